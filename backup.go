@@ -0,0 +1,458 @@
+package dskvs
+
+import (
+	"archive/tar"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// backupManifest is the name of the file describing a backup, at the root of
+// the archive or directory.
+const backupManifest = "dskvs-backup.json"
+
+// A BackupToken identifies the state of a store that a backup holds.  It is
+// given by `BackupSince`, to take an incremental backup on top of it later.
+type BackupToken string
+
+// A manifest describes a backup.  Incremental backups hold the changes made
+// since the backup with token `Base`, and list the files and collections
+// deleted meanwhile.  A restored store keeps the manifest of the last backup
+// it restored.
+type manifest struct {
+	Version string      `json:"version"`
+	Created time.Time   `json:"created"`
+	Token   BackupToken `json:"token,omitempty"`
+	Base    BackupToken `json:"base,omitempty"`
+	Deleted []string    `json:"deleted,omitempty"`
+}
+
+func (m manifest) isIncremental() bool {
+	return m.Base != ""
+}
+
+// A frozenPage is a copy of a page as it was when the store was frozen.
+type frozenPage struct {
+	coll  string
+	seq   uint64
+	aPage *page
+}
+
+// freeze copies every collection and page of the store at one point in
+// time, and returns the number of the last change they hold.  Writers are
+// held back while the pages are copied, but not while they are written
+// anywhere.  Deleted pages and pages without a value yet are left out.
+func (c *collections) freeze() ([]string, []frozenPage, uint64) {
+	c.RLock()
+	defer c.RUnlock()
+
+	var colls []string
+	var frozen []frozenPage
+	var lockedMembers []*member
+	var lockedPages []*page
+	for coll, m := range c.members {
+		colls = append(colls, coll)
+		m.RLock()
+		lockedMembers = append(lockedMembers, m)
+		for _, aPage := range m.entries {
+			aPage.RLock()
+			lockedPages = append(lockedPages, aPage)
+			if aPage.isDeleted || aPage.value == nil {
+				continue
+			}
+			frozen = append(frozen, frozenPage{coll, aPage.seq, &page{
+				basepath: aPage.basepath,
+				coll:     aPage.coll,
+				key:      aPage.key,
+				value:    aPage.value,
+				comp:     aPage.comp,
+				packed:   aPage.packed,
+				keys:     aPage.keys,
+			}})
+		}
+	}
+	// No change can happen while everything is locked
+	seq := c.changes.current()
+	for _, aPage := range lockedPages {
+		aPage.RUnlock()
+	}
+	for _, m := range lockedMembers {
+		m.RUnlock()
+	}
+	return colls, frozen, seq
+}
+
+// A backupSink receives the files of a backup.
+type backupSink interface {
+	collection(coll string) error
+	file(name string, data []byte) error
+}
+
+// backup gives `sink` the pages changed since the backup with token `base`,
+// or every page if `base` is empty, and returns the token of this backup.
+// `chain` tells that it's part of a chain of incremental backups, which a
+// full backup starts over.
+func (s Store) backup(sink backupSink, base BackupToken, chain bool) (BackupToken, error) {
+	changes := s.coll.changes
+	var since uint64
+	if base != "" {
+		var err error
+		if since, err = changes.since(base); err != nil {
+			return "", err
+		}
+	}
+
+	// Deletions made while the pages are copied are recorded already
+	if chain {
+		changes.track()
+	}
+	colls, frozen, seq := s.coll.freeze()
+	meta := manifest{
+		Version: versionString(),
+		Created: time.Now().UTC(),
+		Token:   changes.token(seq),
+		Base:    base,
+	}
+	if base != "" {
+		deleted, ok := changes.deleted(since, seq)
+		if !ok {
+			return "", errorSupersededBackupToken(base)
+		}
+		for _, name := range deleted {
+			meta.Deleted = append(meta.Deleted, filepath.ToSlash(name))
+		}
+		sort.Strings(meta.Deleted)
+	}
+
+	data, err := json.Marshal(meta)
+	if err != nil {
+		return "", err
+	}
+	if err := sink.file(backupManifest, data); err != nil {
+		return "", err
+	}
+
+	for _, coll := range colls {
+		if err := sink.collection(coll); err != nil {
+			return "", err
+		}
+	}
+
+	for _, f := range frozen {
+		if base != "" && f.seq <= since {
+			continue
+		}
+		data, err := fromPageToBytes(f.aPage)
+		if err != nil {
+			return "", err
+		}
+		if err := sink.file(entryName(f.aPage), data); err != nil {
+			return "", err
+		}
+	}
+	if chain && base == "" {
+		changes.restart(seq)
+	}
+	return meta.Token, nil
+}
+
+// Backup writes to `w` a tar archive of every collection and member of the
+// store, as they were at one point in time.  The store keeps serving reads
+// and writes meanwhile, although writers are held back for the short moment
+// it takes to copy the pages in memory.  Page files are archived in the same
+// fileformat as on disk, compressed and encrypted alike.  Use `Restore` to
+// create a store from the archive.
+func (s Store) Backup(w io.Writer) error {
+	_, err := s.writeBackup(w, "", false)
+	return err
+}
+
+// BackupSince writes to `w` a tar archive of the pages changed and deleted
+// since the backup that returned token `since`, and returns the token of this
+// backup.  With an empty token, it writes a full backup like `Backup` does,
+// to start a chain of incremental backups.  `Restore` applies the chain in the
+// same order.
+//
+// Changes are only tracked while the store is open : after it is reopened,
+// tokens given before are stale and the chain must start over with a full
+// backup.  A full backup taken by `BackupSince` also makes the tokens given
+// before it stale, so that the deletions they would need are not kept forever;
+// `Backup` and `BackupTo` leave them alone.
+func (s Store) BackupSince(since BackupToken, w io.Writer) (BackupToken, error) {
+	return s.writeBackup(w, since, true)
+}
+
+// writeBackup writes `backup` to `w` as a tar archive.
+func (s Store) writeBackup(w io.Writer, since BackupToken, chain bool) (BackupToken, error) {
+	tw := tar.NewWriter(w)
+	token, err := s.backup(tarSink{tw, time.Now()}, since, chain)
+	if err != nil {
+		return "", err
+	}
+	return token, tw.Close()
+}
+
+// BackupTo writes a copy of the store to directory `dir`, which must not exist
+// or be empty, like `Backup` does.  The copy can be opened as a store.
+func (s Store) BackupTo(dir string) error {
+	if err := checkEmptyDir(dir); err != nil {
+		return err
+	}
+	_, err := s.backup(dirSink{dir}, "", false)
+	return err
+}
+
+type tarSink struct {
+	tw      *tar.Writer
+	modTime time.Time
+}
+
+func (t tarSink) collection(coll string) error {
+	return t.tw.WriteHeader(&tar.Header{
+		Typeflag: tar.TypeDir,
+		Name:     coll + "/",
+		Mode:     DIR_PERM,
+		ModTime:  t.modTime,
+	})
+}
+
+func (t tarSink) file(name string, data []byte) error {
+	err := t.tw.WriteHeader(&tar.Header{
+		Typeflag: tar.TypeReg,
+		Name:     filepath.ToSlash(name),
+		Mode:     FILE_PERM,
+		Size:     int64(len(data)),
+		ModTime:  t.modTime,
+	})
+	if err != nil {
+		return err
+	}
+	_, err = t.tw.Write(data)
+	return err
+}
+
+type dirSink struct {
+	dir string
+}
+
+func (d dirSink) collection(coll string) error {
+	return os.MkdirAll(filepath.Join(d.dir, coll), DIR_PERM)
+}
+
+func (d dirSink) file(name string, data []byte) error {
+	filename := filepath.Join(d.dir, name)
+	if err := os.MkdirAll(filepath.Dir(filename), DIR_PERM); err != nil {
+		return err
+	}
+	return writeFileAtomic(filename, data)
+}
+
+// Restore creates a store at `path` from an archive written by `Backup`.  The
+// path must not exist or be an empty directory, and must not be used by an
+// open store.  The archive is first extracted next to `path`, then moved in
+// place, so that an interrupted restore leaves nothing at `path`.  Every page
+// file is verified against its checksum on the way.
+//
+// Archives of incremental backups written by `BackupSince` are instead
+// applied to the store at `path`, which must have been restored from the
+// backup they follow : restore a full backup, then every incremental backup
+// of the chain in order.  Should it be interrupted, restore the same
+// incremental backup again.
+func Restore(archive io.Reader, path string) error {
+	if !isValidPath(path) {
+		return errorPathInvalid(path)
+	}
+	basepath := expandPath(path)
+
+	storeExistsLock.Lock()
+	if storeExists[basepath] {
+		storeExistsLock.Unlock()
+		return errorPathInUse(basepath)
+	}
+	storeExists[basepath] = true
+	storeExistsLock.Unlock()
+
+	defer func() {
+		storeExistsLock.Lock()
+		delete(storeExists, basepath)
+		storeExistsLock.Unlock()
+	}()
+
+	tr := tar.NewReader(archive)
+	meta, err := readManifest(tr)
+	if err != nil {
+		return err
+	}
+	if meta.isIncremental() {
+		return applyIncremental(tr, meta, basepath)
+	}
+
+	if err := checkEmptyDir(basepath); err != nil {
+		return err
+	}
+
+	tmpDir := basepath + tempSuffix
+	if err := os.RemoveAll(tmpDir); err != nil {
+		return err
+	}
+	if err := extractBackup(tr, meta, tmpDir); err != nil {
+		_ = os.RemoveAll(tmpDir)
+		return err
+	}
+
+	// An empty directory can be replaced
+	_ = os.Remove(basepath)
+	if err := os.Rename(tmpDir, basepath); err != nil {
+		log.Printf("Couldn't move restored store in place : %v", err)
+		_ = os.RemoveAll(tmpDir)
+		return err
+	}
+	return nil
+}
+
+// applyIncremental applies an incremental backup to the store restored at
+// `basepath`.  Its manifest is replaced last, so that until then, the same
+// backup can be applied again.
+func applyIncremental(tr *tar.Reader, meta manifest, basepath string) error {
+	data, err := ioutil.ReadFile(filepath.Join(basepath, backupManifest))
+	if os.IsNotExist(err) {
+		return errorBrokenBackupChain(meta.Base)
+	} else if err != nil {
+		return err
+	}
+	var last manifest
+	if err := json.Unmarshal(data, &last); err != nil {
+		return errorBadBackupEntry(backupManifest)
+	}
+	if last.Token != meta.Base {
+		return errorBrokenBackupChain(meta.Base)
+	}
+
+	for _, deleted := range meta.Deleted {
+		name := filepath.FromSlash(strings.TrimSuffix(deleted, "/"))
+		if !isBackupEntry(name) || name == backupManifest {
+			return errorBadBackupEntry(deleted)
+		}
+		if err := os.RemoveAll(filepath.Join(basepath, name)); err != nil {
+			return err
+		}
+	}
+	return extractBackup(tr, meta, basepath)
+}
+
+// readManifest reads the manifest, which is the first entry of an archive.
+func readManifest(tr *tar.Reader) (manifest, error) {
+	var meta manifest
+	hdr, err := tr.Next()
+	if err == io.EOF {
+		return meta, errorBadBackupEntry(backupManifest)
+	} else if err != nil {
+		return meta, err
+	}
+	if hdr.Typeflag != tar.TypeReg || hdr.Name != backupManifest {
+		return meta, errorBadBackupEntry(hdr.Name)
+	}
+	data, err := ioutil.ReadAll(tr)
+	if err != nil {
+		return meta, err
+	}
+	if err := json.Unmarshal(data, &meta); err != nil {
+		return meta, errorBadBackupEntry(hdr.Name)
+	}
+	return meta, nil
+}
+
+// extractBackup writes the collections and pages of an archive to `dir`,
+// followed by its manifest.
+func extractBackup(tr *tar.Reader, meta manifest, dir string) error {
+	if err := os.MkdirAll(dir, DIR_PERM); err != nil {
+		return err
+	}
+
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return err
+		}
+
+		name := filepath.FromSlash(strings.TrimSuffix(hdr.Name, "/"))
+		if !isBackupEntry(name) || name == backupManifest {
+			return errorBadBackupEntry(hdr.Name)
+		}
+
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			if err := os.MkdirAll(filepath.Join(dir, name), DIR_PERM); err != nil {
+				return err
+			}
+		case tar.TypeReg:
+			data, err := ioutil.ReadAll(tr)
+			if err != nil {
+				return err
+			}
+			if err := checkBackupPage(name, data); err != nil {
+				return err
+			}
+			if err := (dirSink{dir}).file(name, data); err != nil {
+				return err
+			}
+		default:
+			return errorBadBackupEntry(hdr.Name)
+		}
+	}
+
+	data, err := json.Marshal(meta)
+	if err != nil {
+		return err
+	}
+	return (dirSink{dir}).file(backupManifest, data)
+}
+
+// isBackupEntry tells if `name` is either the manifest, a collection
+// directory or a file within one, collections being possibly nested.
+func isBackupEntry(name string) bool {
+	if name == backupManifest {
+		return true
+	}
+	parts := strings.Split(name, string(filepath.Separator))
+	for _, part := range parts {
+		if part == "" || part == "." || part == ".." {
+			return false
+		}
+	}
+	return true
+}
+
+// checkBackupPage verifies a page file found in a backup.  Encrypted pages
+// can't be decrypted without the keys, but their checksum is verified before
+// that is attempted.
+func checkBackupPage(name string, data []byte) error {
+	_, err := decodePageFile(name, data, nil)
+	if _, encrypted := err.(CryptoError); encrypted {
+		return nil
+	}
+	return err
+}
+
+// checkEmptyDir returns an error unless `dir` doesn't exist or is an empty
+// directory.
+func checkEmptyDir(dir string) error {
+	entries, err := ioutil.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	if len(entries) != 0 {
+		return errorPathNotEmpty(dir)
+	}
+	return nil
+}
@@ -0,0 +1,278 @@
+package dskvs
+
+import (
+	"archive/tar"
+	"bytes"
+	"crypto/rand"
+	"errors"
+	"os"
+	"testing"
+	"testing/iotest"
+)
+
+var backupData = map[string][]byte{
+	"artist/daft_punk": []byte("Discovery"),
+	"artist/justice":   []byte("Cross"),
+	"album/homework":   []byte("1997"),
+}
+
+func fillStore(store *Store, data map[string][]byte, t *testing.T) {
+	for key, value := range data {
+		if err := store.Put(key, value); err != nil {
+			t.Fatalf("Error putting data in, %v", err)
+		}
+	}
+}
+
+func checkStoreHolds(path string, data map[string][]byte, t *testing.T) {
+	store, err := Open(path)
+	if err != nil {
+		t.Fatalf("Error opening store at <%s>, %v", path, err)
+	}
+	defer store.Close()
+
+	for key, expected := range data {
+		actual, ok, err := store.Get(key)
+		if err != nil || !ok {
+			t.Fatalf("Error getting <%s> back, ok=%v, %v", key, ok, err)
+		}
+		if !bytes.Equal(expected, actual) {
+			t.Errorf("Expected <%s> but was <%s>", expected, actual)
+		}
+	}
+	if values, _ := store.GetAll("empty"); len(values) != 0 {
+		t.Errorf("Expected collection empty to be empty, was %v", values)
+	}
+}
+
+func TestBackupAndRestore(t *testing.T) {
+	store := setUp(t)
+	defer tearDown(store, t)
+	fillStore(store, backupData, t)
+	store.Put("empty/gone", []byte("soon"))
+	store.Delete("empty/gone")
+
+	var archive bytes.Buffer
+	if err := store.Backup(&archive); err != nil {
+		t.Fatalf("Error backing up store, %v", err)
+	}
+
+	// Writes after the backup are not in it
+	store.Put("artist/air", []byte("Moon Safari"))
+
+	defer os.RemoveAll("restored")
+	if err := Restore(bytes.NewReader(archive.Bytes()), "restored"); err != nil {
+		t.Fatalf("Error restoring store, %v", err)
+	}
+	checkStoreHolds("restored", backupData, t)
+
+	store2, _ := Open("restored")
+	if _, ok, _ := store2.Get("artist/air"); ok {
+		t.Errorf("Restored store holds a write posterior to the backup")
+	}
+	store2.Close()
+
+	err := Restore(bytes.NewReader(archive.Bytes()), "restored")
+	if _, isRightType := err.(PathError); !isRightType {
+		t.Errorf("Should not restore over an existing store, error was %v",
+			err)
+	}
+}
+
+func TestBackupToDirectory(t *testing.T) {
+	store := setUp(t)
+	defer tearDown(store, t)
+	fillStore(store, backupData, t)
+	store.Put("empty/gone", []byte("soon"))
+	store.Delete("empty/gone")
+
+	defer os.RemoveAll("copy")
+	if err := store.BackupTo("copy"); err != nil {
+		t.Fatalf("Error backing up store, %v", err)
+	}
+	checkStoreHolds("copy", backupData, t)
+}
+
+func TestErrorWhenRestoringUnsafeArchive(t *testing.T) {
+	var archive bytes.Buffer
+	tw := tar.NewWriter(&archive)
+	tw.WriteHeader(&tar.Header{
+		Typeflag: tar.TypeReg,
+		Name:     "../../escaped",
+		Mode:     FILE_PERM,
+		Size:     4,
+	})
+	tw.Write([]byte("evil"))
+	tw.Close()
+
+	defer os.RemoveAll("restored")
+	err := Restore(&archive, "restored")
+	if _, isRightType := err.(FileError); !isRightType {
+		t.Errorf("Should have returned an error of type FileError"+
+			", error was %v",
+			err)
+	}
+	if _, err := os.Stat("restored"); !os.IsNotExist(err) {
+		t.Errorf("Failed restore should leave nothing behind, %v", err)
+	}
+}
+
+func TestIncrementalBackupChain(t *testing.T) {
+	store := setUp(t)
+	defer tearDown(store, t)
+	fillStore(store, backupData, t)
+	store.Put("empty/gone", []byte("soon"))
+
+	var full, first, second bytes.Buffer
+	token, err := store.BackupSince("", &full)
+	if err != nil {
+		t.Fatalf("Error backing up store, %v", err)
+	}
+
+	store.Put("artist/air", []byte("Moon Safari"))
+	store.Delete("artist/justice")
+	store.DeleteAll("empty")
+	token, err = store.BackupSince(token, &first)
+	if err != nil {
+		t.Fatalf("Error taking first incremental backup, %v", err)
+	}
+
+	store.Put("artist/justice", []byte("Audio, Video, Disco"))
+	store.Put("album/homework", []byte("Homework"))
+	if _, err = store.BackupSince(token, &second); err != nil {
+		t.Fatalf("Error taking second incremental backup, %v", err)
+	}
+	if second.Len() >= full.Len() {
+		t.Errorf("Incremental backup is not smaller than a full one")
+	}
+
+	defer os.RemoveAll("restored")
+	// Out of order, the chain is broken
+	err = Restore(bytes.NewReader(second.Bytes()), "restored")
+	if _, isRightType := err.(BackupError); !isRightType {
+		t.Errorf("Should have returned an error of type BackupError"+
+			", error was %v",
+			err)
+	}
+	for _, archive := range []bytes.Buffer{full, first, second} {
+		if err := Restore(bytes.NewReader(archive.Bytes()), "restored"); err != nil {
+			t.Fatalf("Error restoring backup chain, %v", err)
+		}
+	}
+
+	checkStoreHolds("restored", map[string][]byte{
+		"artist/daft_punk": []byte("Discovery"),
+		"artist/air":       []byte("Moon Safari"),
+		"artist/justice":   []byte("Audio, Video, Disco"),
+		"album/homework":   []byte("Homework"),
+	}, t)
+}
+
+func TestErrorWhenBackupTokenIsStale(t *testing.T) {
+	store := setUp(t)
+	fillStore(store, backupData, t)
+
+	var archive bytes.Buffer
+	token, err := store.BackupSince("", &archive)
+	if err != nil {
+		t.Fatalf("Error backing up store, %v", err)
+	}
+	store.Close()
+
+	store, err = Open("./db")
+	if err != nil {
+		t.Fatalf("Error reopening store, %v", err)
+	}
+	defer tearDown(store, t)
+
+	for _, since := range []BackupToken{token, "not a token"} {
+		_, err := store.BackupSince(since, &archive)
+		if _, isRightType := err.(BackupError); !isRightType {
+			t.Errorf("Should have returned an error of type BackupError"+
+				", error was %v",
+				err)
+		}
+	}
+}
+
+func TestTombstonesAreOnlyKeptForBackups(t *testing.T) {
+	store := setUp(t)
+	defer tearDown(store, t)
+	changes := store.coll.changes
+
+	for i := 0; i < 100; i++ {
+		store.Put("jobs/job", []byte("work"))
+		store.Delete("jobs/job")
+	}
+	if len(changes.tombstones) != 0 {
+		t.Errorf("Expected no tombstone without backups, had %d",
+			len(changes.tombstones))
+	}
+
+	var archive bytes.Buffer
+	token, err := store.BackupSince("", &archive)
+	if err != nil {
+		t.Fatalf("Error backing up store, %v", err)
+	}
+	store.Put("jobs/job", []byte("work"))
+	store.Delete("jobs/job")
+	if len(changes.tombstones) != 1 {
+		t.Errorf("Expected a tombstone after a backup, had %d",
+			len(changes.tombstones))
+	}
+
+	// A full backup drops them, and the chains before it
+	if _, err := store.BackupSince("", &archive); err != nil {
+		t.Fatalf("Error backing up store, %v", err)
+	}
+	if len(changes.tombstones) != 0 {
+		t.Errorf("Expected no tombstone after a full backup, had %d",
+			len(changes.tombstones))
+	}
+	_, err = store.BackupSince(token, &archive)
+	if _, isRightType := err.(BackupError); !isRightType {
+		t.Errorf("Should have returned an error of type BackupError"+
+			", error was %v",
+			err)
+	}
+}
+
+func TestOtherBackupsKeepTheChain(t *testing.T) {
+	store := setUp(t)
+	defer tearDown(store, t)
+	fillStore(store, backupData, t)
+
+	var archive bytes.Buffer
+	token, err := store.BackupSince("", &archive)
+	if err != nil {
+		t.Fatalf("Error backing up store, %v", err)
+	}
+
+	store.Put("artist/air", []byte("Moon Safari"))
+	if err := store.Backup(&archive); err != nil {
+		t.Fatalf("Error backing up store, %v", err)
+	}
+	store.Delete("artist/air")
+	defer os.RemoveAll("copy")
+	if err := store.BackupTo("copy"); err != nil {
+		t.Fatalf("Error backing up store, %v", err)
+	}
+
+	if _, err := store.BackupSince(token, &archive); err != nil {
+		t.Errorf("Expected the chain to go on after other backups, %v", err)
+	}
+}
+
+func TestErrorWhenChangesCantBeNumbered(t *testing.T) {
+	reader := rand.Reader
+	rand.Reader = iotest.ErrReader(errors.New("no entropy"))
+	_, err := Open("./db")
+	rand.Reader = reader
+	if err == nil {
+		t.Fatalf("Should have failed to open without random bytes")
+	}
+
+	// The path isn't held by the store that failed to open
+	store := setUp(t)
+	tearDown(store, t)
+}
@@ -0,0 +1,237 @@
+package dskvs
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// A changeLog numbers the changes made to a store since it was opened, so that
+// incremental backups can tell which pages changed since a previous backup.
+// Every page remembers the number of its last change, and deleted pages and
+// collections leave a tombstone with theirs, once a backup was taken.  Numbers
+// start over every time a store is opened, under a new epoch.
+//
+// Snapshots also see the store as it was after some change.  While they live,
+// pages keep the versions that they can see, and deleted pages are kept in a
+// graveyard.
+type changeLog struct {
+	epoch string
+	seq   uint64
+	// live counts the snapshots, so that writers don't lock the change
+	// log when there are none.
+	live int64
+
+	sync.Mutex
+	// tracking is set once a backup was taken, since only incremental
+	// backups need tombstones.  floor is the number of the last full
+	// backup : those before are stale, and so are their tombstones.
+	tracking   bool
+	floor      uint64
+	tombstones map[string]uint64
+	snapshots  map[uint64]int
+	graveyard  []grave
+}
+
+// A grave holds a page deleted by change `seq`.
+type grave struct {
+	seq   uint64
+	aPage *page
+}
+
+func newChangeLog() (*changeLog, error) {
+	epoch := make([]byte, 8)
+	if _, err := io.ReadFull(rand.Reader, epoch); err != nil {
+		return nil, err
+	}
+	return &changeLog{
+		epoch:      hex.EncodeToString(epoch),
+		tombstones: make(map[string]uint64),
+		snapshots:  make(map[uint64]int),
+	}, nil
+}
+
+// next numbers a new change.
+func (l *changeLog) next() uint64 {
+	if l == nil {
+		return 0
+	}
+	return atomic.AddUint64(&l.seq, 1)
+}
+
+// current is the number of the last change.
+func (l *changeLog) current() uint64 {
+	return atomic.LoadUint64(&l.seq)
+}
+
+// tombstone records that `name`, a page file or a collection directory
+// relative to the store, was deleted by change `seq`.
+func (l *changeLog) tombstone(name string, seq uint64) {
+	if l == nil {
+		return
+	}
+	l.Lock()
+	if l.tracking {
+		l.tombstones[name] = seq
+	}
+	l.Unlock()
+}
+
+// track has tombstones recorded from now on, before a backup is taken.
+func (l *changeLog) track() {
+	l.Lock()
+	l.tracking = true
+	l.Unlock()
+}
+
+// restart drops the tombstones of the changes up to `seq`, that of a full
+// backup, since incremental backups start from it instead.
+func (l *changeLog) restart(seq uint64) {
+	l.Lock()
+	defer l.Unlock()
+	if seq <= l.floor {
+		return
+	}
+	l.floor = seq
+	for name, deletedBy := range l.tombstones {
+		if deletedBy <= seq {
+			delete(l.tombstones, name)
+		}
+	}
+}
+
+// deleted lists what was deleted by the changes after `since` up to `until`.
+// It tells if it could, which it can't once a full backup was taken after
+// `since`.
+func (l *changeLog) deleted(since, until uint64) ([]string, bool) {
+	l.Lock()
+	defer l.Unlock()
+	if since < l.floor {
+		return nil, false
+	}
+	var names []string
+	for name, seq := range l.tombstones {
+		if seq > since && seq <= until {
+			names = append(names, name)
+		}
+	}
+	return names, true
+}
+
+// snapshot registers a snapshot of the store after the last change, and
+// returns its number.
+func (l *changeLog) snapshot() uint64 {
+	l.Lock()
+	// Counted before the number is taken : a writer that misses the
+	// snapshot made its change before, and the snapshot sees it
+	atomic.AddInt64(&l.live, 1)
+	seq := l.current()
+	l.snapshots[seq]++
+	l.Unlock()
+	return seq
+}
+
+// release unregisters a snapshot, and buries the pages that no snapshot
+// can see anymore.
+func (l *changeLog) release(seq uint64) {
+	l.Lock()
+	defer l.Unlock()
+	if l.snapshots[seq]--; l.snapshots[seq] <= 0 {
+		delete(l.snapshots, seq)
+	}
+	atomic.AddInt64(&l.live, -1)
+	oldest, ok := l.oldestSnapshot()
+	var kept []grave
+	for _, g := range l.graveyard {
+		if ok && oldest < g.seq {
+			kept = append(kept, g)
+		}
+	}
+	l.graveyard = kept
+}
+
+// needed returns the number of the oldest live snapshot, and tells if it is
+// older than change `seq`, so that it can see what the change replaced.
+func (l *changeLog) needed(seq uint64) (uint64, bool) {
+	if l == nil || atomic.LoadInt64(&l.live) == 0 {
+		return 0, false
+	}
+	l.Lock()
+	defer l.Unlock()
+	oldest, ok := l.oldestSnapshot()
+	return oldest, ok && oldest < seq
+}
+
+// oldestSnapshot returns the number of the oldest live snapshot.  The change
+// log must be locked.
+func (l *changeLog) oldestSnapshot() (uint64, bool) {
+	var oldest uint64
+	found := false
+	for seq := range l.snapshots {
+		if !found || seq < oldest {
+			oldest, found = seq, true
+		}
+	}
+	return oldest, found
+}
+
+// bury keeps a page deleted by change `seq` for the snapshots that can still
+// see it.
+func (l *changeLog) bury(aPage *page, seq uint64) {
+	l.Lock()
+	l.graveyard = append(l.graveyard, grave{seq, aPage})
+	l.Unlock()
+}
+
+// buried returns the deleted pages of collection `coll` still kept, or of
+// every collection if `coll` is empty.
+func (l *changeLog) buried(coll string) []*page {
+	l.Lock()
+	defer l.Unlock()
+	var pages []*page
+	for _, g := range l.graveyard {
+		if coll == "" || g.aPage.coll == coll {
+			pages = append(pages, g.aPage)
+		}
+	}
+	return pages
+}
+
+// token identifies the state of the store after change `seq`.
+func (l *changeLog) token(seq uint64) BackupToken {
+	return BackupToken(fmt.Sprintf("%s:%d", l.epoch, seq))
+}
+
+// since returns the change number held by `token`, which must have been
+// given by this change log.
+func (l *changeLog) since(token BackupToken) (uint64, error) {
+	parts := strings.SplitN(string(token), ":", 2)
+	if len(parts) != 2 {
+		return 0, errorBadBackupToken(token)
+	}
+	seq, err := strconv.ParseUint(parts[1], 10, 64)
+	if err != nil {
+		return 0, errorBadBackupToken(token)
+	}
+	if parts[0] != l.epoch || seq > l.current() {
+		return 0, errorStaleBackupToken(token)
+	}
+	l.Lock()
+	floor := l.floor
+	l.Unlock()
+	if seq < floor {
+		return 0, errorSupersededBackupToken(token)
+	}
+	return seq, nil
+}
+
+// entryName is the name of the file of a page, relative to the store.
+func entryName(aPage *page) string {
+	return filepath.Join(aPage.coll, filepath.Base(generateFilename(aPage)))
+}
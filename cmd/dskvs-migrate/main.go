@@ -0,0 +1,78 @@
+/*
+Command dskvs-migrate rewrites the page files of a dskvs store in the
+fileformat of the version of dskvs it was built with.
+
+Usage:
+
+	dskvs-migrate [-compression none|deflate|gzip] <path>
+
+Stores with encrypted files are given their key, hex encoded, in the
+environment variable DSKVS_ENCRYPTION_KEY, so that it doesn't show in the
+list of processes.  The compression is that of the store, which the migrated
+files are written with.
+
+The store must not be opened by another process while it is migrated.  An
+interrupted migration can be run again: files already migrated are skipped.
+*/
+package main
+
+import (
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/aybabtme/dskvs"
+)
+
+// keyEnv is the environment variable holding the encryption key of the store.
+const keyEnv = "DSKVS_ENCRYPTION_KEY"
+
+var compressors = map[string]dskvs.Compressor{
+	"none":    nil,
+	"deflate": dskvs.Deflate,
+	"gzip":    dskvs.Gzip,
+}
+
+func main() {
+	compression := flag.String("compression", "none",
+		"compression of the store: none, deflate or gzip")
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: %s [flags] <path>\n", os.Args[0])
+		flag.PrintDefaults()
+		fmt.Fprintf(os.Stderr, "  $%s\n    \thex encoded encryption key of the store\n", keyEnv)
+	}
+	flag.Parse()
+
+	if flag.NArg() != 1 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	var opts dskvs.Options
+	c, ok := compressors[*compression]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "unknown compression <%s>\n", *compression)
+		flag.Usage()
+		os.Exit(2)
+	}
+	opts.Compression = c
+
+	if encoded := os.Getenv(keyEnv); encoded != "" {
+		key, err := hex.DecodeString(encoded)
+		if err != nil {
+			log.Fatalf("Invalid key in $%s: %v", keyEnv, err)
+		}
+		opts.EncryptionKey = key
+	}
+
+	path := flag.Arg(0)
+	n, err := dskvs.MigrateWith(path, opts)
+	if err != nil {
+		log.Fatalf("Migrated %d files before failing: %v", n, err)
+	}
+	fmt.Printf("Migrated %d files of store <%s> to fileformat %d.%d.%d\n",
+		n, path,
+		dskvs.MajorVersion, dskvs.MinorVersion, dskvs.PatchVersion)
+}
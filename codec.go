@@ -0,0 +1,66 @@
+package dskvs
+
+import (
+	"bytes"
+	"encoding/gob"
+	"encoding/json"
+)
+
+// A Codec encodes the values of a `TypedCollection` to the bytes that the
+// store holds, and decodes them back.
+type Codec[T any] interface {
+	Encode(value T) ([]byte, error)
+	Decode(data []byte) (T, error)
+}
+
+// RawCodec holds values as they are, for typed collections of bytes.
+var RawCodec Codec[[]byte] = rawCodec{}
+
+// JSONCodec encodes values with `encoding/json`.
+func JSONCodec[T any]() Codec[T] {
+	return jsonCodec[T]{}
+}
+
+// GobCodec encodes values with `encoding/gob`.  Every value is encoded on its
+// own, with the description of its type.
+func GobCodec[T any]() Codec[T] {
+	return gobCodec[T]{}
+}
+
+type rawCodec struct{}
+
+func (rawCodec) Encode(value []byte) ([]byte, error) {
+	return value, nil
+}
+
+func (rawCodec) Decode(data []byte) ([]byte, error) {
+	return data, nil
+}
+
+type jsonCodec[T any] struct{}
+
+func (jsonCodec[T]) Encode(value T) ([]byte, error) {
+	return json.Marshal(value)
+}
+
+func (jsonCodec[T]) Decode(data []byte) (T, error) {
+	var value T
+	err := json.Unmarshal(data, &value)
+	return value, err
+}
+
+type gobCodec[T any] struct{}
+
+func (gobCodec[T]) Encode(value T) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(value); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (gobCodec[T]) Decode(data []byte) (T, error) {
+	var value T
+	err := gob.NewDecoder(bytes.NewReader(data)).Decode(&value)
+	return value, err
+}
@@ -1,20 +1,78 @@
 package dskvs
 
 import (
+	"path/filepath"
 	"sync"
 )
 
 type collections struct {
 	sync.RWMutex
-	basepath string
-	members  map[string]*member
+	basepath  string
+	opts      *Options
+	changes   *changeLog
+	histories *histories
+	bin       *trashBin
+	members   map[string]*member
+	// indexSets hold the indexes of every collection that had a member
+	// or an index, by collection.
+	indexLock sync.Mutex
+	indexSets map[string]*indexSet
+	// queues hold what the queues of a name share.
+	queueLock sync.Mutex
+	queues    map[string]*queueState
 }
 
-func newCollections(basepath string) *collections {
+func newCollections(basepath string, opts *Options) (*collections, error) {
+	changes, err := newChangeLog()
+	if err != nil {
+		return nil, err
+	}
+	var bin *trashBin
+	if opts.Trash > 0 {
+		bin = newTrashBin()
+	}
 	return &collections{
-		basepath: basepath,
-		members:  make(map[string]*member),
+		basepath:  basepath,
+		opts:      opts,
+		changes:   changes,
+		histories: newHistories(),
+		bin:       bin,
+		members:   make(map[string]*member),
+		indexSets: make(map[string]*indexSet),
+		queues:    make(map[string]*queueState),
+	}, nil
+}
+
+// newMember prepares a member for collection `coll`, configured as the
+// options of the store say.  It isn't added to the collections.
+func (c *collections) newMember(coll string) *member {
+	m := newMember(c.basepath, coll)
+	m.comp = c.opts.compressionFor(coll)
+	m.keys = c.opts.keyProvider()
+	m.changes = c.changes
+	m.hist = c.opts.historyFor(coll)
+	m.histories = c.histories
+	m.bin = c.bin
+	m.indexes = c.indexSet(coll)
+	return m
+}
+
+func (c *collections) member(coll string) (*member, bool) {
+	c.RLock()
+	m, ok := c.members[coll]
+	c.RUnlock()
+	return m, ok
+}
+
+// snapshot returns the members of every collection, as they are now.
+func (c *collections) snapshot() map[string]*member {
+	c.RLock()
+	members := make(map[string]*member, len(c.members))
+	for coll, m := range c.members {
+		members[coll] = m
 	}
+	c.RUnlock()
+	return members
 }
 
 func (c *collections) get(coll, key string) ([]byte, bool) {
@@ -41,7 +99,48 @@ func (c *collections) getCollection(coll string) [][]byte {
 	return m.getMembers()
 }
 
-func (c *collections) put(coll, key string, value []byte) {
+func (c *collections) put(coll, key string, value []byte) error {
+	return c.memberOrNew(coll).put(key, value)
+}
+
+// putNew puts the value only if the key has none yet, and tells if it did.
+func (c *collections) putNew(coll, key string, value []byte) (bool, error) {
+	return c.memberOrNew(coll).putNew(key, value)
+}
+
+// update replaces the value of the key, nil if it has none, with what `fn`
+// makes of it, as one change.
+func (c *collections) update(coll, key string, fn func(old []byte) ([]byte, error)) error {
+	for {
+		m := c.memberOrNew(coll)
+		aPage := m.pageOrNew(key)
+		done, err := aPage.update(fn, false)
+		if err != nil {
+			m.dropEmpty(aPage)
+		}
+		if done || err != nil {
+			return err
+		}
+		// It was deleted meanwhile, so update the page that replaces it
+	}
+}
+
+// updateExisting is `update` for a key that holds a value : it tells if it
+// did, and doesn't create the key if it has none.
+func (c *collections) updateExisting(coll, key string, fn func(old []byte) ([]byte, error)) (bool, error) {
+	m, ok := c.member(coll)
+	if !ok {
+		return false, nil
+	}
+	aPage, ok := m.page(key)
+	if !ok {
+		return false, nil
+	}
+	return aPage.update(fn, true)
+}
+
+// memberOrNew returns the member of collection `coll`, creating it if needed.
+func (c *collections) memberOrNew(coll string) *member {
 	c.RLock()
 	m, ok := c.members[coll]
 	c.RUnlock()
@@ -55,15 +154,17 @@ func (c *collections) put(coll, key string, value []byte) {
 		c.Lock()
 		m, ok = c.members[coll]
 		if !ok {
-			m = newMember(c.basepath, coll)
-			c.members[coll] = m
+			added := c.addMember(coll)
+			m = added[len(added)-1]
 			c.Unlock()
-			jan.createFolder(m)
+			for _, aMember := range added {
+				jan.createFolder(aMember)
+			}
 		} else {
 			c.Unlock()
 		}
 	}
-	m.put(key, value)
+	return m
 }
 
 func (c *collections) deleteKey(coll, key string) error {
@@ -80,24 +181,51 @@ func (c *collections) deleteKey(coll, key string) error {
 	return nil
 }
 
+// deleteIf deletes the key only if `cond` holds, as `member.deleteIf` says,
+// and tells if it did.
+func (c *collections) deleteIf(coll, key string, cond func(value []byte, seq uint64) bool) bool {
+	m, ok := c.member(coll)
+	if !ok {
+		return false
+	}
+	return m.deleteIf(key, cond)
+}
+
+// deleteCollection deletes collection `coll` and, with nested collections,
+// the collections within it.
 func (c *collections) deleteCollection(coll string) {
 	c.RLock()
-	_, ok := c.members[coll]
+	ok := len(c.subtree(coll)) != 0
 	c.RUnlock()
 
 	if ok {
+		// The collections and their pages are deleted by one change, at
+		// once, so that snapshots see all or none of it
 		c.Lock()
-		m, ok := c.members[coll]
-		delete(c.members, coll)
+		members := c.subtree(coll)
+		var dirty []*page
+		if len(members) != 0 {
+			seq := c.changes.next()
+			for _, m := range members {
+				delete(c.members, m.coll)
+				m.leave()
+				c.changes.tombstone(m.coll+string(filepath.Separator), seq)
+				dirty = append(dirty, m.deleteAll(seq)...)
+			}
+		}
 		c.Unlock()
 		// Was deleted in between our read-lock and the current write-lock
-		if !ok {
+		if len(members) == 0 {
 			return
 		}
 
 		// TODO : This is not really necessary, can just delete the folder
 		// at once and save some IO.
-		m.deleteAll()
-		jan.deleteFolder(m)
+		for _, aPage := range dirty {
+			jan.writePage(aPage)
+		}
+		for _, m := range members {
+			jan.deleteFolder(m)
+		}
 	}
 }
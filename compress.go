@@ -0,0 +1,194 @@
+package dskvs
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"io"
+	"io/ioutil"
+	"log"
+	"math"
+	"sync"
+)
+
+// A Compressor compresses the values of a store before they are written to
+// disk and, optionally, while they are held in memory.
+type Compressor interface {
+	// ID identifies the compression algorithm in page files.  It must be
+	// unique among the registered compressors and can't be 0, which means
+	// no compression.
+	ID() uint8
+	Compress(value []byte) ([]byte, error)
+	Decompress(compressed []byte) ([]byte, error)
+}
+
+var (
+	// Deflate compresses values with the DEFLATE algorithm of
+	// `compress/flate`, at its default level.
+	Deflate Compressor = deflateCompressor{}
+	// Gzip compresses values in the gzip format of `compress/gzip`, at its
+	// default level.
+	Gzip Compressor = gzipCompressor{}
+
+	compressorsLock sync.RWMutex
+	compressors     = map[uint8]Compressor{
+		Deflate.ID(): Deflate,
+		Gzip.ID():    Gzip,
+	}
+)
+
+// RegisterCompressor makes a compressor known to dskvs, so that it can read the
+// page files that it compressed.  Compressors must be registered before the
+// stores that use them are opened.
+func RegisterCompressor(c Compressor) error {
+	compressorsLock.Lock()
+	defer compressorsLock.Unlock()
+	if _, taken := compressors[c.ID()]; taken || c.ID() == 0 {
+		return errorCompressorID(c.ID())
+	}
+	compressors[c.ID()] = c
+	return nil
+}
+
+func compressorByID(id uint8) (Compressor, bool) {
+	compressorsLock.RLock()
+	c, ok := compressors[id]
+	compressorsLock.RUnlock()
+	return c, ok
+}
+
+// compression says how the values of a collection are compressed.  A nil
+// compression doesn't compress anything.
+type compression struct {
+	c        Compressor
+	minSize  int
+	inMemory bool
+}
+
+// shouldPack tells if a value of `size` bytes is worth compressing.
+func (comp *compression) shouldPack(size int) bool {
+	return comp != nil && size >= comp.minSize
+}
+
+// keepsPacked tells if a value of `size` bytes is held compressed in memory.
+func (comp *compression) keepsPacked(size int) bool {
+	return comp.shouldPack(size) && comp.inMemory
+}
+
+// pack compresses `value`, and reports false if that didn't make it smaller.
+func (comp *compression) pack(value []byte) ([]byte, bool) {
+	packed, err := comp.c.Compress(value)
+	if err != nil {
+		log.Printf("Couldn't compress value, storing it as is : %v", err)
+		return value, false
+	}
+	if len(packed) >= len(value) {
+		return value, false
+	}
+	return packed, true
+}
+
+func (comp *compression) unpack(packed []byte) []byte {
+	value, err := comp.c.Decompress(packed)
+	if err != nil {
+		log.Printf("Couldn't decompress value held in memory : %v", err)
+		return nil
+	}
+	return value
+}
+
+// A streamCompressor is a Compressor that decompresses as it's read, so that
+// the reader can stop at any point.
+type streamCompressor interface {
+	reader(compressed []byte) (io.ReadCloser, error)
+}
+
+// decompress decompresses the payload of file `filename` with `c`, and fails
+// if it's longer than `length`.  The compressors of dskvs stop right there,
+// others are only checked once done.
+func decompress(filename string, c Compressor, compressed []byte, length uint64) ([]byte, error) {
+	var value []byte
+	var err error
+	if sc, ok := c.(streamCompressor); ok {
+		var r io.ReadCloser
+		if r, err = sc.reader(compressed); err == nil {
+			// One more byte tells that it's too long
+			limit := length
+			if limit < ^uint64(0) {
+				limit++
+			}
+			if limit > math.MaxInt64 {
+				limit = math.MaxInt64
+			}
+			value, err = ioutil.ReadAll(io.LimitReader(r, int64(limit)))
+			r.Close()
+		}
+	} else {
+		value, err = c.Decompress(compressed)
+	}
+	if err != nil {
+		return nil, errorDecompressing(filename, err)
+	}
+	if uint64(len(value)) > length {
+		return nil, errorValueTooLong(filename, length)
+	}
+	return value, nil
+}
+
+type deflateCompressor struct{}
+
+func (deflateCompressor) ID() uint8 { return 1 }
+
+func (deflateCompressor) Compress(value []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	w, err := flate.NewWriter(&buf, flate.DefaultCompression)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := w.Write(value); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (c deflateCompressor) Decompress(compressed []byte) ([]byte, error) {
+	r, _ := c.reader(compressed)
+	defer r.Close()
+	return ioutil.ReadAll(r)
+}
+
+func (deflateCompressor) reader(compressed []byte) (io.ReadCloser, error) {
+	return flate.NewReader(bytes.NewReader(compressed)), nil
+}
+
+type gzipCompressor struct{}
+
+func (gzipCompressor) ID() uint8 { return 2 }
+
+func (gzipCompressor) Compress(value []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	w := gzip.NewWriter(&buf)
+	if _, err := w.Write(value); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (c gzipCompressor) Decompress(compressed []byte) ([]byte, error) {
+	r, err := c.reader(compressed)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	return ioutil.ReadAll(r)
+}
+
+func (gzipCompressor) reader(compressed []byte) (io.ReadCloser, error) {
+	return gzip.NewReader(bytes.NewReader(compressed))
+}
@@ -0,0 +1,108 @@
+package dskvs
+
+import (
+	"bytes"
+	"io/ioutil"
+	"strings"
+	"testing"
+)
+
+func compressibleValue() []byte {
+	return []byte(strings.Repeat(`{"artist":"Daft Punk","quality":"epic"}`, 100))
+}
+
+func fileHeaderOf(store *Store, coll, key string, t *testing.T) *fileHeader {
+	m, _ := store.coll.member(coll)
+	data, err := ioutil.ReadFile(generateFilename(m.entries[key]))
+	if err != nil {
+		t.Fatalf("Couldn't read page file, %v", err)
+	}
+	header, err := headerFromBytes(data)
+	if err != nil {
+		t.Fatalf("Couldn't read page header, %v", err)
+	}
+	return header
+}
+
+func TestCompressedValuesPersist(t *testing.T) {
+	for _, c := range []Compressor{Deflate, Gzip} {
+		opts := Options{
+			Compression:           c,
+			CollectionCompression: map[string]Compressor{"raw": nil},
+			CompressMinSize:       64,
+			CompressInMemory:      true,
+		}
+
+		store, _, err := OpenWith("./db", opts)
+		if err != nil {
+			t.Fatalf("Error opening store, %v", err)
+		}
+
+		big := compressibleValue()
+		small := []byte("Alive")
+		for key, value := range map[string][]byte{
+			"artist/big":   big,
+			"artist/small": small,
+			"raw/big":      big,
+		} {
+			if err := store.Put(key, value); err != nil {
+				t.Fatalf("Error putting data in, %v", err)
+			}
+		}
+
+		m, _ := store.coll.member("artist")
+		if !m.entries["big"].packed {
+			t.Errorf("Big value should be compressed in memory")
+		}
+		if m.entries["small"].packed {
+			t.Errorf("Small value should not be compressed in memory")
+		}
+		actual, _, _ := store.Get("artist/big")
+		if !bytes.Equal(actual, big) {
+			t.Errorf("Expected to get back the uncompressed value")
+		}
+
+		if err := store.Close(); err != nil {
+			t.Fatalf("Error closing store, %v", err)
+		}
+
+		// Reading doesn't depend on the options
+		store = setUp(t)
+
+		expected := map[string]uint8{
+			"artist/big":   c.ID(),
+			"artist/small": 0,
+			"raw/big":      0,
+		}
+		for fullKey, compression := range expected {
+			coll, key := splitKeys(fullKey)
+			header := fileHeaderOf(store, coll, key, t)
+			if header.Compression != compression {
+				t.Errorf("Expected <%s> compressed with %d but was %d",
+					fullKey, compression, header.Compression)
+			}
+		}
+		if header := fileHeaderOf(store, "artist", "big", t); header.PayloadLength >= uint64(len(big)) {
+			t.Errorf("Compressed payload should be smaller, was %d bytes",
+				header.PayloadLength)
+		}
+
+		for _, key := range []string{"artist/big", "raw/big"} {
+			actual, ok, err := store.Get(key)
+			if err != nil || !ok {
+				t.Fatalf("Error getting <%s> back, ok=%v, %v", key, ok, err)
+			}
+			if !bytes.Equal(actual, big) {
+				t.Errorf("Value of <%s> didn't survive compression", key)
+			}
+		}
+
+		tearDown(store, t)
+	}
+}
+
+func TestErrorWhenRegisteringCompressorTwice(t *testing.T) {
+	if err := RegisterCompressor(Gzip); err == nil {
+		t.Errorf("Should not register two compressors with the same ID")
+	}
+}
@@ -301,3 +301,46 @@ func generateKeyValueList(kvCount int64, t *testing.T) []keyValue {
 
 	return kvList
 }
+
+func TestConcurrentIncrAreNotLost(t *testing.T) {
+
+	store := setUp(t)
+	defer tearDown(store, t)
+	defer runtime.GOMAXPROCS(runtime.GOMAXPROCS(runtime.NumCPU()))
+
+	counters := 8
+	var wg sync.WaitGroup
+	for i := 0; i < goroutines; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := int64(0); j < kvCount; j++ {
+				key := coll + CollKeySep + strconv.Itoa(int(j)%counters)
+				var err error
+				if i%2 == 0 {
+					_, err = store.Incr(key, 3)
+				} else {
+					_, err = store.Decr(key, 1)
+				}
+				if err != nil {
+					t.Errorf("Error updating counter %s, %v", key, err)
+					return
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	total := int64(0)
+	for i := 0; i < counters; i++ {
+		n, _, err := store.GetInt(coll + CollKeySep + strconv.Itoa(i))
+		if err != nil {
+			t.Fatalf("Error reading counter, %v", err)
+		}
+		total += n
+	}
+	expected := int64(goroutines/2)*kvCount*3 - int64(goroutines/2)*kvCount
+	if total != expected {
+		t.Errorf("Expected counters to sum to %d but was %d", expected, total)
+	}
+}
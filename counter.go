@@ -0,0 +1,72 @@
+package dskvs
+
+import (
+	"math"
+	"strconv"
+)
+
+// Incr adds `delta` to the integer held by member `fullKey`, or to zero if it
+// holds nothing, and returns the result, as one change.  Integers are held in
+// decimal, as `strconv.FormatInt` writes them, so that `Get` and `Put` read
+// and write them too.  It fails if the member holds something else, or if the
+// result would overflow an int64.
+func (s Store) Incr(fullKey string, delta int64) (int64, error) {
+
+	if err := s.coll.checkKey(fullKey); err != nil {
+		return 0, err
+	}
+
+	if s.coll.isCollectionKey(fullKey) {
+		return 0, errorPutIsColl(fullKey, "")
+	}
+
+	coll, key := s.coll.splitKeys(fullKey)
+
+	var result int64
+	err := s.coll.update(coll, key, func(old []byte) ([]byte, error) {
+		var n int64
+		if old != nil {
+			var err error
+			if n, err = parseInt(fullKey, old); err != nil {
+				return nil, err
+			}
+		}
+		if (delta > 0 && n > math.MaxInt64-delta) || (delta < 0 && n < math.MinInt64-delta) {
+			return nil, errorOverflow(fullKey)
+		}
+		result = n + delta
+		return strconv.AppendInt(nil, result, 10), nil
+	})
+	return result, err
+}
+
+// Decr subtracts `delta` from the integer held by member `fullKey`, like
+// `Incr`.
+func (s Store) Decr(fullKey string, delta int64) (int64, error) {
+	if delta == math.MinInt64 {
+		return 0, errorOverflow(fullKey)
+	}
+	return s.Incr(fullKey, -delta)
+}
+
+// GetInt returns the integer held by member `fullKey`, as `Incr` writes it.
+// If the member doesn't exist in the store, ok will be false.
+func (s Store) GetInt(fullKey string) (int64, bool, error) {
+	val, ok, err := s.Get(fullKey)
+	if err != nil || !ok {
+		return 0, ok, err
+	}
+	n, err := parseInt(fullKey, val)
+	if err != nil {
+		return 0, false, err
+	}
+	return n, true, nil
+}
+
+func parseInt(fullKey string, value []byte) (int64, error) {
+	n, err := strconv.ParseInt(string(value), 10, 64)
+	if err != nil {
+		return 0, errorNotInteger(fullKey)
+	}
+	return n, nil
+}
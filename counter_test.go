@@ -0,0 +1,61 @@
+package dskvs
+
+import (
+	"math"
+	"testing"
+)
+
+func TestIncrDecrAndGetInt(t *testing.T) {
+	store := setUp(t)
+
+	if n, err := store.Incr("counter/visits", 5); err != nil || n != 5 {
+		t.Errorf("Expected 5 from a new counter, was %d, %v", n, err)
+	}
+	if n, err := store.Decr("counter/visits", 7); err != nil || n != -2 {
+		t.Errorf("Expected -2, was %d, %v", n, err)
+	}
+	if val, _, _ := store.Get("counter/visits"); string(val) != "-2" {
+		t.Errorf("Expected counter held in decimal, was <%s>", val)
+	}
+	store.Put("counter/likes", []byte("41"))
+	store.Incr("counter/likes", 1)
+	if err := store.Close(); err != nil {
+		t.Fatalf("Error closing store, %v", err)
+	}
+
+	store = setUp(t)
+	defer func() { tearDown(store, t) }()
+	if n, ok, err := store.GetInt("counter/likes"); err != nil || !ok || n != 42 {
+		t.Errorf("Expected 42 after reopening, was %d, %v, %v", n, ok, err)
+	}
+	if _, ok, err := store.GetInt("counter/shares"); ok || err != nil {
+		t.Errorf("Expected no counter nor error, was %v, %v", ok, err)
+	}
+}
+
+func TestErrorWhenIncrementingNonInteger(t *testing.T) {
+	store := setUp(t)
+	defer tearDown(store, t)
+
+	store.Put("counter/visits", []byte("many"))
+	_, err := store.Incr("counter/visits", 1)
+	if _, isRightType := err.(ValueError); !isRightType {
+		t.Errorf("Should have returned an error of type ValueError"+
+			", error was %v",
+			err)
+	}
+	if val, _, _ := store.Get("counter/visits"); string(val) != "many" {
+		t.Errorf("Expected value left as it was, was <%s>", val)
+	}
+
+	store.Incr("counter/likes", math.MaxInt64)
+	_, err = store.Incr("counter/likes", 1)
+	if _, isRightType := err.(ValueError); !isRightType {
+		t.Errorf("Should have returned an error of type ValueError"+
+			", error was %v",
+			err)
+	}
+	if n, _, _ := store.GetInt("counter/likes"); n != math.MaxInt64 {
+		t.Errorf("Expected counter left as it was, was %d", n)
+	}
+}
@@ -0,0 +1,174 @@
+package dskvs
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"io"
+	"log"
+)
+
+// nonceSize is the size of the AES-GCM nonces kept in page headers.
+const nonceSize = 12
+
+// A KeyProvider gives the AES keys used to encrypt page files at rest.  Keys
+// must be 16, 24 or 32 bytes long, to select AES-128, AES-192 or AES-256.
+// Every key has an ID, written in the header of the files it encrypted, so
+// that a provider can rotate keys while still decrypting older files.
+type KeyProvider interface {
+	// CurrentKey returns the key with which to encrypt new files, and its
+	// ID.  IDs can't be 0, which means a file is not encrypted.
+	CurrentKey() (uint32, []byte, error)
+	// Key returns the key with the given ID.
+	Key(id uint32) ([]byte, error)
+}
+
+// A KeyRing is a KeyProvider holding its keys in memory.
+type KeyRing struct {
+	// Current is the ID of the key with which new files are encrypted.
+	Current uint32
+	Keys    map[uint32][]byte
+}
+
+// CurrentKey returns the key with ID `Current`.
+func (r KeyRing) CurrentKey() (uint32, []byte, error) {
+	key, err := r.Key(r.Current)
+	return r.Current, key, err
+}
+
+// Key returns the key with the given ID.
+func (r KeyRing) Key(id uint32) ([]byte, error) {
+	key, ok := r.Keys[id]
+	if !ok || id == 0 {
+		return nil, errorNoSuchKeyID(id)
+	}
+	return key, nil
+}
+
+// newGCM prepares an AES-GCM cipher with the key `id` of `keys`.
+func newGCM(keys KeyProvider, id uint32) (cipher.AEAD, error) {
+	key, err := keys.Key(id)
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, errorBadKey(id, err)
+	}
+	return cipher.NewGCM(block)
+}
+
+// checkKeyProvider makes sure that `keys` can encrypt files.
+func checkKeyProvider(keys KeyProvider) error {
+	id, _, err := keys.CurrentKey()
+	if err != nil {
+		return err
+	}
+	if id == 0 {
+		return errorNoSuchKeyID(id)
+	}
+	_, err = newGCM(keys, id)
+	return err
+}
+
+// seal encrypts the payload of the page with key `pageKey` using the current
+// key of `keys`.  The page key and the header of the file are authenticated
+// along, so that a payload can't be moved to the file of another page, nor be
+// read with another compression or length.
+func seal(keys KeyProvider, pageKey string, payload *pagePayload) error {
+	id, _, err := keys.CurrentKey()
+	if err != nil {
+		return err
+	}
+	gcm, err := newGCM(keys, id)
+	if err != nil {
+		return err
+	}
+	if _, err := io.ReadFull(rand.Reader, payload.nonce[:]); err != nil {
+		return err
+	}
+	payload.keyID = id
+	header := payloadHeader(pageKey, *payload,
+		uint64(len(payload.data)+gcm.Overhead()))
+	payload.data = gcm.Seal(nil, payload.nonce[:], payload.data,
+		additionalData(pageKey, header))
+	return nil
+}
+
+// unseal decrypts a payload sealed with additional data `ad`.
+func unseal(keys KeyProvider, ad []byte, payload *pagePayload) error {
+	gcm, err := newGCM(keys, payload.keyID)
+	if err != nil {
+		return err
+	}
+	plain, err := gcm.Open(nil, payload.nonce[:], payload.data, ad)
+	if err != nil {
+		return err
+	}
+	payload.data = plain
+	payload.keyID = 0
+	return nil
+}
+
+// additionalData is what `seal` authenticates along a payload : the header of
+// its file, then the page key.  The nonce is left out, and so is the checksum,
+// which is that of the sealed payload.
+func additionalData(pageKey string, header *fileHeader) []byte {
+	authenticated := *header
+	authenticated.Nonce = [nonceSize]byte{}
+	authenticated.Checksum = 0
+	w := new(bytes.Buffer)
+	// Writing a fixed size struct to a buffer can't fail
+	_ = binary.Write(w, binary.BigEndian, &authenticated)
+	w.WriteString(pageKey)
+	return w.Bytes()
+}
+
+// sealsHeader tells if the files of fileformat `version` authenticate their
+// header with their payload.  Before 0.9, only the page key was.
+func sealsHeader(version formatVersion) bool {
+	return version.Major > 0 || version.Minor >= 9
+}
+
+// RotateKeys has the janitor re-encrypt, in the background, every page file
+// that isn't encrypted with the current key of the KeyProvider of the store.
+// Call it after the provider changed its current key.  Stores also do so on
+// their own when they are opened.  `Close` waits for the re-encryption to
+// complete.
+func (s *Store) RotateKeys() error {
+	if s == nil {
+		return errorStoreNotLoaded()
+	}
+	keys := s.opts.keyProvider()
+	if keys == nil {
+		return errorStoreNotEncrypted()
+	}
+	if err := checkKeyProvider(keys); err != nil {
+		return err
+	}
+	jan.background(s, s.reencrypt)
+	return nil
+}
+
+// reencrypt marks dirty every page whose file is not encrypted with the
+// current key, so that the janitor rewrites it.
+func (s *Store) reencrypt() {
+	current, _, err := s.opts.keyProvider().CurrentKey()
+	if err != nil {
+		log.Printf("Can't re-encrypt page files : %v", err)
+		return
+	}
+
+	for _, m := range s.coll.snapshot() {
+		for _, aPage := range m.pages() {
+			aPage.RLock()
+			stale := aPage.keyID != current && !aPage.isDirty
+			aPage.RUnlock()
+			if stale {
+				aPage.touch()
+			}
+		}
+	}
+}
@@ -0,0 +1,181 @@
+package dskvs
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io/ioutil"
+	"testing"
+)
+
+var (
+	firstKey  = []byte("0123456789abcdef0123456789abcdef")
+	secondKey = []byte("fedcba9876543210fedcba9876543210")
+)
+
+func TestEncryptedPageFiles(t *testing.T) {
+	key := "customer/jane_doe"
+	expected := []byte(`{"email":"jane@example.com"}`)
+
+	store, _, err := OpenWith("./db", Options{EncryptionKey: firstKey})
+	if err != nil {
+		t.Fatalf("Error opening store, %v", err)
+	}
+	if err := store.Put(key, expected); err != nil {
+		t.Fatalf("Error putting data in, %v", err)
+	}
+	if err := store.Close(); err != nil {
+		t.Fatalf("Error closing store, %v", err)
+	}
+
+	filename := generateFilename(&page{
+		basepath: store.storagePath,
+		coll:     "customer",
+		key:      "jane_doe",
+	})
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		t.Fatalf("Couldn't read page file, %v", err)
+	}
+	if bytes.Contains(data, expected) {
+		t.Errorf("Page file holds the value in clear")
+	}
+	header, _ := headerFromBytes(data)
+	if header.KeyID != 1 {
+		t.Errorf("Expected file encrypted with key 1 but was %d", header.KeyID)
+	}
+
+	for _, opts := range []Options{{}, {EncryptionKey: secondKey}} {
+		_, _, err := OpenWith("./db", opts)
+		if _, isRightType := err.(CryptoError); !isRightType {
+			t.Errorf("Should have failed to open with a CryptoError, "+
+				"error was %v", err)
+		}
+	}
+
+	store, _, err = OpenWith("./db", Options{EncryptionKey: firstKey})
+	if err != nil {
+		t.Fatalf("Error opening store, %v", err)
+	}
+	defer tearDown(store, t)
+
+	actual, ok, err := store.Get(key)
+	if err != nil || !ok {
+		t.Fatalf("Error getting data back, ok=%v, %v", ok, err)
+	}
+	if !bytes.Equal(actual, expected) {
+		t.Errorf("Expected <%s> but was <%s>", expected, actual)
+	}
+}
+
+func TestErrorWhenEncryptionKeyIsInvalid(t *testing.T) {
+	_, _, err := OpenWith("./db", Options{EncryptionKey: []byte("too short")})
+	if _, isRightType := err.(CryptoError); !isRightType {
+		t.Errorf("Should have returned an error of type CryptoError"+
+			", error was %v",
+			err)
+	}
+}
+
+func TestKeyRotationReencryptsFiles(t *testing.T) {
+	keys := KeyRing{1, map[uint32][]byte{1: firstKey}}
+	store, _, err := OpenWith("./db", Options{KeyProvider: keys})
+	if err != nil {
+		t.Fatalf("Error opening store, %v", err)
+	}
+	for _, key := range []string{"customer/jane", "customer/john"} {
+		if err := store.Put(key, []byte(key)); err != nil {
+			t.Fatalf("Error putting data in, %v", err)
+		}
+	}
+	if err := store.Close(); err != nil {
+		t.Fatalf("Error closing store, %v", err)
+	}
+
+	// Opening with a new current key rewrites every file with it
+	keys = KeyRing{2, map[uint32][]byte{1: firstKey, 2: secondKey}}
+	store, _, err = OpenWith("./db", Options{KeyProvider: keys})
+	if err != nil {
+		t.Fatalf("Error opening store, %v", err)
+	}
+	if err := store.Close(); err != nil {
+		t.Fatalf("Error closing store, %v", err)
+	}
+
+	keys = KeyRing{2, map[uint32][]byte{2: secondKey}}
+	store, _, err = OpenWith("./db", Options{KeyProvider: keys})
+	if err != nil {
+		t.Fatalf("Files should all use the new key, %v", err)
+	}
+	defer tearDown(store, t)
+
+	if err := store.RotateKeys(); err != nil {
+		t.Errorf("Error rotating keys, %v", err)
+	}
+	if val, ok, _ := store.Get("customer/john"); !ok || string(val) != "customer/john" {
+		t.Errorf("Expected value to survive rotation, was <%s>", val)
+	}
+}
+
+func TestErrorWhenEncryptedHeaderIsTampered(t *testing.T) {
+	keys := KeyRing{1, map[uint32][]byte{1: firstKey}}
+	aPage := newPage("./db", "customer", "jane_doe")
+	aPage.comp = &compression{c: Deflate}
+	aPage.keys = keys
+	aPage.value = bytes.Repeat([]byte("jane@example.com "), 20)
+	data, _, err := encodePage(aPage)
+	if err != nil {
+		t.Fatalf("Couldn't encode page, %v", err)
+	}
+	if _, err := decodePageFile(generateFilename(aPage), data, keys); err != nil {
+		t.Fatalf("Couldn't decode page, %v", err)
+	}
+
+	for name, tamper := range map[string]func(header *fileHeader){
+		"compression":  func(header *fileHeader) { header.Compression = 0 },
+		"value length": func(header *fileHeader) { header.ValueLength++ },
+	} {
+		header, _ := headerFromBytes(data)
+		tamper(header)
+		headerBytes, _ := headerToBytes(header)
+		tampered := append(headerBytes, data[fileHeaderSize:]...)
+		_, err := decodePageFile(generateFilename(aPage), tampered, keys)
+		if _, isRightType := err.(CryptoError); !isRightType {
+			t.Errorf("Should have returned an error of type CryptoError"+
+				" for a tampered %s, error was %v",
+				name, err)
+		}
+	}
+}
+
+func TestReadEncryptedPageOf08(t *testing.T) {
+	keys := KeyRing{1, map[uint32][]byte{1: firstKey}}
+	expected := []byte(`{"email":"jane@example.com"}`)
+	key := "jane_doe"
+
+	// Fileformat 0.8 only authenticated the page key
+	gcm, err := newGCM(keys, 1)
+	if err != nil {
+		t.Fatalf("Couldn't prepare cipher, %v", err)
+	}
+	header := fileHeader08{
+		Magic:         fileMagic,
+		Minor:         8,
+		KeyID:         1,
+		KeyNameLength: uint64(len(key)),
+	}
+	sealed := gcm.Seal(nil, header.Nonce[:], expected, []byte(key))
+	header.Checksum = payloadChecksum(sealed)
+	header.PayloadLength = uint64(len(sealed))
+	buf := new(bytes.Buffer)
+	binary.Write(buf, binary.BigEndian, &header)
+	buf.WriteString(key)
+	buf.Write(sealed)
+
+	aPage, err := decodePageFile("db/customer/jane_doe", buf.Bytes(), keys)
+	if err != nil {
+		t.Fatalf("Couldn't decode 0.8 page, %v", err)
+	}
+	if !bytes.Equal(aPage.value, expected) {
+		t.Errorf("Expected <%s> but was <%s>", expected, aPage.value)
+	}
+}
@@ -0,0 +1,87 @@
+package dskvs
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// DiskvOptions tell `ImportDiskv` where to load the files of a diskv store.
+type DiskvOptions struct {
+	// Collection receives a member for every file.
+	Collection string
+	// KeyTransform gives the key of the member of a file, from the path of
+	// the file relative to the diskv base directory.  Files for which it
+	// returns false are left out.  The default is the base name of the
+	// file, which is the key it was written with by diskv.
+	KeyTransform func(path string) (string, bool)
+	// OnConflict tells what to do with members that already have a value.
+	OnConflict Conflict
+}
+
+// A DiskvReport counts the members loaded by `ImportDiskv`, and lists the
+// files that couldn't be.
+type DiskvReport struct {
+	ImportReport
+	// Errors lists the files that couldn't be loaded, and why.
+	Errors []FileReport
+}
+
+// ImportDiskv walks `basedir`, the base path of a diskv store, and loads every
+// file it finds in a member of collection `opts.Collection`.  Whatever the
+// transform that diskv used to spread files in directories, the key of a file
+// is its name, unless `opts.KeyTransform` says otherwise.  Files that can't be
+// read or don't make a valid key are reported and the import goes on, but a
+// conflict with `Fail` stops it with a KeyError.
+func (s Store) ImportDiskv(basedir string, opts DiskvOptions) (DiskvReport, error) {
+	var report DiskvReport
+
+	if err := s.coll.checkCollName(opts.Collection); err != nil {
+		return report, err
+	}
+	transform := opts.KeyTransform
+	if transform == nil {
+		transform = func(path string) (string, bool) {
+			return filepath.Base(path), true
+		}
+	}
+
+	err := filepath.Walk(basedir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			report.Errors = append(report.Errors, FileReport{path, err})
+			if info != nil && info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+
+		rel, err := filepath.Rel(basedir, path)
+		if err != nil {
+			return err
+		}
+		key, ok := transform(rel)
+		if !ok {
+			return nil
+		}
+		fullKey := opts.Collection + CollKeySep + key
+		if err := s.coll.checkKey(fullKey); err != nil {
+			report.Errors = append(report.Errors, FileReport{path, err})
+			return nil
+		}
+		if s.coll.isCollectionKey(fullKey) {
+			report.Errors = append(report.Errors, FileReport{path, errorEmptyKey()})
+			return nil
+		}
+
+		value, err := ioutil.ReadFile(path)
+		if err != nil {
+			report.Errors = append(report.Errors, FileReport{path, err})
+			return nil
+		}
+		return s.importMember(fullKey, value, opts.OnConflict, &report.ImportReport)
+	})
+	return report, err
+}
@@ -0,0 +1,70 @@
+package dskvs
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func writeDiskvFile(path string, data string, t *testing.T) {
+	if err := os.MkdirAll(filepath.Dir(path), DIR_PERM); err != nil {
+		t.Fatalf("Couldn't create diskv directory, %v", err)
+	}
+	if err := ioutil.WriteFile(path, []byte(data), FILE_PERM); err != nil {
+		t.Fatalf("Couldn't write diskv file, %v", err)
+	}
+}
+
+func TestImportDiskv(t *testing.T) {
+	defer os.RemoveAll("diskv")
+	writeDiskvFile("diskv/daft_punk.txt", "Discovery", t)
+	writeDiskvFile("diskv/ju/st/justice.txt", "Cross", t)
+	writeDiskvFile("diskv/air.txt", "Moon Safari", t)
+	writeDiskvFile("diskv/.txt", "no key", t)
+	writeDiskvFile("diskv/.DS_Store", "junk", t)
+
+	store := setUp(t)
+	defer tearDown(store, t)
+	store.Put("artist/air", []byte("Talkie Walkie"))
+
+	report, err := store.ImportDiskv("diskv", DiskvOptions{
+		Collection: "artist",
+		KeyTransform: func(path string) (string, bool) {
+			name := filepath.Base(path)
+			if !strings.HasSuffix(name, ".txt") {
+				return "", false
+			}
+			return strings.TrimSuffix(name, ".txt"), true
+		},
+		OnConflict: Skip,
+	})
+	if err != nil {
+		t.Fatalf("Error importing diskv, %v", err)
+	}
+	if report.Imported != 2 || report.Skipped != 1 || len(report.Errors) != 1 {
+		t.Errorf("Expected 2 imported, 1 skipped and 1 error, was %+v", report)
+	}
+
+	expected := map[string]string{
+		"artist/daft_punk": "Discovery",
+		"artist/justice":   "Cross",
+		"artist/air":       "Talkie Walkie",
+	}
+	for key, value := range expected {
+		if actual, _, _ := store.Get(key); string(actual) != value {
+			t.Errorf("Expected <%s> at %s but was <%s>", value, key, actual)
+		}
+	}
+
+	for _, coll := range []string{"", "artist/air", ".quarantine"} {
+		_, err := store.ImportDiskv("diskv", DiskvOptions{Collection: coll})
+		if _, isRightType := err.(KeyError); !isRightType {
+			t.Errorf("%q: should have returned an error of type KeyError"+
+				", error was %v",
+				coll,
+				err)
+		}
+	}
+}
@@ -29,7 +29,42 @@ Example:
 
 
 A fullkey can contain many CollKeySep; only the first encountered is considered
-for the collection name.
+for the collection name.  Unless the store is opened with `Options.Nested`, in
+which case every segment but the last is a collection within the previous
+one, with a directory of its own:
+
+	fullkey := "artist" + CollKeySep + "Daft Punk" + CollKeySep + "Discovery.."
+
+	collection := "artist" + CollKeySep + "Daft Punk"
+	member     := "Discovery.."
+
+Collection keys then end with CollKeySep, like "artist/Daft Punk/", unless
+they have a single segment.  `GetAll` works at any level, `DeleteAll` deletes
+the collections within too, and `Collections` lists them.
+
+The separator is part of neither the collection nor the member.  `ParseKey`
+and `NewKey` give a `Key` holding both, and verify that it is at most
+MaxKeyLength bytes long and holds no NUL byte, as every operation does.
+
+`Store.Collection` returns a handle on a collection, whose methods take the
+member alone and skip looking the collection up again on every call.
+`NewTypedCollection` wraps one to hold values of a Go type, encoded by a
+`Codec` such as `JSONCodec`, `GobCodec` or `RawCodec`.
+
+`CreateIndex` indexes the members of a collection by the terms that a function
+extracts from their value, for `Lookup` to find them without a scan.  Indexes
+live in memory; `Options.Indexes` builds them again when the store is opened.
+`Query` selects members holding JSON by their fields, using the indexes made
+by `CreateFieldIndex` when it can; `Explain` tells how it would run a query.
+Collections listed in `Options.FullText` keep a full-text index of their
+values, kept on disk while the store is closed, which `Search` ranks by BM25.
+
+`Incr` and `Decr` update integers held in decimal as one change, without a
+lock of your own, and `GetInt` reads them.
+Lists (`LPush`, `RPop`, `LRange`), sets (`SAdd`, `SMembers`, `SIsMember`) and
+hashes (`HSet`, `HGet`) are held by a member likewise, in a binary format.
+`Queue` returns a durable FIFO queue backed by a collection : `Dequeue` hides
+the messages it returns until they are acked, and waits for new ones.
 
 Every entry of dskvs is saved as a file under a path.  If you tell dskvs to use
 the base path "$HOME/dskvs", it will prepare a filename for your key
@@ -12,9 +12,9 @@ const (
 	// MinorVersion is used to differentiate between fileformat versions. It might
 	// be used for migrations if a future change to dskvs breaks the original
 	// fileformat contract
-	MinorVersion uint16 = 4
+	MinorVersion uint16 = 9
 	// PatchVersion is used for the same reasons as MinorVersion
-	PatchVersion uint64 = 2
+	PatchVersion uint64 = 0
 )
 
 var (
@@ -41,6 +41,10 @@ func init() {
 type Store struct {
 	storagePath string
 	coll        *collections
+	opts        Options
+	scrub       *scrubber
+	prune       *pruner
+	tasks       *sync.WaitGroup
 }
 
 /*
@@ -52,12 +56,26 @@ type Store struct {
 // empty store.
 //
 // Every entry file is checked for consistency with a SHA1 checksum.  A file
-// that is not consistent will be ignored, a log message emitted and an error
-// returned.  This call will block until all collections have been replenished.
+// that is not consistent will be ignored and a log message emitted.  Use
+// `OpenWith` to learn which files were ignored and why.  This call will block
+// until all collections have been replenished.
 func Open(path string) (*Store, error) {
+	s, _, err := OpenWith(path, Options{})
+	return s, err
+}
+
+// OpenWith is like Open, but configures the store with `opts` and returns a
+// report of what happened to every file found under `path`.
+func OpenWith(path string, opts Options) (*Store, *OpenReport, error) {
 
 	if !isValidPath(path) {
-		return nil, errorPathInvalid(path)
+		return nil, nil, errorPathInvalid(path)
+	}
+
+	if keys := opts.keyProvider(); keys != nil {
+		if err := checkKeyProvider(keys); err != nil {
+			return nil, nil, err
+		}
 	}
 
 	basepath := expandPath(path)
@@ -66,27 +84,63 @@ func Open(path string) (*Store, error) {
 	exists := storeExists[basepath]
 	if exists {
 		storeExistsLock.RUnlock()
-		return nil, errorPathInUse(basepath)
+		return nil, nil, errorPathInUse(basepath)
 	}
 	storeExistsLock.RUnlock()
 
 	storeExistsLock.Lock()
 	if !exists && storeExists[basepath] {
 		storeExistsLock.Unlock()
-		return nil, errorPathInUse(basepath)
+		return nil, nil, errorPathInUse(basepath)
 	}
 	storeExists[basepath] = true
 	storeExistsLock.Unlock()
 
-	s := &Store{basepath, newCollections(basepath)}
+	s := &Store{
+		storagePath: basepath,
+		opts:        opts,
+		tasks:       new(sync.WaitGroup),
+	}
+	coll, err := newCollections(basepath, &s.opts)
+	if err != nil {
+		storeExistsLock.Lock()
+		delete(storeExists, basepath)
+		storeExistsLock.Unlock()
+		return nil, nil, err
+	}
+	s.coll = coll
+	if opts.ScrubRate > 0 {
+		s.scrub = newScrubber(opts.ScrubRate)
+	}
+	if len(opts.History) != 0 || opts.Trash > 0 {
+		s.prune = newPruner()
+	}
 
-	err := jan.loadStore(s)
+	indexes, err := s.addIndexes()
 	if err != nil {
-		return nil, err
+		storeExistsLock.Lock()
+		delete(storeExists, basepath)
+		storeExistsLock.Unlock()
+		return nil, nil, err
+	}
+
+	report := new(OpenReport)
+	err = jan.loadStore(s, report)
+	if err != nil {
+		storeExistsLock.Lock()
+		delete(storeExists, basepath)
+		storeExistsLock.Unlock()
+		return nil, nil, err
 	}
 	jan.run()
+	jan.scrub(s)
+	jan.prune(s)
+	s.fillIndexes(indexes)
+	if opts.keyProvider() != nil {
+		jan.background(s, s.reencrypt)
+	}
 
-	return s, nil
+	return s, report, nil
 
 }
 
@@ -104,13 +158,16 @@ func (s *Store) Close() error {
 	storeExistsLock.Unlock()
 
 	err := jan.unloadStore(s)
-	s = nil
 	if err != nil {
+		s = nil
 		jan.die()
 		return err
 	}
 
-	return nil
+	// Nothing changes the full-text indexes anymore
+	err = s.saveTextIndexes()
+	s = nil
+	return err
 }
 
 // Get returns the value referenced by the `fullKey` given in argument. A
@@ -126,15 +183,15 @@ func (s *Store) Close() error {
 // you.
 func (s Store) Get(fullKey string) ([]byte, bool, error) {
 
-	if err := checkKeyValid(fullKey); err != nil {
+	if err := s.coll.checkKey(fullKey); err != nil {
 		return nil, false, err
 	}
 
-	if isCollectionKey(fullKey) {
+	if s.coll.isCollectionKey(fullKey) {
 		return nil, false, errorGetIsColl(fullKey)
 	}
 
-	coll, key := splitKeys(fullKey)
+	coll, key := s.coll.splitKeys(fullKey)
 
 	val, ok := s.coll.get(coll, key)
 	return val, ok, nil
@@ -146,15 +203,15 @@ func (s Store) Get(fullKey string) ([]byte, bool, error) {
 // you.
 func (s Store) GetAll(coll string) ([][]byte, error) {
 
-	if err := checkKeyValid(coll); err != nil {
+	if err := s.coll.checkKey(coll); err != nil {
 		return nil, err
 	}
 
-	if !isCollectionKey(coll) {
+	if !s.coll.isCollectionKey(coll) {
 		return nil, errorGetAllIsNotColl(coll)
 	}
 
-	return s.coll.getCollection(coll), nil
+	return s.coll.getCollection(s.coll.collName(coll)), nil
 }
 
 // Put saves the given value into the key location.  `fullKey` should be a
@@ -163,32 +220,31 @@ func (s Store) GetAll(coll string) ([][]byte, error) {
 // collection and call `Put` on each member.
 func (s Store) Put(fullKey string, value []byte) error {
 
-	if err := checkKeyValid(fullKey); err != nil {
+	if err := s.coll.checkKey(fullKey); err != nil {
 		return err
 	}
 
-	if isCollectionKey(fullKey) {
+	if s.coll.isCollectionKey(fullKey) {
 		return errorPutIsColl(fullKey, string(value))
 	}
 
-	coll, key := splitKeys(fullKey)
+	coll, key := s.coll.splitKeys(fullKey)
 
-	s.coll.put(coll, key, value)
-	return nil
+	return s.coll.put(coll, key, value)
 }
 
 // Delete removes member with `fullKey` from the storage.
 func (s Store) Delete(fullKey string) error {
 
-	if err := checkKeyValid(fullKey); err != nil {
+	if err := s.coll.checkKey(fullKey); err != nil {
 		return err
 	}
 
-	if isCollectionKey(fullKey) {
+	if s.coll.isCollectionKey(fullKey) {
 		return errorDeleteIsColl(fullKey)
 	}
 
-	coll, key := splitKeys(fullKey)
+	coll, key := s.coll.splitKeys(fullKey)
 
 	return s.coll.deleteKey(coll, key)
 }
@@ -196,15 +252,15 @@ func (s Store) Delete(fullKey string) error {
 // DeleteAll removes all the members in collection `coll`
 func (s Store) DeleteAll(coll string) error {
 
-	if err := checkKeyValid(coll); err != nil {
+	if err := s.coll.checkKey(coll); err != nil {
 		return err
 	}
 
-	if !isCollectionKey(coll) {
+	if !s.coll.isCollectionKey(coll) {
 		return errorDeleteAllIsNotColl(coll)
 	}
 
-	s.coll.deleteCollection(coll)
+	s.coll.deleteCollection(s.coll.collName(coll))
 
 	return nil
 }
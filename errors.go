@@ -20,6 +20,18 @@ func errorStoreNotLoaded() error {
 	}
 }
 
+func errorStoreNoTrash() error {
+	return StoreError{
+		"Store has no Trash",
+	}
+}
+
+func errorStoreNotEncrypted() error {
+	return StoreError{
+		"Store has no EncryptionKey nor KeyProvider",
+	}
+}
+
 // A FileError is returned when a file that was read failed to return
 // expected data
 type FileError struct {
@@ -38,6 +50,22 @@ func errorFailedChecksum(name string) error {
 	}
 }
 
+func errorHeaderTooShort(name string, expected uint64, actual int) error {
+	return FileError{
+		fmt.Sprintf("Header should have length %d but file has only %d bytes",
+			expected, actual),
+		name,
+	}
+}
+
+func errorKeyWrongSize(name string, expected, actual uint64) error {
+	return FileError{
+		fmt.Sprintf("Key should have length %d but only %d bytes follow the header",
+			expected, actual),
+		name,
+	}
+}
+
 func errorPayloadWrongSize(name string, expected uint64, actual int) error {
 	return FileError{
 		fmt.Sprintf("Payload should have length %d but was %d",
@@ -54,6 +82,35 @@ func errorWrongVersion(major, minor uint16, patch uint64) error {
 	}
 }
 
+func errorUnknownVersion(name string, version formatVersion) error {
+	return FileError{
+		fmt.Sprintf("No decoder for fileformat version %v", version),
+		name,
+	}
+}
+
+func errorUnknownCompressor(name string, id uint8) error {
+	return FileError{
+		fmt.Sprintf("No compressor registered with ID %d", id),
+		name,
+	}
+}
+
+func errorValueTooLong(name string, expected uint64) error {
+	return FileError{
+		fmt.Sprintf("Payload decompresses to more than the %d bytes the header says",
+			expected),
+		name,
+	}
+}
+
+func errorDecompressing(name string, err error) error {
+	return FileError{
+		fmt.Sprintf("Error decompressing payload, received error <%v>", err),
+		name,
+	}
+}
+
 func errorCreatingHeader(name string, err error) error {
 	return FileError{
 		fmt.Sprintf("Error creating header, received error <%v>", err),
@@ -61,6 +118,298 @@ func errorCreatingHeader(name string, err error) error {
 	}
 }
 
+func errorBadBackupEntry(name string) error {
+	return FileError{
+		"Unexpected entry in backup archive",
+		name,
+	}
+}
+
+func errorBadHistory(name string) error {
+	return FileError{
+		"History file holds malformed revisions",
+		name,
+	}
+}
+
+func errorBadTextIndex(name string) error {
+	return FileError{
+		"Full-text index file is malformed",
+		name,
+	}
+}
+
+func errorIrregularFile(name string) error {
+	return FileError{
+		"Not a regular file",
+		name,
+	}
+}
+
+func errorUnfinishedWrite(name string) error {
+	return FileError{
+		"Temporary file of a write that didn't complete",
+		name,
+	}
+}
+
+// A CryptoError is returned when page files can't be encrypted or decrypted,
+// most likely because a key is wrong or missing.
+type CryptoError struct {
+	What     string
+	KeyID    uint32
+	Filename string
+}
+
+func (e CryptoError) Error() string {
+	if e.Filename == "" {
+		return fmt.Sprintf("%v, key id=%d", e.What, e.KeyID)
+	}
+	return fmt.Sprintf("%v, key id=%d, file=%s", e.What, e.KeyID, e.Filename)
+}
+
+func errorNoSuchKeyID(id uint32) error {
+	return CryptoError{
+		"No encryption key with this ID",
+		id,
+		"",
+	}
+}
+
+func errorBadKey(id uint32, err error) error {
+	return CryptoError{
+		fmt.Sprintf("Encryption key is not a valid AES key, received error <%v>", err),
+		id,
+		"",
+	}
+}
+
+func errorNoKeyProvider(name string, id uint32) error {
+	return CryptoError{
+		"File is encrypted but the store has no KeyProvider",
+		id,
+		name,
+	}
+}
+
+func errorDecrypting(name string, id uint32, err error) error {
+	if cryptoErr, ok := err.(CryptoError); ok {
+		cryptoErr.Filename = name
+		return cryptoErr
+	}
+	return CryptoError{
+		"Can't decrypt file, the key is wrong",
+		id,
+		name,
+	}
+}
+
+// A CompressorError is returned when a Compressor can't be registered.
+type CompressorError struct {
+	What string
+	ID   uint8
+}
+
+func (e CompressorError) Error() string {
+	return fmt.Sprintf("%v, id=%d", e.What, e.ID)
+}
+
+func errorCompressorID(id uint8) error {
+	return CompressorError{
+		"Compressor ID is 0 or already registered",
+		id,
+	}
+}
+
+// A BackupError is returned when an incremental backup can't be taken or
+// restored from the token it refers to.  When the token is stale, take a full
+// backup to start a new chain.
+type BackupError struct {
+	What  string
+	Token BackupToken
+}
+
+func (e BackupError) Error() string {
+	return fmt.Sprintf("%v, token=%v", e.What, e.Token)
+}
+
+func errorBadBackupToken(token BackupToken) error {
+	return BackupError{
+		"Not a backup token",
+		token,
+	}
+}
+
+func errorSupersededBackupToken(token BackupToken) error {
+	return BackupError{
+		"Backup token is older than the last full backup of this Store",
+		token,
+	}
+}
+
+func errorStaleBackupToken(token BackupToken) error {
+	return BackupError{
+		"Backup token doesn't come from this Store since it was opened",
+		token,
+	}
+}
+
+func errorBrokenBackupChain(token BackupToken) error {
+	return BackupError{
+		"Incremental backup doesn't follow the last restored backup",
+		token,
+	}
+}
+
+// An ImportError is returned when a record can't be imported.  Line is the
+// number of the record in the stream.
+type ImportError struct {
+	What string
+	Line int
+}
+
+func (e ImportError) Error() string {
+	return fmt.Sprintf("%v, line=%d", e.What, e.Line)
+}
+
+func errorBadRecord(line int, err error) error {
+	return ImportError{
+		fmt.Sprintf("Bad record : %v", err),
+		line,
+	}
+}
+
+func errorUnknownEncoding(line int, encoding string) error {
+	return ImportError{
+		fmt.Sprintf("Unknown value encoding %q", encoding),
+		line,
+	}
+}
+
+// A CodecError is returned when the value of a typed collection can't be
+// encoded, or the bytes of a member decoded.
+type CodecError struct {
+	What string
+	Key  string
+}
+
+func (e CodecError) Error() string {
+	return fmt.Sprintf("%v, key=%s", e.What, e.Key)
+}
+
+func errorEncoding(key string, err error) error {
+	return CodecError{
+		fmt.Sprintf("Can't encode value : %v", err),
+		key,
+	}
+}
+
+func errorDecoding(key string, err error) error {
+	return CodecError{
+		fmt.Sprintf("Can't decode value : %v", err),
+		key,
+	}
+}
+
+// An IndexError is returned when an index can't be created or used, or when
+// a unique index refuses a value.
+type IndexError struct {
+	What  string
+	Coll  string
+	Index string
+}
+
+func (e IndexError) Error() string {
+	return fmt.Sprintf("%v, collection=%s, index=%s", e.What, e.Coll, e.Index)
+}
+
+func errorBadIndex(coll, name string) error {
+	return IndexError{
+		"Index has no name, or neither extractor nor field",
+		coll,
+		name,
+	}
+}
+
+func errorIndexExists(coll, name string) error {
+	return IndexError{
+		"Index already exists",
+		coll,
+		name,
+	}
+}
+
+func errorNoSuchIndex(coll, name string) error {
+	return IndexError{
+		"No such index",
+		coll,
+		name,
+	}
+}
+
+func errorNoTextIndex(coll string) error {
+	return IndexError{
+		"Collection keeps no full-text index",
+		coll,
+		textIndexName,
+	}
+}
+
+func errorUniqueTerm(coll, name, term, key string) error {
+	return IndexError{
+		fmt.Sprintf("Term %q is already held by member <%s>", term, key),
+		coll,
+		name,
+	}
+}
+
+// A QueryError is returned when a query can't be run as it is.
+type QueryError struct {
+	What string
+}
+
+func (e QueryError) Error() string {
+	return e.What
+}
+
+func errorBadQuery(what string) error {
+	return QueryError{
+		fmt.Sprintf("Bad query : %s", what),
+	}
+}
+
+// A ValueError is returned when the value of a member is not what an
+// operation expects.
+type ValueError struct {
+	What string
+	Key  string
+}
+
+func (e ValueError) Error() string {
+	return fmt.Sprintf("%v, key=%s", e.What, e.Key)
+}
+
+func errorNotInteger(key string) error {
+	return ValueError{
+		"Value is not a decimal integer",
+		key,
+	}
+}
+
+func errorWrongKind(key, kind string) error {
+	return ValueError{
+		fmt.Sprintf("Value is not a %s", kind),
+		key,
+	}
+}
+
+func errorOverflow(key string) error {
+	return ValueError{
+		"Integer would overflow",
+		key,
+	}
+}
+
 // A PathError is returned when the path you provided is not suitable
 // for storage, either because of its intrisic nature or because it is
 // already in use by another storage.  In the latter case, you should
@@ -82,6 +431,13 @@ func errorPathInUse(path string) error {
 	}
 }
 
+func errorPathNotEmpty(path string) error {
+	return PathError{
+		"Path is not an empty directory",
+		path,
+	}
+}
+
 func errorPathInvalid(path string) error {
 	return PathError{
 		"String is not a valid path",
@@ -112,6 +468,13 @@ func errorNoColl(key string) error {
 	}
 }
 
+func errorReservedColl(key string) error {
+	return KeyError{
+		"collection identifier is reserved by dskvs",
+		key,
+	}
+}
+
 func errorEmptyKey() error {
 	return KeyError{
 		"key is empty",
@@ -119,6 +482,20 @@ func errorEmptyKey() error {
 	}
 }
 
+func errorKeyTooLong(key string) error {
+	return KeyError{
+		fmt.Sprintf("key is longer than %d bytes", MaxKeyLength),
+		key,
+	}
+}
+
+func errorForbiddenByte(key string) error {
+	return KeyError{
+		"key holds a forbidden byte",
+		key,
+	}
+}
+
 func errorNoSuchKey(key string) error {
 	return KeyError{
 		"key holds no value in this store",
@@ -126,6 +503,34 @@ func errorNoSuchKey(key string) error {
 	}
 }
 
+func errorKeyExists(key string) error {
+	return KeyError{
+		"key already holds a value in this store",
+		key,
+	}
+}
+
+func errorNoHistory(key string) error {
+	return KeyError{
+		"key is in a collection that keeps no history",
+		key,
+	}
+}
+
+func errorNotMember(key string) error {
+	return KeyError{
+		"key does not name a member of the collection",
+		key,
+	}
+}
+
+func errorNotDelivered(key string) error {
+	return KeyError{
+		"message was acked, nacked or delivered again since it was dequeued",
+		key,
+	}
+}
+
 func errorNoSuchColl(key string) error {
 	return KeyError{
 		"key does not represent a collection in this store",
@@ -133,6 +538,20 @@ func errorNoSuchColl(key string) error {
 	}
 }
 
+func errorBadSegment(key string) error {
+	return KeyError{
+		"key has an empty or relative collection segment",
+		key,
+	}
+}
+
+func errorCollExists(key string) error {
+	return KeyError{
+		"key represents a collection that already exists in this store",
+		key,
+	}
+}
+
 /*
  * Errors when the key implies the wrong method
  */
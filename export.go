@@ -0,0 +1,184 @@
+package dskvs
+
+import (
+	"bufio"
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
+
+// Encodings of the values in an export.
+const (
+	Base64Encoding = "base64"
+	RawEncoding    = "raw"
+)
+
+// ExportOptions choose what `Export` writes, and how.
+type ExportOptions struct {
+	// Collection only exports the members of this collection, when not
+	// empty.
+	Collection string
+	// KeyPrefix only exports the members whose key, without their
+	// collection, starts with it.
+	KeyPrefix string
+	// Raw writes values as JSON strings rather than in base64.  Values
+	// that aren't valid UTF-8 are written in base64 anyway.
+	Raw bool
+}
+
+// An ExportRecord is written by `Export` for every member, on a line of its
+// own.  FormatVersion is the fileformat version of the store that exported
+// it.  dskvs members don't expire, so there is no TTL.
+type ExportRecord struct {
+	Collection    string `json:"collection"`
+	Key           string `json:"key"`
+	Value         string `json:"value"`
+	Encoding      string `json:"encoding"`
+	FormatVersion string `json:"format_version"`
+}
+
+// Export writes every member of the store to `w` as JSON Lines, one
+// `ExportRecord` per member, sorted by collection and key.  Like `Backup`, it
+// writes the members as they were at one point in time.  Use `Import` to load
+// them in a store.
+func (s Store) Export(w io.Writer, opts ExportOptions) error {
+	_, frozen, _ := s.coll.freeze()
+
+	var records []ExportRecord
+	for _, f := range frozen {
+		key := f.aPage.key
+		if opts.Collection != "" && f.coll != opts.Collection {
+			continue
+		}
+		if !strings.HasPrefix(key, opts.KeyPrefix) {
+			continue
+		}
+
+		value := f.aPage.plainValue()
+		record := ExportRecord{
+			Collection:    f.coll,
+			Key:           key,
+			FormatVersion: versionString(),
+		}
+		if opts.Raw && utf8.Valid(value) {
+			record.Value = string(value)
+			record.Encoding = RawEncoding
+		} else {
+			record.Value = base64.StdEncoding.EncodeToString(value)
+			record.Encoding = Base64Encoding
+		}
+		records = append(records, record)
+	}
+
+	sort.Slice(records, func(i, j int) bool {
+		if records[i].Collection != records[j].Collection {
+			return records[i].Collection < records[j].Collection
+		}
+		return records[i].Key < records[j].Key
+	})
+
+	buf := bufio.NewWriter(w)
+	enc := json.NewEncoder(buf)
+	for _, record := range records {
+		if err := enc.Encode(record); err != nil {
+			return err
+		}
+	}
+	return buf.Flush()
+}
+
+// Conflict tells `Import` what to do with members that already have a value.
+type Conflict int
+
+const (
+	// Overwrite replaces the value of the member.
+	Overwrite Conflict = iota
+	// Skip keeps the value of the member.
+	Skip
+	// Fail stops the import with a KeyError.
+	Fail
+)
+
+// An ImportReport counts the members loaded by `Import`, and those skipped
+// because they already had a value.
+type ImportReport struct {
+	Imported int
+	Skipped  int
+}
+
+// Import loads the members of a JSON Lines stream written by `Export`, handling
+// members that already have a value as `onConflict` says.  It stops at the
+// first bad record, with an ImportError, leaving the members before it
+// loaded.
+func (s Store) Import(r io.Reader, onConflict Conflict) (ImportReport, error) {
+	var report ImportReport
+
+	dec := json.NewDecoder(bufio.NewReader(r))
+	for line := 1; ; line++ {
+		var record ExportRecord
+		if err := dec.Decode(&record); err == io.EOF {
+			return report, nil
+		} else if err != nil {
+			return report, errorBadRecord(line, err)
+		}
+
+		value, err := record.value(line)
+		if err != nil {
+			return report, err
+		}
+		if err := s.coll.checkCollName(record.Collection); err != nil {
+			return report, errorBadRecord(line, err)
+		}
+		fullKey := record.Collection + CollKeySep + record.Key
+		if err := s.coll.checkKey(fullKey); err != nil {
+			return report, errorBadRecord(line, err)
+		}
+		if s.coll.isCollectionKey(fullKey) {
+			return report, errorBadRecord(line, errorPutIsColl(fullKey, ""))
+		}
+		if err := s.importMember(fullKey, value, onConflict, &report); err != nil {
+			return report, err
+		}
+	}
+}
+
+// importMember puts the value of a valid member key as `onConflict` says, and
+// counts it in the report.
+func (s Store) importMember(fullKey string, value []byte, onConflict Conflict, report *ImportReport) error {
+	coll, key := s.coll.splitKeys(fullKey)
+	switch onConflict {
+	case Overwrite:
+		if err := s.coll.put(coll, key, value); err != nil {
+			return err
+		}
+	default:
+		if ok, err := s.coll.putNew(coll, key, value); err != nil {
+			return err
+		} else if !ok {
+			if onConflict == Fail {
+				return errorKeyExists(fullKey)
+			}
+			report.Skipped++
+			return nil
+		}
+	}
+	report.Imported++
+	return nil
+}
+
+func (r ExportRecord) value(line int) ([]byte, error) {
+	switch r.Encoding {
+	case RawEncoding:
+		return []byte(r.Value), nil
+	case Base64Encoding:
+		value, err := base64.StdEncoding.DecodeString(r.Value)
+		if err != nil {
+			return nil, errorBadRecord(line, err)
+		}
+		return value, nil
+	}
+	return nil, errorUnknownEncoding(line, r.Encoding)
+}
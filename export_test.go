@@ -0,0 +1,91 @@
+package dskvs
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestExportAndImport(t *testing.T) {
+	store := setUp(t)
+	fillStore(store, backupData, t)
+	store.Put("artist/binary", []byte{0xFF, 0x00})
+
+	var all, artists bytes.Buffer
+	if err := store.Export(&all, ExportOptions{Raw: true}); err != nil {
+		t.Fatalf("Error exporting store, %v", err)
+	}
+	err := store.Export(&artists, ExportOptions{Collection: "artist", KeyPrefix: "daft"})
+	if err != nil {
+		t.Fatalf("Error exporting store, %v", err)
+	}
+	tearDown(store, t)
+
+	lines := strings.Split(strings.TrimSpace(all.String()), "\n")
+	if len(lines) != 4 {
+		t.Fatalf("Expected 4 records but got %d", len(lines))
+	}
+	if !strings.Contains(lines[0], `"value":"1997","encoding":"raw"`) {
+		t.Errorf("Expected a raw value first, was %s", lines[0])
+	}
+	if !strings.Contains(lines[0], `"format_version":"`+versionString()+`"`) {
+		t.Errorf("Expected the fileformat version, was %s", lines[0])
+	}
+	if strings.Count(artists.String(), "\n") != 1 {
+		t.Errorf("Expected only daft_punk, was %s", artists.String())
+	}
+
+	store = setUp(t)
+	defer tearDown(store, t)
+	store.Put("album/homework", []byte("Homework"))
+
+	report, err := store.Import(bytes.NewReader(all.Bytes()), Skip)
+	if err != nil {
+		t.Fatalf("Error importing, %v", err)
+	}
+	if report.Imported != 3 || report.Skipped != 1 {
+		t.Errorf("Expected 3 imported and 1 skipped, was %+v", report)
+	}
+	if val, _, _ := store.Get("album/homework"); string(val) != "Homework" {
+		t.Errorf("Skipped member was overwritten with <%s>", val)
+	}
+	if val, _, _ := store.Get("artist/binary"); !bytes.Equal(val, []byte{0xFF, 0x00}) {
+		t.Errorf("Binary value didn't survive export, was %v", val)
+	}
+
+	_, err = store.Import(bytes.NewReader(all.Bytes()), Fail)
+	if _, isRightType := err.(KeyError); !isRightType {
+		t.Errorf("Should have returned an error of type KeyError"+
+			", error was %v",
+			err)
+	}
+
+	if _, err := store.Import(bytes.NewReader(all.Bytes()), Overwrite); err != nil {
+		t.Fatalf("Error importing, %v", err)
+	}
+	if val, _, _ := store.Get("album/homework"); string(val) != "1997" {
+		t.Errorf("Expected member to be overwritten, was <%s>", val)
+	}
+}
+
+func TestErrorWhenImportingBadRecords(t *testing.T) {
+	store := setUp(t)
+	defer tearDown(store, t)
+
+	tests := []string{
+		`not json`,
+		`{"collection":"artist","key":"air","value":"!!","encoding":"base64"}`,
+		`{"collection":"artist","key":"air","value":"x","encoding":"rot13"}`,
+		`{"collection":".quarantine","key":"air","value":"x","encoding":"raw"}`,
+		`{"collection":"artist","key":"","value":"x","encoding":"raw"}`,
+	}
+	for _, record := range tests {
+		_, err := store.Import(strings.NewReader(record), Overwrite)
+		if _, isRightType := err.(ImportError); !isRightType {
+			t.Errorf("%s: should have returned an error of type ImportError"+
+				", error was %v",
+				record,
+				err)
+		}
+	}
+}
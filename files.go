@@ -5,7 +5,6 @@ import (
 	"crypto/sha1"
 	"encoding/binary"
 	"encoding/hex"
-	"fmt"
 	"io/ioutil"
 	"log"
 	"net/url"
@@ -16,63 +15,110 @@ import (
 const (
 	FILE_PERM = 0640
 	DIR_PERM  = 0740
+
+	// Files are first written under their name with this suffix, then renamed
+	// in place.  Page filenames end with the hex SHA1 of their key, so they
+	// never carry it.
+	tempSuffix = ".tmp"
 )
 
+// fileMagic prefixes every page file written since fileformat 0.5.  It lets
+// dskvs tell a foreign file from a corrupted page.
+var fileMagic = [4]byte{'D', 'S', 'K', 'V'}
+
 type fileHeader struct {
-	Major         uint16
-	Minor         uint16
-	Patch         uint64
+	Magic [4]byte
+	Major uint16
+	Minor uint16
+	Patch uint64
+	// Compression is the ID of the Compressor that compressed the payload,
+	// or 0 if it isn't compressed.
+	Compression uint8
+	// KeyID is the ID of the key that encrypted the payload with Nonce, or
+	// 0 if it isn't encrypted.
+	KeyID         uint32
+	Nonce         [nonceSize]byte
 	Checksum      uint64
 	KeyNameLength uint64
 	PayloadLength uint64
+	// ValueLength is the length of the payload once decompressed, past
+	// which decompressing it stops.
+	ValueLength uint64
 }
 
 var (
 	fileHeaderSize int = binary.Size(new(fileHeader))
 )
 
+// A pagePayload is the value of a page as it is written in its file, along
+// with what the header must say to read it back.
+type pagePayload struct {
+	data []byte
+	// length is that of the value, once decompressed.
+	length      uint64
+	compression uint8
+	keyID       uint32
+	nonce       [nonceSize]byte
+}
+
 func newFileHeader(aPage *page) *fileHeader {
-	h := sha1.New()
-	n, err := h.Write(aPage.value)
+	payload, err := aPage.payload()
 	if err != nil {
-		log.Fatalf("writing to SHA1 hash, %v", err)
-	}
-	if n != len(aPage.value) {
-		log.Fatalf("should have writen %d bytes, wrote %d", len(aPage.value), n)
-	}
-	hash := h.Sum(nil)
-	checksum, size := binary.Uvarint(hash)
-
-	if size == 0 {
-		log.Fatalf("Hash too small to produce uint64: %s", hash)
-	} else if size < 0 {
-		log.Printf("Hash to uint64 resulted in overflow. uint64=%d, overflow size=%d",
-			checksum,
-			size)
+		log.Printf("Couldn't prepare payload of page <%s> : %v", aPage.key, err)
 	}
+	return headerForPayload(aPage.key, payload)
+}
+
+func headerForPayload(key string, payload pagePayload) *fileHeader {
+	header := payloadHeader(key, payload, uint64(len(payload.data)))
+	header.Checksum = payloadChecksum(payload.data)
+	return header
+}
 
+// payloadHeader is the header of a payload that will be `length` bytes long,
+// without its checksum.
+func payloadHeader(key string, payload pagePayload, length uint64) *fileHeader {
 	return &fileHeader{
-		MajorVersion,
-		MinorVersion,
-		PatchVersion,
-		checksum,
-		uint64(len([]byte(aPage.key))),
-		uint64(len(aPage.value)),
+		Magic:         fileMagic,
+		Major:         MajorVersion,
+		Minor:         MinorVersion,
+		Patch:         PatchVersion,
+		Compression:   payload.compression,
+		KeyID:         payload.keyID,
+		Nonce:         payload.nonce,
+		KeyNameLength: uint64(len([]byte(key))),
+		PayloadLength: length,
+		ValueLength:   payload.length,
 	}
 }
 
 func writeToFile(dirty *page) error {
+	// Keep the files of the member in place meanwhile
+	if dirty.files != nil {
+		dirty.files.RLock()
+		defer dirty.files.RUnlock()
+	}
+	return writePageFile(dirty)
+}
+
+func writePageFile(dirty *page) error {
 	// Don't need to lock the page before reading the key, it's only modified
-	// when `page` are created
+	// when `page` are created, and its collection while files are held
 	filename := generateFilename(dirty)
+	// Don't care about errors, they're logged
+	_ = saveHistory(dirty)
 	// Lock the page for read
 	dirty.RLock()
 	if dirty.isDeleted {
 		dirty.RUnlock()
+		// The value is safe in the trash before its file goes
+		if err := trashPage(dirty); err != nil {
+			return err
+		}
 		return deleteFile(filename)
 	}
 
-	data, err := fromPageToBytes(dirty)
+	data, payload, err := encodePage(dirty)
 
 	dirty.RUnlock()
 
@@ -85,9 +131,10 @@ func writeToFile(dirty *page) error {
 	if dirty.isDeleted {
 		// Was requested for deletion right after we tested
 		dirty.Unlock()
-		return writeToFile(dirty)
+		return writePageFile(dirty)
 	}
 	dirty.isDirty = false
+	dirty.keyID = payload.keyID
 	dirty.Unlock()
 
 	if err := ioutil.WriteFile(filename, data, FILE_PERM); err != nil {
@@ -99,71 +146,18 @@ func writeToFile(dirty *page) error {
 }
 
 func readFromFile(filename string) (*page, error) {
+	return readPageFile(filename, nil)
+}
+
+// readPageFile reads a page file, decrypting it with `keys` if needed.
+func readPageFile(filename string, keys KeyProvider) (*page, error) {
 	data, err := ioutil.ReadFile(filename)
 	if err != nil {
 		log.Printf("Error reading file <%s> : %v", filename, err)
 		return nil, err
 	}
 
-	header, err := headerFromBytes(data)
-	if err != nil {
-		log.Printf("Error reading header from file <%s> : %v",
-			filename, err)
-		return nil, errorCreatingHeader(filename, err)
-	}
-
-	// Fileformat is garanteed within same major versions
-	if header.Major > MajorVersion {
-		return nil, errorWrongVersion(header.Major, header.Minor, header.Patch)
-	}
-
-	keyIndex := uint64(fileHeaderSize)
-	payloadIndex := keyIndex + header.KeyNameLength
-	key := string(data[keyIndex:payloadIndex])
-	payload := data[payloadIndex:]
-
-	if uint64(len(data[payloadIndex:])) != header.PayloadLength {
-		return nil, errorPayloadWrongSize(filename,
-			header.PayloadLength,
-			len(data[payloadIndex:]))
-	}
-
-	h := sha1.New()
-	n, err := h.Write(payload)
-	if err != nil {
-		return nil, fmt.Errorf("writing to SHA1 hash, %v", err)
-	}
-	if n != len(payload) {
-		return nil, fmt.Errorf("should have writen %d bytes, wrote %d", len(payload), n)
-	}
-	hash := h.Sum(nil)
-
-	checksum, size := binary.Uvarint(hash)
-	if size == 0 {
-		log.Fatalf("Error reading file <%s> checksum, incomplete hash.",
-			filename)
-	} else if size < 0 {
-		log.Fatal("Read too many bytes for checksum.")
-	} else if checksum != header.Checksum {
-		log.Printf("Payload checksum failed for file <%s>. Header says <%v>"+
-			" but checksum was <%v>",
-			filename,
-			header,
-			checksum)
-		return nil, errorFailedChecksum(filename)
-	}
-
-	basepath := filepath.Base(filepath.Dir(filepath.Dir(filename)))
-	coll := filepath.Base(filepath.Dir(filename))
-
-	return &page{
-		isDirty:   false,
-		isDeleted: false,
-		basepath:  basepath,
-		coll:      coll,
-		key:       key,
-		value:     payload,
-	}, nil
+	return decodePageFile(filename, data, keys)
 }
 
 func deleteFile(filename string) error {
@@ -197,6 +191,36 @@ func deleteFolder(delete *member) error {
 	return nil
 }
 
+// writeFileAtomic writes `data` to a temporary file that is synced then
+// renamed to `filename`.  A crash leaves either the previous file or the new
+// one, never a partial one.
+func writeFileAtomic(filename string, data []byte) error {
+	tmpName := filename + tempSuffix
+	f, err := os.OpenFile(tmpName, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, FILE_PERM)
+	if err != nil {
+		log.Printf("Couldn't create file <%s> : %v", tmpName, err)
+		return err
+	}
+	_, err = f.Write(data)
+	if err == nil {
+		err = f.Sync()
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		log.Printf("Couldn't write file <%s> : %v", tmpName, err)
+		_ = os.Remove(tmpName)
+		return err
+	}
+	if err := os.Rename(tmpName, filename); err != nil {
+		log.Printf("Couldn't rename <%s> to <%s> : %v", tmpName, filename, err)
+		_ = os.Remove(tmpName)
+		return err
+	}
+	return nil
+}
+
 /*
 	Helpers
 */
@@ -245,15 +269,26 @@ func generateFilename(aPage *page) string {
 }
 
 func fromPageToBytes(aPage *page) ([]byte, error) {
+	data, _, err := encodePage(aPage)
+	return data, err
+}
+
+// encodePage returns the content of the file of a page, and the payload
+// that it holds.
+func encodePage(aPage *page) ([]byte, pagePayload, error) {
 	keyBytes := []byte(aPage.key)
+	payload, err := aPage.payload()
+	if err != nil {
+		return nil, payload, err
+	}
 
-	header := newFileHeader(aPage)
+	header := headerForPayload(aPage.key, payload)
 	headerBytes, err := headerToBytes(header)
 	if err != nil {
-		return nil, err
+		return nil, payload, err
 	}
 
-	dataLength := len(headerBytes) + len(keyBytes) + len(aPage.value)
+	dataLength := len(headerBytes) + len(keyBytes) + len(payload.data)
 	keyIndex := len(headerBytes)
 	payloadIndex := len(headerBytes) + len(keyBytes)
 
@@ -264,7 +299,7 @@ func fromPageToBytes(aPage *page) ([]byte, error) {
 	// Followed by the key name
 	copy(data[keyIndex:], keyBytes)
 	// Followed by the page value
-	copy(data[payloadIndex:], aPage.value)
+	copy(data[payloadIndex:], payload.data)
 
-	return data, nil
+	return data, payload, nil
 }
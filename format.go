@@ -0,0 +1,367 @@
+package dskvs
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/binary"
+	"fmt"
+	"log"
+	"path/filepath"
+	"strings"
+)
+
+// A formatVersion identifies a fileformat layout.  Patch versions never
+// change the layout, so they are not part of it.
+type formatVersion struct {
+	Major uint16
+	Minor uint16
+}
+
+func currentFormat() formatVersion {
+	return formatVersion{MajorVersion, MinorVersion}
+}
+
+// versionString is the full version of dskvs.
+func versionString() string {
+	return fmt.Sprintf("%d.%d.%d", MajorVersion, MinorVersion, PatchVersion)
+}
+
+func (v formatVersion) String() string {
+	return fmt.Sprintf("%d.%d", v.Major, v.Minor)
+}
+
+// A pageDecoder turns the content of a page file written in a given
+// fileformat version back into a page.  `keys` decrypt encrypted files, it
+// can be nil if no key is known.
+type pageDecoder func(filename string, data []byte, keys KeyProvider) (*page, error)
+
+// pageDecoders knows how to read every fileformat version that dskvs ever
+// wrote.  When the fileformat changes, bump MinorVersion and register a
+// decoder for the new layout; keep the old ones so that `Open` can still load
+// older stores and `Migrate` can rewrite them.
+var pageDecoders = map[formatVersion]pageDecoder{
+	{0, 4}: withoutSeparator(decodePage04),
+	{0, 5}: withoutSeparator(decodePage05),
+	{0, 6}: withoutSeparator(decodePage06),
+	{0, 7}: withoutSeparator(decodePage08),
+	{0, 8}: decodePage08,
+	{0, 9}: decodePage,
+}
+
+// withoutSeparator wraps the decoder of a fileformat older than 0.8, whose
+// member keys started with CollKeySep.  The key is only trimmed once the file
+// is decrypted, as it authenticates the payload as it was written.
+func withoutSeparator(decode pageDecoder) pageDecoder {
+	return func(filename string, data []byte, keys KeyProvider) (*page, error) {
+		aPage, err := decode(filename, data, keys)
+		if err != nil {
+			return nil, err
+		}
+		aPage.key = strings.TrimPrefix(aPage.key, CollKeySep)
+		return aPage, nil
+	}
+}
+
+// fileHeader04 is the header of fileformat 0.4, which had no magic prefix.
+type fileHeader04 struct {
+	Major         uint16
+	Minor         uint16
+	Patch         uint64
+	Checksum      uint64
+	KeyNameLength uint64
+	PayloadLength uint64
+}
+
+// fileHeader05 is the header of fileformat 0.5, which couldn't compress
+// payloads.
+type fileHeader05 struct {
+	Magic         [4]byte
+	Major         uint16
+	Minor         uint16
+	Patch         uint64
+	Checksum      uint64
+	KeyNameLength uint64
+	PayloadLength uint64
+}
+
+// fileHeader06 is the header of fileformat 0.6, which couldn't encrypt
+// payloads.
+type fileHeader06 struct {
+	Magic         [4]byte
+	Major         uint16
+	Minor         uint16
+	Patch         uint64
+	Compression   uint8
+	Checksum      uint64
+	KeyNameLength uint64
+	PayloadLength uint64
+}
+
+// fileHeader08 is the header of fileformats 0.7 and 0.8, which didn't tell
+// the length of decompressed payloads.
+type fileHeader08 struct {
+	Magic         [4]byte
+	Major         uint16
+	Minor         uint16
+	Patch         uint64
+	Compression   uint8
+	KeyID         uint32
+	Nonce         [nonceSize]byte
+	Checksum      uint64
+	KeyNameLength uint64
+	PayloadLength uint64
+}
+
+var (
+	fileHeader04Size int = binary.Size(new(fileHeader04))
+	fileHeader05Size int = binary.Size(new(fileHeader05))
+	fileHeader06Size int = binary.Size(new(fileHeader06))
+	fileHeader08Size int = binary.Size(new(fileHeader08))
+)
+
+// maxExpansion is the most that DEFLATE, which gzip uses as well, can expand
+// a payload.  Decompressing the payloads of fileformats that didn't tell
+// their length stops past it.
+const maxExpansion = 1032
+
+// legacyValueLength bounds the length of a payload of `length` bytes, once
+// decompressed, for the fileformats that didn't tell it.
+func legacyValueLength(length uint64) uint64 {
+	if length > ^uint64(0)/maxExpansion {
+		return ^uint64(0)
+	}
+	return length * maxExpansion
+}
+
+// decodePageFile finds the fileformat version of `data` and hands it to the
+// matching decoder.
+func decodePageFile(filename string, data []byte, keys KeyProvider) (*page, error) {
+	version, err := fileVersion(data)
+	if err != nil {
+		log.Printf("Error reading header from file <%s> : %v",
+			filename, err)
+		return nil, errorCreatingHeader(filename, err)
+	}
+
+	// Fileformat is garanteed within same major versions
+	if version.Major > MajorVersion {
+		return nil, errorWrongVersion(version.Major, version.Minor, 0)
+	}
+
+	decode, ok := pageDecoders[version]
+	if !ok {
+		return nil, errorUnknownVersion(filename, version)
+	}
+	return decode(filename, data, keys)
+}
+
+// fileVersion reads the version of a page file.  Files without the magic
+// prefix predate it and start directly with their version numbers.
+func fileVersion(data []byte) (formatVersion, error) {
+	var version formatVersion
+	r := bytes.NewBuffer(data)
+	if bytes.HasPrefix(data, fileMagic[:]) {
+		r.Next(len(fileMagic))
+	}
+	err := binary.Read(r, binary.BigEndian, &version)
+	return version, err
+}
+
+// hasLegacyKey tells if the files of fileformat `version` hold member keys
+// that start with CollKeySep, as they did before 0.8.
+func hasLegacyKey(version formatVersion) bool {
+	return version.Major == 0 && version.Minor < 8
+}
+
+// isCurrentFormat tells if `data` is already written in the fileformat of
+// this version of dskvs.
+func isCurrentFormat(data []byte) bool {
+	if !bytes.HasPrefix(data, fileMagic[:]) {
+		return false
+	}
+	version, err := fileVersion(data)
+	return err == nil && version == currentFormat()
+}
+
+func decodePage(filename string, data []byte, keys KeyProvider) (*page, error) {
+	header, err := headerFromBytes(data)
+	if err != nil {
+		log.Printf("Error reading header from file <%s> : %v",
+			filename, err)
+		return nil, errorCreatingHeader(filename, err)
+	}
+
+	return pageFromParts(filename, data, uint64(fileHeaderSize), header, keys)
+}
+
+func decodePage08(filename string, data []byte, keys KeyProvider) (*page, error) {
+	var old fileHeader08
+	err := binary.Read(bytes.NewBuffer(data), binary.BigEndian, &old)
+	if err != nil {
+		log.Printf("Error reading header from file <%s> : %v",
+			filename, err)
+		return nil, errorCreatingHeader(filename, err)
+	}
+
+	header := &fileHeader{
+		Magic:         old.Magic,
+		Major:         old.Major,
+		Minor:         old.Minor,
+		Patch:         old.Patch,
+		Compression:   old.Compression,
+		KeyID:         old.KeyID,
+		Nonce:         old.Nonce,
+		Checksum:      old.Checksum,
+		KeyNameLength: old.KeyNameLength,
+		PayloadLength: old.PayloadLength,
+		ValueLength:   legacyValueLength(old.PayloadLength),
+	}
+	return pageFromParts(filename, data, uint64(fileHeader08Size), header, keys)
+}
+
+func decodePage06(filename string, data []byte, keys KeyProvider) (*page, error) {
+	var old fileHeader06
+	err := binary.Read(bytes.NewBuffer(data), binary.BigEndian, &old)
+	if err != nil {
+		log.Printf("Error reading header from file <%s> : %v",
+			filename, err)
+		return nil, errorCreatingHeader(filename, err)
+	}
+
+	header := &fileHeader{
+		Compression:   old.Compression,
+		Checksum:      old.Checksum,
+		KeyNameLength: old.KeyNameLength,
+		PayloadLength: old.PayloadLength,
+		ValueLength:   legacyValueLength(old.PayloadLength),
+	}
+	return pageFromParts(filename, data, uint64(fileHeader06Size), header, keys)
+}
+
+func decodePage05(filename string, data []byte, keys KeyProvider) (*page, error) {
+	var old fileHeader05
+	err := binary.Read(bytes.NewBuffer(data), binary.BigEndian, &old)
+	if err != nil {
+		log.Printf("Error reading header from file <%s> : %v",
+			filename, err)
+		return nil, errorCreatingHeader(filename, err)
+	}
+
+	header := &fileHeader{
+		Checksum:      old.Checksum,
+		KeyNameLength: old.KeyNameLength,
+		PayloadLength: old.PayloadLength,
+	}
+	return pageFromParts(filename, data, uint64(fileHeader05Size), header, keys)
+}
+
+func decodePage04(filename string, data []byte, keys KeyProvider) (*page, error) {
+	var old fileHeader04
+	err := binary.Read(bytes.NewBuffer(data), binary.BigEndian, &old)
+	if err != nil {
+		log.Printf("Error reading legacy header from file <%s> : %v",
+			filename, err)
+		return nil, errorCreatingHeader(filename, err)
+	}
+
+	header := &fileHeader{
+		Checksum:      old.Checksum,
+		KeyNameLength: old.KeyNameLength,
+		PayloadLength: old.PayloadLength,
+	}
+	return pageFromParts(filename, data, uint64(fileHeader04Size), header, keys)
+}
+
+// pageFromParts extracts the key and the payload that follow a header of
+// `headerSize` bytes, verifies the payload against its checksum then decrypts
+// and decompresses it.  Older headers are given converted to the current
+// fileHeader.  The lengths come from the file itself, so none of them is
+// trusted before being checked against the actual size of `data`, and
+// decompressing stops past the length of the value.
+func pageFromParts(filename string, data []byte, headerSize uint64,
+	header *fileHeader, keys KeyProvider) (*page, error) {
+
+	size := uint64(len(data))
+	if headerSize > size {
+		return nil, errorHeaderTooShort(filename, headerSize, len(data))
+	}
+
+	if header.KeyNameLength > size-headerSize {
+		return nil, errorKeyWrongSize(filename, header.KeyNameLength,
+			size-headerSize)
+	}
+
+	keyIndex := headerSize
+	payloadIndex := keyIndex + header.KeyNameLength
+	key := string(data[keyIndex:payloadIndex])
+	payload := pagePayload{
+		data:        data[payloadIndex:],
+		compression: header.Compression,
+		keyID:       header.KeyID,
+		nonce:       header.Nonce,
+	}
+
+	if uint64(len(payload.data)) != header.PayloadLength {
+		return nil, errorPayloadWrongSize(filename,
+			header.PayloadLength,
+			len(payload.data))
+	}
+
+	if actual := payloadChecksum(payload.data); actual != header.Checksum {
+		log.Printf("Payload checksum failed for file <%s>. Header says <%v>"+
+			" but checksum was <%v>",
+			filename,
+			header.Checksum,
+			actual)
+		return nil, errorFailedChecksum(filename)
+	}
+
+	// The checksum passed, so failing to decrypt means the key is wrong
+	keyID := payload.keyID
+	if keyID != 0 {
+		if keys == nil {
+			return nil, errorNoKeyProvider(filename, keyID)
+		}
+		ad := []byte(key)
+		if sealsHeader(formatVersion{header.Major, header.Minor}) {
+			ad = additionalData(key, header)
+		}
+		if err := unseal(keys, ad, &payload); err != nil {
+			return nil, errorDecrypting(filename, keyID, err)
+		}
+	}
+
+	if payload.compression != 0 {
+		c, ok := compressorByID(payload.compression)
+		if !ok {
+			return nil, errorUnknownCompressor(filename, payload.compression)
+		}
+		var err error
+		payload.data, err = decompress(filename, c, payload.data, header.ValueLength)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return &page{
+		isDirty:   false,
+		isDeleted: false,
+		basepath:  filepath.Dir(filepath.Dir(filename)),
+		coll:      filepath.Base(filepath.Dir(filename)),
+		key:       key,
+		value:     payload.data,
+		keyID:     keyID,
+	}, nil
+}
+
+// payloadChecksum folds the SHA1 of `payload` into an uint64 by reading it as
+// an uvarint, as every fileformat so far did.  When the uvarint overflows, the
+// checksum is 0, which is what was written in such files.
+func payloadChecksum(payload []byte) uint64 {
+	hash := sha1.Sum(payload)
+	checksum, size := binary.Uvarint(hash[:])
+	if size <= 0 {
+		return 0
+	}
+	return checksum
+}
@@ -0,0 +1,143 @@
+package dskvs
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+)
+
+func validPageBytes(t testing.TB) []byte {
+	aPage := newPage("imdb", "FMJ", "/Is that you, John Wayne?")
+	aPage.value = []byte("Is this me?")
+	data, err := fromPageToBytes(aPage)
+	if err != nil {
+		t.Fatalf("Couldn't encode page, %v", err)
+	}
+	return data
+}
+
+func TestErrorWhenDecodingMalformedFiles(t *testing.T) {
+	valid := validPageBytes(t)
+
+	hugeKey := newFileHeader(newPage("imdb", "FMJ", "/key"))
+	hugeKey.KeyNameLength = ^uint64(0)
+	hugeKeyBytes, err := headerToBytes(hugeKey)
+	if err != nil {
+		t.Fatalf("Couldn't get fake header, %v", err)
+	}
+
+	tests := map[string][]byte{
+		"empty":             {},
+		"magic only":        fileMagic[:],
+		"truncated header":  valid[:fileHeaderSize-1],
+		"truncated payload": valid[:len(valid)-1],
+		"trailing bytes":    append(append([]byte{}, valid...), 0xFF),
+		"key past the end":  hugeKeyBytes,
+	}
+
+	for name, data := range tests {
+		_, err := decodePageFile("malformed.test", data, nil)
+		if _, isRightType := err.(FileError); !isRightType {
+			t.Errorf("%s: should have returned an error of type FileError"+
+				", error was %v",
+				name,
+				err)
+		}
+	}
+}
+
+func TestChecksumNeverFails(t *testing.T) {
+	// Hashes whose uvarint overflows used to abort the process
+	for i := 0; i < 4096; i++ {
+		payload := make([]byte, 8)
+		binary.BigEndian.PutUint64(payload, uint64(i))
+		aPage := newPage("imdb", "FMJ", "/key")
+		aPage.value = payload
+
+		data, err := fromPageToBytes(aPage)
+		if err != nil {
+			t.Fatalf("Couldn't encode page, %v", err)
+		}
+		if _, err := decodePageFile("imdb/FMJ/key", data, nil); err != nil {
+			t.Fatalf("Couldn't decode page with payload %v, %v", payload, err)
+		}
+	}
+}
+
+func FuzzHeaderFromBytes(f *testing.F) {
+	f.Add(validPageBytes(f))
+	f.Add([]byte{})
+	f.Add(fileMagic[:])
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		header, err := headerFromBytes(data)
+		if err != nil {
+			return
+		}
+		encoded, err := headerToBytes(header)
+		if err != nil {
+			t.Fatalf("Couldn't encode decoded header, %v", err)
+		}
+		if !bytes.Equal(encoded, data[:fileHeaderSize]) {
+			t.Errorf("Header doesn't survive a round trip")
+		}
+	})
+}
+
+func FuzzDecodePageFile(f *testing.F) {
+	f.Add(validPageBytes(f))
+	f.Add([]byte{})
+	f.Add([]byte{0xDE, 0xAD, 0xBE, 0xEF})
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		aPage, err := decodePageFile("fuzz/coll/page", data, nil)
+		if err != nil {
+			_, isFileError := err.(FileError)
+			_, isCryptoError := err.(CryptoError)
+			if !isFileError && !isCryptoError {
+				t.Fatalf("Should have returned an error of type FileError"+
+					" or CryptoError, error was %v",
+					err)
+			}
+			return
+		}
+
+		// Whatever decodes must encode back to a page that decodes the same
+		encoded, err := fromPageToBytes(aPage)
+		if err != nil {
+			t.Fatalf("Couldn't encode decoded page, %v", err)
+		}
+		again, err := decodePageFile("fuzz/coll/page", encoded, nil)
+		if err != nil {
+			t.Fatalf("Couldn't decode encoded page, %v", err)
+		}
+		if again.key != aPage.key || !bytes.Equal(again.value, aPage.value) {
+			t.Errorf("Page doesn't survive a round trip")
+		}
+	})
+}
+
+func TestErrorWhenPayloadDecompressesPastItsLength(t *testing.T) {
+	bomb, err := Deflate.Compress(make([]byte, 1<<20))
+	if err != nil {
+		t.Fatalf("Couldn't compress payload, %v", err)
+	}
+	key := "bomb"
+	header := headerForPayload(key, pagePayload{
+		data:        bomb,
+		length:      16,
+		compression: Deflate.ID(),
+	})
+	headerBytes, err := headerToBytes(header)
+	if err != nil {
+		t.Fatalf("Couldn't get fake header, %v", err)
+	}
+	data := append(append(headerBytes, key...), bomb...)
+
+	_, err = decodePageFile("imdb/FMJ/bomb", data, nil)
+	if _, isRightType := err.(FileError); !isRightType {
+		t.Errorf("Should have returned an error of type FileError"+
+			", error was %v",
+			err)
+	}
+}
@@ -0,0 +1,262 @@
+package dskvs
+
+import (
+	"bytes"
+	"encoding/binary"
+	"log"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// textDir is where the full-text indexes of collections are written when the
+// store is closed, under its storage path.  They are read back and deleted
+// when it's opened, so that a store that crashed builds them again.
+const textDir = ".fulltext"
+
+// textIndexName is the name of the full-text index among the indexes of a
+// collection, and textIndexKey the key of its file.
+const (
+	textIndexName = ".fulltext"
+	textIndexKey  = "index"
+)
+
+// The BM25 parameters used to rank search results.
+const (
+	bm25K1 = 1.2
+	bm25B  = 0.75
+)
+
+// stopwords are the words too common to be worth indexing.
+var stopwords = map[string]bool{
+	"a": true, "an": true, "and": true, "are": true, "as": true, "at": true,
+	"be": true, "but": true, "by": true, "for": true, "if": true, "in": true,
+	"into": true, "is": true, "it": true, "no": true, "not": true, "of": true,
+	"on": true, "or": true, "such": true, "that": true, "the": true,
+	"their": true, "then": true, "there": true, "these": true, "they": true,
+	"this": true, "to": true, "was": true, "will": true, "with": true,
+}
+
+// Tokenize splits `text` into the terms that full-text indexes hold : its runs
+// of letters and digits, lowercased, but for stopwords.
+func Tokenize(text string) []string {
+	words := strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+	})
+	terms := words[:0]
+	for _, word := range words {
+		if !stopwords[word] {
+			terms = append(terms, word)
+		}
+	}
+	return terms
+}
+
+// Search returns the members of collection `coll` holding the terms of
+// `query`, as `Tokenize` finds them, best first.  They are ranked by BM25,
+// and at most `limit` are returned, unless it's zero.  The collection must
+// keep a full-text index, as `Options.FullText` says; should it still be
+// built, it waits until it is.
+func (s Store) Search(coll, query string, limit int) ([]string, error) {
+	if err := s.coll.checkCollName(coll); err != nil {
+		return nil, err
+	}
+	set := s.coll.indexSet(coll)
+	idx, ok := set.get(textIndexName)
+	if !ok {
+		return nil, errorNoTextIndex(coll)
+	}
+	<-idx.ready
+
+	set.RLock()
+	scores := idx.score(Tokenize(query))
+	set.RUnlock()
+
+	keys := make([]string, 0, len(scores))
+	for key := range scores {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if scores[keys[i]] != scores[keys[j]] {
+			return scores[keys[i]] > scores[keys[j]]
+		}
+		return keys[i] < keys[j]
+	})
+	if limit > 0 && limit < len(keys) {
+		keys = keys[:limit]
+	}
+	return keys, nil
+}
+
+// score returns the BM25 score of every member holding one of `terms`.  The
+// index must be locked for reading.
+func (idx *index) score(terms []string) map[string]float64 {
+	scores := make(map[string]float64)
+	if len(idx.keys) == 0 {
+		return scores
+	}
+	n := float64(len(idx.keys))
+	avgLength := float64(idx.total) / n
+
+	seen := make(map[string]bool)
+	for _, term := range terms {
+		if seen[term] {
+			continue
+		}
+		seen[term] = true
+
+		postings := idx.terms[term]
+		df := float64(len(postings))
+		idf := math.Log(1 + (n-df+0.5)/(df+0.5))
+		for key, count := range postings {
+			tf := float64(count)
+			length := float64(len(idx.keys[key]))
+			scores[key] += idf * tf * (bm25K1 + 1) /
+				(tf + bm25K1*(1-bm25B+bm25B*length/avgLength))
+		}
+	}
+	return scores
+}
+
+// textIndexes returns the full-text indexes of `Options.FullText`.
+func (s *Store) textIndexes() []Index {
+	var specs []Index
+	for _, coll := range s.opts.FullText {
+		specs = append(specs, Index{
+			Coll: coll,
+			Name: textIndexName,
+			Extract: func(key string, value []byte) []string {
+				return Tokenize(string(value))
+			},
+		})
+	}
+	return specs
+}
+
+// textIndexFilename is where the full-text index of collection `coll` is
+// written.
+func textIndexFilename(basepath, coll string) string {
+	return filepath.Join(basepath, textDir, coll,
+		filepath.Base(generateFilename(newPage(basepath, coll, textIndexKey))))
+}
+
+// loadTextIndex fills full-text index `idx` from the file written when the
+// store was last closed, and tells if it could.
+func (s *Store) loadTextIndex(idx *index) bool {
+	filename := textIndexFilename(s.storagePath, idx.Coll)
+	if _, err := os.Stat(filename); os.IsNotExist(err) {
+		return false
+	}
+	tPage, err := readPageFile(filename, s.opts.keyProvider())
+	if err == nil {
+		err = decodeTextIndex(filename, tPage.value, idx)
+	}
+	if err != nil {
+		log.Printf("Building full-text index of <%s> again : %v", idx.Coll, err)
+		return false
+	}
+
+	// What it holds is only known to be right until the store changes
+	for key := range idx.keys {
+		if _, ok := s.coll.get(idx.Coll, key); !ok {
+			log.Printf("Building full-text index of <%s> again : member <%s> is gone",
+				idx.Coll, key)
+			return false
+		}
+	}
+	close(idx.ready)
+	return true
+}
+
+// saveTextIndexes writes the full-text indexes of the store, which must not
+// change anymore.
+func (s *Store) saveTextIndexes() error {
+	for _, coll := range s.opts.FullText {
+		set := s.coll.indexSet(coll)
+		idx, ok := set.get(textIndexName)
+		if !ok {
+			continue
+		}
+		set.RLock()
+		value := encodeTextIndex(idx)
+		set.RUnlock()
+
+		filename := textIndexFilename(s.storagePath, coll)
+		data, _, err := encodePage(&page{
+			basepath: s.storagePath,
+			coll:     coll,
+			key:      textIndexKey,
+			value:    value,
+			comp:     s.opts.compressionFor(coll),
+			keys:     s.opts.keyProvider(),
+		})
+		if err == nil {
+			err = os.MkdirAll(filepath.Dir(filename), DIR_PERM)
+		}
+		if err == nil {
+			err = writeFileAtomic(filename, data)
+		}
+		if err != nil {
+			log.Printf("Couldn't write full-text index <%s> : %v", filename, err)
+			return err
+		}
+	}
+	return nil
+}
+
+// encodeTextIndex encodes every member of a full-text index as its key, then
+// the number of its terms and every term, all strings prefixed by their
+// length.  The index must be locked for reading.
+func encodeTextIndex(idx *index) []byte {
+	buf := new(bytes.Buffer)
+	writeString := func(s string) {
+		_ = binary.Write(buf, binary.BigEndian, uint32(len(s)))
+		_, _ = buf.WriteString(s)
+	}
+	for key, terms := range idx.keys {
+		writeString(key)
+		_ = binary.Write(buf, binary.BigEndian, uint32(len(terms)))
+		for _, term := range terms {
+			writeString(term)
+		}
+	}
+	return buf.Bytes()
+}
+
+func decodeTextIndex(filename string, data []byte, idx *index) error {
+	buf := bytes.NewReader(data)
+	readString := func() (string, bool) {
+		var length uint32
+		if err := binary.Read(buf, binary.BigEndian, &length); err != nil {
+			return "", false
+		}
+		if uint64(length) > uint64(buf.Len()) {
+			return "", false
+		}
+		s := make([]byte, length)
+		_, _ = buf.Read(s)
+		return string(s), true
+	}
+
+	for buf.Len() != 0 {
+		key, ok := readString()
+		var count uint32
+		if !ok || binary.Read(buf, binary.BigEndian, &count) != nil {
+			return errorBadTextIndex(filename)
+		}
+		if uint64(count) > uint64(buf.Len()) {
+			return errorBadTextIndex(filename)
+		}
+		terms := make([]string, count)
+		for i := range terms {
+			if terms[i], ok = readString(); !ok {
+				return errorBadTextIndex(filename)
+			}
+		}
+		idx.set(key, terms)
+	}
+	return nil
+}
@@ -0,0 +1,93 @@
+package dskvs
+
+import (
+	"io/ioutil"
+	"os"
+	"reflect"
+	"testing"
+)
+
+var articles = map[string][]byte{
+	"post/go":     []byte("Go is a language. Go makes concurrency easy with goroutines."),
+	"post/rust":   []byte("Rust is a language without a garbage collector."),
+	"post/gc":     []byte("The garbage collector of Go is concurrent."),
+	"post/cheese": []byte("A guide to the cheeses of France."),
+}
+
+func openFullText(t *testing.T) *Store {
+	store, _, err := OpenWith("./db", Options{FullText: []string{"post"}})
+	if err != nil {
+		t.Fatalf("Error opening store, %v", err)
+	}
+	return store
+}
+
+func checkSearch(store *Store, query string, limit int, expected []string, t *testing.T) {
+	keys, err := store.Search("post", query, limit)
+	if err != nil {
+		t.Fatalf("Error searching %q, %v", query, err)
+	}
+	if len(expected) == 0 && len(keys) == 0 {
+		return
+	}
+	if !reflect.DeepEqual(expected, keys) {
+		t.Errorf("Expected %v for %q but was %v", expected, query, keys)
+	}
+}
+
+func TestTokenize(t *testing.T) {
+	expected := []string{"garbage", "collector", "go", "1", "20"}
+	if terms := Tokenize("The Garbage-Collector of Go 1.20!"); !reflect.DeepEqual(expected, terms) {
+		t.Errorf("Expected %v but was %v", expected, terms)
+	}
+}
+
+func TestSearch(t *testing.T) {
+	store := openFullText(t)
+	defer func() { tearDown(store, t) }()
+	fillStore(store, articles, t)
+
+	checkSearch(store, "Go", 0, []string{"go", "gc"}, t)
+	checkSearch(store, "garbage collector", 1, []string{"gc"}, t)
+	checkSearch(store, "the of", 0, nil, t)
+
+	store.Put("post/cheese", []byte("Go and cheese."))
+	store.Delete("post/gc")
+	checkSearch(store, "go", 0, []string{"cheese", "go"}, t)
+
+	_, err := store.Search("artist", "go", 0)
+	if _, isRightType := err.(IndexError); !isRightType {
+		t.Errorf("Should have returned an error of type IndexError"+
+			", error was %v",
+			err)
+	}
+}
+
+func TestFullTextIndexIsKeptAcrossOpens(t *testing.T) {
+	store := openFullText(t)
+	fillStore(store, articles, t)
+	if err := store.Close(); err != nil {
+		t.Fatalf("Error closing store, %v", err)
+	}
+	filename := textIndexFilename(expandPath("./db"), "post")
+	if _, err := os.Stat(filename); err != nil {
+		t.Fatalf("Expected full-text index to be written, %v", err)
+	}
+
+	store = openFullText(t)
+	if _, err := os.Stat(filename); !os.IsNotExist(err) {
+		t.Errorf("Expected full-text index file to be removed once read, %v", err)
+	}
+	checkSearch(store, "garbage", 0, []string{"gc", "rust"}, t)
+	if err := store.Close(); err != nil {
+		t.Fatalf("Error closing store, %v", err)
+	}
+
+	// A broken index is built again
+	if err := ioutil.WriteFile(filename, []byte("garbage"), FILE_PERM); err != nil {
+		t.Fatalf("Error corrupting index, %v", err)
+	}
+	store = openFullText(t)
+	defer func() { tearDown(store, t) }()
+	checkSearch(store, "garbage", 0, []string{"gc", "rust"}, t)
+}
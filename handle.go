@@ -0,0 +1,195 @@
+package dskvs
+
+import (
+	"sort"
+	"strings"
+	"sync/atomic"
+)
+
+// A Collection is a handle on a collection of a store.  It names members
+// without their collection, and keeps the collection at hand instead of
+// looking it up at every call, so it's the way to go for hot paths.  It's safe
+// for concurrent use, and stays valid when the collection is deleted or
+// renamed : it then names a collection that is empty, until a member is put
+// again.
+type Collection struct {
+	coll  *collections
+	name  string
+	err   error
+	bound atomic.Value
+}
+
+// A binding is the member that a handle found under its name, and the epoch
+// of the member then.
+type binding struct {
+	m     *member
+	epoch uint32
+}
+
+// Collection returns a handle on collection `coll`, which doesn't need to exist
+// yet.  Should `coll` not be a valid collection name, every call to the handle
+// that can fail returns why, and the others see an empty collection.
+func (s Store) Collection(coll string) *Collection {
+	c := &Collection{
+		coll: s.coll,
+		name: coll,
+		err:  s.coll.checkCollName(coll),
+	}
+	c.bound.Store(binding{})
+	return c
+}
+
+// Name returns the name of the collection.
+func (c *Collection) Name() string {
+	return c.name
+}
+
+// Get returns the value of member `key`, like `Store.Get` would for its full
+// key.
+//
+// ATTENTION : do not modify the value of the slices that are returned to
+// you.
+func (c *Collection) Get(key string) ([]byte, bool, error) {
+	if err := c.checkMember(key); err != nil {
+		return nil, false, err
+	}
+	m := c.member(false)
+	if m == nil {
+		return nil, false, nil
+	}
+	val, ok := m.get(key)
+	return val, ok, nil
+}
+
+// Put saves `value` as member `key`, like `Store.Put` would for its full key.
+func (c *Collection) Put(key string, value []byte) error {
+	if err := c.checkMember(key); err != nil {
+		return err
+	}
+	return c.member(true).put(key, value)
+}
+
+// Delete removes member `key`, like `Store.Delete` would for its full key.
+func (c *Collection) Delete(key string) error {
+	if err := c.checkMember(key); err != nil {
+		return err
+	}
+	m := c.member(false)
+	if m == nil {
+		return errorNoSuchColl(c.name)
+	}
+	m.delete(key)
+	return nil
+}
+
+// Len returns the number of members of the collection.
+func (c *Collection) Len() int {
+	return len(c.livePages())
+}
+
+// Keys returns the members of the collection, sorted.
+func (c *Collection) Keys() []string {
+	pages := c.livePages()
+	keys := make([]string, 0, len(pages))
+	for _, aPage := range pages {
+		keys = append(keys, aPage.key)
+	}
+	return keys
+}
+
+// Iterate calls `fn` with every member of the collection and its value,
+// sorted by member, until `fn` returns false.  Members put or deleted
+// meanwhile may or may not be seen; use a `Snapshot` to read them all as they
+// were at once.
+//
+// ATTENTION : do not modify the values given to `fn`.
+func (c *Collection) Iterate(fn func(key string, value []byte) bool) {
+	for _, aPage := range c.livePages() {
+		// It could have been deleted since
+		value := aPage.get()
+		if value == nil {
+			continue
+		}
+		if !fn(aPage.key, value) {
+			return
+		}
+	}
+}
+
+// Clear removes every member of the collection, like `Store.DeleteAll` would.
+func (c *Collection) Clear() error {
+	if c.err != nil {
+		return c.err
+	}
+	c.coll.deleteCollection(c.name)
+	return nil
+}
+
+// checkMember verifies that `key` names a member of the collection.  The full
+// key is only built to report what's wrong with it.
+func (c *Collection) checkMember(key string) error {
+	if c.err != nil {
+		return c.err
+	}
+	switch {
+	case key == "":
+		return errorNotMember(joinKeys(c.name, key))
+	case len(c.name)+len(CollKeySep)+len(key) > MaxKeyLength:
+		return errorKeyTooLong(joinKeys(c.name, key))
+	case strings.ContainsAny(key, forbiddenKeyBytes):
+		return errorForbiddenByte(joinKeys(c.name, key))
+	case c.coll.opts.Nested && strings.Contains(key, CollKeySep):
+		// It would name a member of a collection within this one
+		return errorNotMember(joinKeys(c.name, key))
+	}
+	return nil
+}
+
+// member returns the member of the collection, creating it if `create` says
+// so.  It is looked up again only once the member it had was deleted or
+// renamed.
+func (c *Collection) member(create bool) *member {
+	if c.err != nil {
+		return nil
+	}
+	b := c.bound.Load().(binding)
+	if b.m != nil && atomic.LoadUint32(&b.m.epoch) == b.epoch {
+		return b.m
+	}
+
+	var m *member
+	if create {
+		m = c.coll.memberOrNew(c.name)
+	} else if found, ok := c.coll.member(c.name); ok {
+		m = found
+	} else {
+		return nil
+	}
+	// The epoch is read after the member was found, so that a change in
+	// between makes the next call look it up again
+	epoch := atomic.LoadUint32(&m.epoch)
+	if current, ok := c.coll.member(c.name); !ok || current != m {
+		return m
+	}
+	c.bound.Store(binding{m, epoch})
+	return m
+}
+
+// livePages returns the pages of the collection that hold a value, sorted by
+// member.
+func (c *Collection) livePages() []*page {
+	m := c.member(false)
+	if m == nil {
+		return nil
+	}
+	var pages []*page
+	for _, aPage := range m.pages() {
+		if aPage.live() {
+			pages = append(pages, aPage)
+		}
+	}
+	sort.Slice(pages, func(i, j int) bool {
+		return pages[i].key < pages[j].key
+	})
+	return pages
+}
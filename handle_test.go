@@ -0,0 +1,92 @@
+package dskvs
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestCollectionHandle(t *testing.T) {
+	store := setUp(t)
+	defer tearDown(store, t)
+
+	artists := store.Collection("artist")
+	if artists.Len() != 0 {
+		t.Errorf("Expected an empty collection, had %d members", artists.Len())
+	}
+	if _, ok, err := artists.Get("daft_punk"); ok || err != nil {
+		t.Errorf("Expected no value nor error, was %v, %v", ok, err)
+	}
+
+	artists.Put("daft_punk", []byte("Discovery"))
+	artists.Put("justice", []byte("Cross"))
+	store.Put("artist/air", []byte("Moon Safari"))
+	if val, _, _ := store.Get("artist/justice"); string(val) != "Cross" {
+		t.Errorf("Expected <Cross> from the store but was <%s>", val)
+	}
+	if val, _, _ := artists.Get("air"); string(val) != "Moon Safari" {
+		t.Errorf("Expected <Moon Safari> from the handle but was <%s>", val)
+	}
+
+	artists.Delete("air")
+	expected := []string{"daft_punk", "justice"}
+	if keys := artists.Keys(); !reflect.DeepEqual(expected, keys) {
+		t.Errorf("Expected keys %v but were %v", expected, keys)
+	}
+	var values []string
+	artists.Iterate(func(key string, value []byte) bool {
+		values = append(values, string(value))
+		return true
+	})
+	if !reflect.DeepEqual([]string{"Discovery", "Cross"}, values) {
+		t.Errorf("Expected values sorted by key, were %v", values)
+	}
+
+	// The handle sees the collection deleted and created again
+	if err := artists.Clear(); err != nil {
+		t.Fatalf("Error clearing collection, %v", err)
+	}
+	if artists.Len() != 0 {
+		t.Errorf("Expected a cleared collection, had %d members", artists.Len())
+	}
+	store.Put("artist/air", []byte("Talkie Walkie"))
+	if val, _, _ := artists.Get("air"); string(val) != "Talkie Walkie" {
+		t.Errorf("Expected <Talkie Walkie> but was <%s>", val)
+	}
+
+	// And doesn't follow it when renamed
+	store.RenameCollection("artist", "band")
+	if artists.Len() != 0 {
+		t.Errorf("Expected the renamed collection to be gone from the handle")
+	}
+	if val, _, _ := store.Collection("band").Get("air"); string(val) != "Talkie Walkie" {
+		t.Errorf("Expected <Talkie Walkie> in renamed collection but was <%s>", val)
+	}
+}
+
+func TestErrorWhenCollectionHandleIsInvalid(t *testing.T) {
+	store := setUp(t)
+	defer tearDown(store, t)
+
+	for _, coll := range []string{"", "artist/daft_punk", historyDir} {
+		err := store.Collection(coll).Put("discovery", []byte("2001"))
+		if _, isRightType := err.(KeyError); !isRightType {
+			t.Errorf("Should have returned an error of type KeyError"+
+				" for %q, error was %v",
+				coll, err)
+		}
+	}
+
+	artists := store.Collection("artist")
+	for _, key := range []string{"", "daft\x00punk"} {
+		err := artists.Put(key, []byte("Discovery"))
+		if _, isRightType := err.(KeyError); !isRightType {
+			t.Errorf("Should have returned an error of type KeyError"+
+				" for %q, error was %v",
+				key, err)
+		}
+	}
+
+	if err := artists.Delete("daft_punk"); err == nil {
+		t.Errorf("Should have failed to delete from a missing collection")
+	}
+}
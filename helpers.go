@@ -7,12 +7,37 @@ import (
 	"strings"
 )
 
+// reservedColls are directories that dskvs keeps under the storage path for
+// its own use, and that can't be used as collections.
+var reservedColls = map[string]bool{
+	quarantineDir: true,
+	historyDir:    true,
+	trashDir:      true,
+	stagingDir:    true,
+	textDir:       true,
+}
+
+func isReservedColl(coll string) bool {
+	return reservedColls[coll]
+}
+
 func checkKeyValid(key string) error {
 	idxSeperator := strings.Index(key, CollKeySep)
 	if idxSeperator == 0 {
 		return errorNoColl(key)
 	} else if key == "" {
 		return errorEmptyKey()
+	} else if len(key) > MaxKeyLength {
+		return errorKeyTooLong(key)
+	} else if strings.ContainsAny(key, forbiddenKeyBytes) {
+		return errorForbiddenByte(key)
+	}
+	coll := key
+	if idxSeperator > 0 {
+		coll = key[:idxSeperator]
+	}
+	if isReservedColl(coll) {
+		return errorReservedColl(key)
 	}
 	return nil
 }
@@ -29,11 +54,29 @@ func isCollectionKey(key string) bool {
 	return false
 }
 
+// checkCollName verifies that `coll` is the bare name of a collection, with
+// no separator.
+func checkCollName(coll string) error {
+	if err := checkKeyValid(coll); err != nil {
+		return err
+	}
+	if strings.Contains(coll, CollKeySep) {
+		return errorNoColl(coll)
+	}
+	return nil
+}
+
 // Takes a fullkey and splits it in a (collection, member) tuple.  If member
-// is nil, the fullkey is a request for the collection as a whole
+// is nil, the fullkey is a request for the collection as a whole.  The member
+// doesn't hold the separator.
 func splitKeys(fullKey string) (string, string) {
 	idx := strings.Index(fullKey, CollKeySep)
-	return fullKey[:idx], fullKey[idx:]
+	return fullKey[:idx], fullKey[idx+len(CollKeySep):]
+}
+
+// joinKeys is the full key of member `key` of collection `coll`.
+func joinKeys(coll, key string) string {
+	return coll + CollKeySep + key
 }
 
 func isValidPath(path string) bool {
@@ -0,0 +1,364 @@
+package dskvs
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// historyDir is where the history of the members of collections that keep
+// one is written, under the storage path of a store.
+const historyDir = ".history"
+
+// A HistoryPolicy tells how much of the history of its members a collection
+// keeps.  The zero value keeps everything.
+type HistoryPolicy struct {
+	// Versions is how many revisions of a member are kept, its current
+	// value included.  Zero doesn't limit them.
+	Versions int
+	// MaxAge is how long a revision is kept after it was replaced.  Zero
+	// doesn't limit it.
+	MaxAge time.Duration
+}
+
+// A Revision is a value that a member held from Time on.  Deleted revisions
+// tell when the member was deleted.
+type Revision struct {
+	Time    time.Time
+	Value   []byte
+	Deleted bool
+}
+
+// History returns the revisions of member `fullKey`, oldest first, the
+// current one last.  The collection must keep a history, as
+// `Options.History` says.  Deleted members keep theirs until it expires.
+//
+// ATTENTION : do not modify the value of the slices that are returned to
+// you.
+func (s Store) History(fullKey string) ([]Revision, error) {
+
+	if err := s.coll.checkKey(fullKey); err != nil {
+		return nil, err
+	}
+
+	if s.coll.isCollectionKey(fullKey) {
+		return nil, errorGetIsColl(fullKey)
+	}
+
+	coll, key := s.coll.splitKeys(fullKey)
+	if s.opts.historyFor(coll) == nil {
+		return nil, errorNoHistory(fullKey)
+	}
+
+	var aPage *page
+	if m, ok := s.coll.member(coll); ok {
+		m.RLock()
+		aPage = m.entries[key]
+		m.RUnlock()
+	}
+	if aPage == nil {
+		aPage = s.coll.histories.dead(joinKeys(coll, key))
+	}
+	if aPage == nil {
+		return nil, nil
+	}
+
+	aPage.RLock()
+	revisions := append([]Revision(nil), aPage.revisions...)
+	aPage.RUnlock()
+	return revisions, nil
+}
+
+// GetAt returns the value that member `fullKey` held at time `at`, like `Get`
+// would have then, from its history.
+func (s Store) GetAt(fullKey string, at time.Time) ([]byte, bool, error) {
+	revisions, err := s.History(fullKey)
+	if err != nil {
+		return nil, false, err
+	}
+	for i := len(revisions) - 1; i >= 0; i-- {
+		if revisions[i].Time.After(at) {
+			continue
+		}
+		if revisions[i].Deleted {
+			return nil, false, nil
+		}
+		return revisions[i].Value, true, nil
+	}
+	return nil, false, nil
+}
+
+// histories keeps the pages of deleted members whose collection keeps a
+// history, until it expires or the member is put again.
+type histories struct {
+	sync.Mutex
+	deadPages map[string]*page
+}
+
+func newHistories() *histories {
+	return &histories{deadPages: make(map[string]*page)}
+}
+
+// retire keeps a deleted page for its history.
+func (h *histories) retire(aPage *page) {
+	h.Lock()
+	h.deadPages[joinKeys(aPage.coll, aPage.key)] = aPage
+	h.Unlock()
+}
+
+// revive returns the history of member `fullKey`, which is put again.
+func (h *histories) revive(fullKey string) []Revision {
+	h.Lock()
+	aPage, ok := h.deadPages[fullKey]
+	delete(h.deadPages, fullKey)
+	h.Unlock()
+	if !ok {
+		return nil
+	}
+	aPage.RLock()
+	revisions := aPage.revisions
+	aPage.RUnlock()
+	return revisions
+}
+
+func (h *histories) dead(fullKey string) *page {
+	h.Lock()
+	aPage := h.deadPages[fullKey]
+	h.Unlock()
+	return aPage
+}
+
+// prune forgets the revisions of deleted members that expired at time `now`,
+// and rewrites their history files.  It holds the histories meanwhile, so that
+// a member put again doesn't have its history overwritten.
+func (h *histories) prune(now time.Time) {
+	h.Lock()
+	defer h.Unlock()
+	for fullKey, aPage := range h.deadPages {
+		aPage.Lock()
+		pruned := aPage.prune(now)
+		empty := len(aPage.revisions) == 0
+		aPage.Unlock()
+		if !pruned {
+			continue
+		}
+		_ = saveHistory(aPage)
+		if empty {
+			delete(h.deadPages, fullKey)
+		}
+	}
+}
+
+// record adds a revision to the history of the page, forgetting the oldest
+// ones beyond what its policy keeps.  The page must be locked.
+func (p *page) record(revision Revision) {
+	p.revisions = append(p.revisions, revision)
+	if n := p.hist.Versions; n > 0 && len(p.revisions) > n {
+		p.revisions = append([]Revision(nil), p.revisions[len(p.revisions)-n:]...)
+	}
+}
+
+// prune forgets the revisions that the policy of the page doesn't keep at
+// time `now`, and tells if there were any.  The page must be locked.
+func (p *page) prune(now time.Time) bool {
+	revisions := p.revisions
+	if n := p.hist.Versions; n > 0 && len(revisions) > n {
+		revisions = revisions[len(revisions)-n:]
+	}
+	if p.hist.MaxAge > 0 {
+		expired := now.Add(-p.hist.MaxAge)
+		for len(revisions) > 1 && revisions[1].Time.Before(expired) {
+			revisions = revisions[1:]
+		}
+		// The deletion itself expires, and the member is forgotten
+		if len(revisions) == 1 && revisions[0].Deleted &&
+			revisions[0].Time.Before(expired) {
+			revisions = nil
+		}
+	}
+	if len(revisions) == len(p.revisions) {
+		return false
+	}
+	p.revisions = append([]Revision(nil), revisions...)
+	return true
+}
+
+// pruneHistory forgets the revisions that the collections of the store don't
+// keep at time `now`, and has the janitor rewrite their history files.
+func (s *Store) pruneHistory(now time.Time) {
+	for _, m := range s.coll.snapshot() {
+		if m.hist == nil {
+			continue
+		}
+		for _, aPage := range m.pages() {
+			aPage.Lock()
+			pruned := aPage.prune(now)
+			aPage.Unlock()
+			if pruned {
+				aPage.touch()
+			}
+		}
+	}
+	s.coll.histories.prune(now)
+}
+
+// historyFilename is where the history of a page is written.
+func historyFilename(aPage *page) string {
+	return filepath.Join(aPage.basepath, historyDir, aPage.coll,
+		filepath.Base(generateFilename(aPage)))
+}
+
+// saveHistory writes the history file of a page, or deletes it once the
+// history is empty.  It is written in the fileformat of page files, holding
+// the encoded revisions as value, so that it is compressed and encrypted
+// alike.
+func saveHistory(aPage *page) error {
+	if aPage.hist == nil {
+		return nil
+	}
+	filename := historyFilename(aPage)
+
+	aPage.RLock()
+	if len(aPage.revisions) == 0 {
+		aPage.RUnlock()
+		return deleteFile(filename)
+	}
+	data, _, err := encodePage(&page{
+		basepath: aPage.basepath,
+		coll:     aPage.coll,
+		key:      aPage.key,
+		value:    encodeRevisions(aPage.revisions),
+		comp:     aPage.comp,
+		keys:     aPage.keys,
+	})
+	aPage.RUnlock()
+	if err != nil {
+		log.Printf("Couldn't get history of page: %v", err)
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(filename), DIR_PERM); err != nil {
+		log.Printf("Couldn't create history folder of <%s> : %v", filename, err)
+		return err
+	}
+	if err := writeFileAtomic(filename, data); err != nil {
+		log.Printf("Couldn't write history file <%s> : %v", filename, err)
+		return err
+	}
+	return nil
+}
+
+// encodeRevisions encodes every revision as its time in nanoseconds, a byte
+// telling if it is a deletion, and its value prefixed by its length.
+func encodeRevisions(revisions []Revision) []byte {
+	buf := new(bytes.Buffer)
+	for _, revision := range revisions {
+		var deleted uint8
+		if revision.Deleted {
+			deleted = 1
+		}
+		_ = binary.Write(buf, binary.BigEndian, revision.Time.UnixNano())
+		_ = buf.WriteByte(deleted)
+		_ = binary.Write(buf, binary.BigEndian, uint32(len(revision.Value)))
+		_, _ = buf.Write(revision.Value)
+	}
+	return buf.Bytes()
+}
+
+func decodeRevisions(filename string, data []byte) ([]Revision, error) {
+	var revisions []Revision
+	buf := bytes.NewReader(data)
+	for buf.Len() != 0 {
+		var nanos int64
+		var deleted uint8
+		var length uint32
+		if err := binary.Read(buf, binary.BigEndian, &nanos); err != nil {
+			return nil, errorBadHistory(filename)
+		}
+		if err := binary.Read(buf, binary.BigEndian, &deleted); err != nil {
+			return nil, errorBadHistory(filename)
+		}
+		if err := binary.Read(buf, binary.BigEndian, &length); err != nil {
+			return nil, errorBadHistory(filename)
+		}
+		if uint64(length) > uint64(buf.Len()) {
+			return nil, errorBadHistory(filename)
+		}
+		value := make([]byte, length)
+		_, _ = buf.Read(value)
+		revisions = append(revisions, Revision{
+			Time:    time.Unix(0, nanos),
+			Value:   value,
+			Deleted: deleted != 0,
+		})
+	}
+	return revisions, nil
+}
+
+// loadHistory reads the history files of the collections of `s` that keep a
+// history, and gives them to their pages, or keeps them for deleted members.
+func (j *janitor) loadHistory(s *Store, report *OpenReport) error {
+	keys := s.opts.keyProvider()
+	for coll, policy := range s.opts.History {
+		policy := policy
+		dir := filepath.Join(s.storagePath, historyDir, coll)
+		files, err := ioutil.ReadDir(dir)
+		if os.IsNotExist(err) {
+			continue
+		} else if err != nil {
+			report.skipped(dir, err)
+			continue
+		}
+
+		for _, file := range files {
+			filename := filepath.Join(dir, file.Name())
+			if file.IsDir() {
+				// The history of a collection within this one
+				continue
+			}
+			if !file.Mode().IsRegular() || strings.HasSuffix(file.Name(), tempSuffix) {
+				report.skipped(filename, errorIrregularFile(filename))
+				continue
+			}
+			hPage, err := readPageFile(filename, keys)
+			if _, wrongKey := err.(CryptoError); wrongKey {
+				return err
+			} else if err != nil {
+				report.skipped(filename, err)
+				continue
+			}
+			revisions, err := decodeRevisions(filename, hPage.value)
+			if err != nil {
+				report.skipped(filename, err)
+				continue
+			}
+
+			if m, ok := s.coll.members[coll]; ok {
+				if aPage, ok := m.entries[hPage.key]; ok {
+					aPage.revisions = revisions
+					relocate(filename, historyFilename(aPage))
+					continue
+				}
+			}
+			dead := &page{
+				isDeleted: true,
+				basepath:  s.storagePath,
+				coll:      coll,
+				key:       hPage.key,
+				comp:      s.opts.compressionFor(coll),
+				keys:      keys,
+				hist:      &policy,
+				revisions: revisions,
+			}
+			relocate(filename, historyFilename(dead))
+			s.coll.histories.retire(dead)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,88 @@
+package dskvs
+
+import (
+	"testing"
+	"time"
+)
+
+func TestHistoryIsKeptAndPersisted(t *testing.T) {
+	opts := Options{History: map[string]HistoryPolicy{"doc": {Versions: 3}}}
+	store, _, err := OpenWith("./db", opts)
+	if err != nil {
+		t.Fatalf("Error opening store, %v", err)
+	}
+	for _, value := range []string{"v1", "v2", "v3", "v4"} {
+		store.Put("doc/readme", []byte(value))
+	}
+	store.Delete("doc/readme")
+
+	revisions, err := store.History("doc/readme")
+	if err != nil {
+		t.Fatalf("Error getting history, %v", err)
+	}
+	if len(revisions) != 3 || string(revisions[0].Value) != "v3" ||
+		!revisions[2].Deleted {
+		t.Fatalf("Expected v3, v4 and the deletion, was %v", revisions)
+	}
+
+	val, ok, err := store.GetAt("doc/readme", revisions[1].Time)
+	if err != nil || !ok || string(val) != "v4" {
+		t.Errorf("Expected v4 back in time, was <%s>, ok=%v, %v", val, ok, err)
+	}
+	if _, ok, _ := store.GetAt("doc/readme", time.Now()); ok {
+		t.Errorf("Member should be deleted by now")
+	}
+	if err := store.Close(); err != nil {
+		t.Fatalf("Error closing store, %v", err)
+	}
+
+	store, _, err = OpenWith("./db", opts)
+	if err != nil {
+		t.Fatalf("Error opening store, %v", err)
+	}
+	defer tearDown(store, t)
+
+	store.Put("doc/readme", []byte("v5"))
+	revisions, _ = store.History("doc/readme")
+	if len(revisions) != 3 || string(revisions[2].Value) != "v5" ||
+		!revisions[1].Deleted {
+		t.Errorf("Expected history to survive a reopen, was %v", revisions)
+	}
+}
+
+func TestHistoryIsPruned(t *testing.T) {
+	opts := Options{History: map[string]HistoryPolicy{"doc": {MaxAge: time.Hour}}}
+	store, _, err := OpenWith("./db", opts)
+	if err != nil {
+		t.Fatalf("Error opening store, %v", err)
+	}
+	defer tearDown(store, t)
+
+	store.Put("doc/readme", []byte("v1"))
+	store.Put("doc/readme", []byte("v2"))
+	store.Put("doc/gone", []byte("v1"))
+	store.Delete("doc/gone")
+
+	store.pruneHistory(time.Now().Add(2 * time.Hour))
+
+	revisions, _ := store.History("doc/readme")
+	if len(revisions) != 1 || string(revisions[0].Value) != "v2" {
+		t.Errorf("Expected only the current revision left, was %v", revisions)
+	}
+	if revisions, _ := store.History("doc/gone"); len(revisions) != 0 {
+		t.Errorf("Expected deleted member to be forgotten, was %v", revisions)
+	}
+}
+
+func TestErrorWhenCollectionKeepsNoHistory(t *testing.T) {
+	store := setUp(t)
+	defer tearDown(store, t)
+	store.Put("artist/daft_punk", []byte("Discovery"))
+
+	_, err := store.History("artist/daft_punk")
+	if _, isRightType := err.(KeyError); !isRightType {
+		t.Errorf("Should have returned an error of type KeyError"+
+			", error was %v",
+			err)
+	}
+}
@@ -0,0 +1,448 @@
+package dskvs
+
+import (
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+)
+
+// An Extractor returns the terms under which member `key` of a collection,
+// holding `value`, is found in an index.  It's called while the member is
+// locked, so it must not use the store.
+type Extractor func(key string, value []byte) []string
+
+// An Index is a secondary index on the values of collection Coll, which maps
+// the terms that Extract returns to the members holding them.  When Unique,
+// a `Put` giving a member a term that another member already has fails.
+//
+// Without Extract, the index holds the JSON field at path Field of the values,
+// and `Query` uses it.
+type Index struct {
+	Coll    string
+	Name    string
+	Extract Extractor
+	Field   string
+	Unique  bool
+}
+
+// CreateIndex creates index `name` on collection `coll`, which doesn't need to
+// exist yet, from the members it holds now.  The store keeps it up to date on
+// every change of the collection, in memory only : have `Options.Indexes`
+// create it again every time the store is opened.
+func (s Store) CreateIndex(coll, name string, extractor Extractor) error {
+	return s.createIndex(Index{Coll: coll, Name: name, Extract: extractor})
+}
+
+// CreateUniqueIndex is like CreateIndex, but no two members may have the same
+// term in the index.  It fails if some already do.
+func (s Store) CreateUniqueIndex(coll, name string, extractor Extractor) error {
+	return s.createIndex(Index{Coll: coll, Name: name, Extract: extractor, Unique: true})
+}
+
+// CreateFieldIndex creates an index on collection `coll` of the JSON field at
+// path `field` of its values, named after it, for `Query` to use.  Fields
+// holding an object or an array are not indexed.
+func (s Store) CreateFieldIndex(coll, field string) error {
+	return s.createIndex(Index{Coll: coll, Name: field, Field: field})
+}
+
+func (s Store) createIndex(spec Index) error {
+	idx, err := s.coll.addIndex(spec)
+	if err != nil {
+		return err
+	}
+	if err := s.coll.fillIndex(idx); err != nil {
+		s.coll.indexSet(spec.Coll).drop(idx)
+		return err
+	}
+	return nil
+}
+
+// DropIndex deletes index `name` of collection `coll`.
+func (s Store) DropIndex(coll, name string) error {
+	if err := s.coll.checkCollName(coll); err != nil {
+		return err
+	}
+	set := s.coll.indexSet(coll)
+	idx, ok := set.get(name)
+	if !ok {
+		return errorNoSuchIndex(coll, name)
+	}
+	set.drop(idx)
+	return nil
+}
+
+// Lookup returns the members of collection `coll` that have term `term` in
+// index `index`, sorted.  Should the index still be built, as it is in the
+// background when the store is opened, it waits until it is.
+func (s Store) Lookup(coll, index, term string) ([]string, error) {
+	if err := s.coll.checkCollName(coll); err != nil {
+		return nil, err
+	}
+	set := s.coll.indexSet(coll)
+	idx, ok := set.get(index)
+	if !ok {
+		return nil, errorNoSuchIndex(coll, index)
+	}
+	<-idx.ready
+	return set.lookup(idx, term), nil
+}
+
+// addIndexes adds the indexes of `Options.Indexes`, empty, before the store
+// is loaded.
+func (s *Store) addIndexes() ([]*index, error) {
+	var added []*index
+	specs := append(append([]Index(nil), s.opts.Indexes...), s.textIndexes()...)
+	for _, spec := range specs {
+		idx, err := s.coll.addIndex(spec)
+		if err != nil {
+			return nil, err
+		}
+		added = append(added, idx)
+	}
+	return added, nil
+}
+
+// fillIndexes fills the indexes added by `addIndexes` in the background, once
+// the store is loaded.  Full-text indexes are read from their file instead, if
+// they can be.
+func (s *Store) fillIndexes(indexes []*index) {
+	for _, idx := range indexes {
+		idx := idx
+		if idx.Name == textIndexName {
+			if s.loadTextIndex(idx) {
+				continue
+			}
+			idx.reset()
+		}
+		jan.background(s, func() {
+			if err := s.coll.fillIndex(idx); err != nil {
+				// The members holding the term are all found, but
+				// none may take it until they let it go
+				log.Printf("Index <%s> of collection <%s> : %v", idx.Name, idx.Coll, err)
+			}
+		})
+	}
+	// Should the store crash, they would not match it anymore
+	textPath := filepath.Join(s.storagePath, textDir)
+	if err := os.RemoveAll(textPath); err != nil {
+		log.Printf("Couldn't remove full-text indexes <%s> : %v", textPath, err)
+	}
+}
+
+// An index holds the terms of the members of a collection, as its extractor
+// gives them.  It is guarded by the lock of its set.
+type index struct {
+	Index
+	// ready is closed once the index holds the members that were there
+	// when it was created.
+	ready chan struct{}
+	// terms count how often every member has each term, and keys hold the
+	// terms of every member, as extracted.  total is the number of terms of
+	// all members.
+	terms map[string]map[string]int
+	keys  map[string][]string
+	total int
+}
+
+// reset empties the index.
+func (idx *index) reset() {
+	idx.terms = make(map[string]map[string]int)
+	idx.keys = make(map[string][]string)
+	idx.total = 0
+}
+
+// set gives member `key` the terms `terms`, instead of those it had.
+func (idx *index) set(key string, terms []string) {
+	for _, term := range idx.keys[key] {
+		if idx.terms[term][key]--; idx.terms[term][key] == 0 {
+			delete(idx.terms[term], key)
+		}
+		if len(idx.terms[term]) == 0 {
+			delete(idx.terms, term)
+		}
+	}
+	idx.total -= len(idx.keys[key])
+	if len(terms) == 0 {
+		delete(idx.keys, key)
+		return
+	}
+	idx.keys[key] = terms
+	idx.total += len(terms)
+	for _, term := range terms {
+		if idx.terms[term] == nil {
+			idx.terms[term] = make(map[string]int)
+		}
+		idx.terms[term][key]++
+	}
+}
+
+// conflict returns the error of giving member `key` the terms `terms`, if
+// another member has one and the index is unique.
+func (idx *index) conflict(key string, terms []string) error {
+	if !idx.Unique {
+		return nil
+	}
+	for _, term := range terms {
+		for other := range idx.terms[term] {
+			if other != key {
+				return errorUniqueTerm(idx.Coll, idx.Name, term, other)
+			}
+		}
+	}
+	return nil
+}
+
+// An indexSet holds the indexes of a collection.  It outlives the members of
+// the collection, so that a collection deleted then put again keeps them.
+// Pages use that of their member, which may be nil, while they are locked.
+type indexSet struct {
+	sync.RWMutex
+	indexes map[string]*index
+}
+
+// indexSet returns the index set of collection `coll`, creating it if needed.
+func (c *collections) indexSet(coll string) *indexSet {
+	c.indexLock.Lock()
+	set, ok := c.indexSets[coll]
+	if !ok {
+		set = &indexSet{indexes: make(map[string]*index)}
+		c.indexSets[coll] = set
+	}
+	c.indexLock.Unlock()
+	return set
+}
+
+// addIndex adds an empty index to its collection, from where changes keep it up
+// to date.
+func (c *collections) addIndex(spec Index) (*index, error) {
+	if err := c.checkCollName(spec.Coll); err != nil {
+		return nil, err
+	}
+	if spec.Extract != nil {
+		// Queries can't tell what it holds
+		spec.Field = ""
+	} else if spec.Field != "" {
+		spec.Extract = fieldExtractor(spec.Field)
+	}
+	if spec.Name == "" || spec.Extract == nil {
+		return nil, errorBadIndex(spec.Coll, spec.Name)
+	}
+
+	idx := &index{
+		Index: spec,
+		ready: make(chan struct{}),
+	}
+	idx.reset()
+	set := c.indexSet(spec.Coll)
+	set.Lock()
+	defer set.Unlock()
+	if _, exists := set.indexes[spec.Name]; exists {
+		return nil, errorIndexExists(spec.Coll, spec.Name)
+	}
+	set.indexes[spec.Name] = idx
+	return idx, nil
+}
+
+// fillIndex adds the members of its collection to an index added by
+// `addIndex`, then tells that it's ready.  It returns the first conflict of a
+// unique index, but fills it anyway.
+func (c *collections) fillIndex(idx *index) error {
+	defer close(idx.ready)
+
+	m, ok := c.member(idx.Coll)
+	if !ok {
+		return nil
+	}
+	set := c.indexSet(idx.Coll)
+	var err error
+	for _, aPage := range m.pages() {
+		// Changes made to the page since the index was added updated it
+		// already, and those made while it's locked wait for it
+		aPage.RLock()
+		if aPage.indexes == set && !aPage.isDeleted && aPage.value != nil {
+			terms := idx.Extract(aPage.key, aPage.plainValue())
+			set.Lock()
+			if conflict := idx.conflict(aPage.key, terms); conflict != nil && err == nil {
+				err = conflict
+			}
+			idx.set(aPage.key, terms)
+			set.Unlock()
+		}
+		aPage.RUnlock()
+	}
+	return err
+}
+
+// isReady tells if the index was filled already.
+func (idx *index) isReady() bool {
+	select {
+	case <-idx.ready:
+		return true
+	default:
+		return false
+	}
+}
+
+// byField returns a filled index of JSON field `field`, if the set has one.
+func (set *indexSet) byField(field string) (*index, bool) {
+	set.RLock()
+	defer set.RUnlock()
+	for _, idx := range set.indexes {
+		if idx.Field == field && idx.isReady() {
+			return idx, true
+		}
+	}
+	return nil, false
+}
+
+// lookup returns the members that have term `term` in the index, sorted.
+func (set *indexSet) lookup(idx *index, term string) []string {
+	set.RLock()
+	keys := make([]string, 0, len(idx.terms[term]))
+	for key := range idx.terms[term] {
+		keys = append(keys, key)
+	}
+	set.RUnlock()
+	sort.Strings(keys)
+	return keys
+}
+
+func (set *indexSet) get(name string) (*index, bool) {
+	set.RLock()
+	idx, ok := set.indexes[name]
+	set.RUnlock()
+	return idx, ok
+}
+
+func (set *indexSet) drop(idx *index) {
+	set.Lock()
+	if set.indexes[idx.Name] == idx {
+		delete(set.indexes, idx.Name)
+	}
+	set.Unlock()
+}
+
+// waitUnique waits until the unique indexes of the set are filled, so that
+// they know every conflict.  The page being written must not be locked, since
+// filling them reads it.
+func (set *indexSet) waitUnique() {
+	if set == nil {
+		return
+	}
+	var pending []chan struct{}
+	set.RLock()
+	for _, idx := range set.indexes {
+		if idx.Unique && !idx.isReady() {
+			pending = append(pending, idx.ready)
+		}
+	}
+	set.RUnlock()
+	for _, ready := range pending {
+		<-ready
+	}
+}
+
+// checkAll returns the first conflict in a unique index of the set that
+// giving members `values` their value would make, with each other or with the
+// members it holds.
+func (set *indexSet) checkAll(values map[string][]byte) error {
+	set.RLock()
+	defer set.RUnlock()
+	for _, idx := range set.indexes {
+		if !idx.Unique {
+			continue
+		}
+		owners := make(map[string]string)
+		for key, value := range values {
+			terms := idx.Extract(key, value)
+			if err := idx.conflict(key, terms); err != nil {
+				return err
+			}
+			for _, term := range terms {
+				if other, taken := owners[term]; taken && other != key {
+					return errorUniqueTerm(idx.Coll, idx.Name, term, other)
+				}
+				owners[term] = key
+			}
+		}
+	}
+	return nil
+}
+
+// checkUnique returns the first conflict that giving collection `coll` the
+// pages `pages` would make in its unique indexes.  The pages must be locked,
+// or not shared yet.
+func (c *collections) checkUnique(coll string, pages []*page) error {
+	values := make(map[string][]byte, len(pages))
+	for _, aPage := range pages {
+		if !aPage.isDeleted && aPage.value != nil {
+			values[aPage.key] = aPage.plainValue()
+		}
+	}
+	return c.indexSet(coll).checkAll(values)
+}
+
+// extract returns the terms of member `key` holding `value` in every index of
+// the set.
+func (set *indexSet) extract(key string, value []byte) map[*index][]string {
+	if set == nil {
+		return nil
+	}
+	set.RLock()
+	indexes := make([]*index, 0, len(set.indexes))
+	for _, idx := range set.indexes {
+		indexes = append(indexes, idx)
+	}
+	set.RUnlock()
+
+	if len(indexes) == 0 {
+		return nil
+	}
+	extracted := make(map[*index][]string, len(indexes))
+	for _, idx := range indexes {
+		extracted[idx] = idx.Extract(key, value)
+	}
+	return extracted
+}
+
+// put gives member `key` the terms that `extract` returned.  Unless `force`
+// says so, it fails without changing anything if a unique index conflicts.
+func (set *indexSet) put(key string, extracted map[*index][]string, force bool) error {
+	if set == nil || len(extracted) == 0 {
+		return nil
+	}
+	set.Lock()
+	defer set.Unlock()
+	if !force {
+		for idx, terms := range extracted {
+			if set.indexes[idx.Name] != idx {
+				continue
+			}
+			if err := idx.conflict(key, terms); err != nil {
+				return err
+			}
+		}
+	}
+	for idx, terms := range extracted {
+		// It could have been dropped since
+		if set.indexes[idx.Name] == idx {
+			idx.set(key, terms)
+		}
+	}
+	return nil
+}
+
+// remove takes member `key` out of every index of the set.
+func (set *indexSet) remove(key string) {
+	if set == nil {
+		return
+	}
+	set.Lock()
+	for _, idx := range set.indexes {
+		idx.set(key, nil)
+	}
+	set.Unlock()
+}
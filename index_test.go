@@ -0,0 +1,220 @@
+package dskvs
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// byGenre indexes "<title>,<genre>" values by genre.
+func byGenre(key string, value []byte) []string {
+	parts := strings.SplitN(string(value), ",", 2)
+	if len(parts) < 2 {
+		return nil
+	}
+	return []string{parts[1]}
+}
+
+func checkLookup(store *Store, coll, index, term string, expected []string, t *testing.T) {
+	keys, err := store.Lookup(coll, index, term)
+	if err != nil {
+		t.Fatalf("Error looking up %s in %s, %v", term, index, err)
+	}
+	if len(expected) == 0 && len(keys) == 0 {
+		return
+	}
+	if !reflect.DeepEqual(expected, keys) {
+		t.Errorf("Expected %v for %s but was %v", expected, term, keys)
+	}
+}
+
+func TestIndex(t *testing.T) {
+	store := setUp(t)
+	defer tearDown(store, t)
+
+	store.Put("artist/daft_punk", []byte("Discovery,house"))
+	store.Put("artist/justice", []byte("Cross,electro"))
+	if err := store.CreateIndex("artist", "genre", byGenre); err != nil {
+		t.Fatalf("Error creating index, %v", err)
+	}
+	checkLookup(store, "artist", "genre", "house", []string{"daft_punk"}, t)
+
+	store.Put("artist/air", []byte("Moon Safari,electro"))
+	store.Put("artist/justice", []byte("Cross,house"))
+	checkLookup(store, "artist", "genre", "house", []string{"daft_punk", "justice"}, t)
+	checkLookup(store, "artist", "genre", "electro", []string{"air"}, t)
+
+	store.Delete("artist/daft_punk")
+	checkLookup(store, "artist", "genre", "house", []string{"justice"}, t)
+
+	// The index outlives the collection, and doesn't follow it
+	store.RenameCollection("artist", "band")
+	checkLookup(store, "artist", "genre", "house", nil, t)
+	store.Put("artist/daft_punk", []byte("Homework,house"))
+	checkLookup(store, "artist", "genre", "house", []string{"daft_punk"}, t)
+	store.DeleteAll("artist")
+	checkLookup(store, "artist", "genre", "house", nil, t)
+
+	_, err := store.Lookup("band", "genre", "house")
+	if _, isRightType := err.(IndexError); !isRightType {
+		t.Errorf("Should have returned an error of type IndexError"+
+			", error was %v",
+			err)
+	}
+	if err := store.CreateIndex("artist", "genre", byGenre); err == nil {
+		t.Errorf("Should have refused to create the index twice")
+	}
+}
+
+func TestUniqueIndex(t *testing.T) {
+	store := setUp(t)
+	defer tearDown(store, t)
+
+	store.Put("artist/daft_punk", []byte("Discovery,house"))
+	store.Put("artist/justice", []byte("Cross,house"))
+	err := store.CreateUniqueIndex("artist", "genre", byGenre)
+	if _, isRightType := err.(IndexError); !isRightType {
+		t.Errorf("Should have refused a unique index on conflicting members"+
+			", error was %v",
+			err)
+	}
+
+	store.Put("artist/justice", []byte("Cross,electro"))
+	if err := store.CreateUniqueIndex("artist", "genre", byGenre); err != nil {
+		t.Fatalf("Error creating index, %v", err)
+	}
+	err = store.Put("artist/air", []byte("Moon Safari,electro"))
+	if _, isRightType := err.(IndexError); !isRightType {
+		t.Errorf("Should have returned an error of type IndexError"+
+			", error was %v",
+			err)
+	}
+	if _, ok, _ := store.Get("artist/air"); ok {
+		t.Errorf("Conflicting value was put anyway")
+	}
+	if err := store.Put("artist/justice", []byte("Audio, Video, Disco,electro")); err != nil {
+		t.Errorf("Member should keep its own term, %v", err)
+	}
+}
+
+func TestUniqueIndexRefusesConflictsWhileFilling(t *testing.T) {
+	store := setUp(t)
+	for i := 0; i < 1000; i++ {
+		store.Put(fmt.Sprintf("user/%d", i), []byte(fmt.Sprintf("name%d", i)))
+	}
+	if err := store.Close(); err != nil {
+		t.Fatalf("Error closing store, %v", err)
+	}
+
+	store, _, err := OpenWith("./db", Options{Indexes: []Index{{
+		Coll: "user",
+		Name: "name",
+		Extract: func(key string, value []byte) []string {
+			return []string{string(value)}
+		},
+		Unique: true,
+	}}})
+	if err != nil {
+		t.Fatalf("Error opening store, %v", err)
+	}
+	defer func() { tearDown(store, t) }()
+	err = store.Put("user/copycat", []byte("name999"))
+	if _, isRightType := err.(IndexError); !isRightType {
+		t.Errorf("Should have returned an error of type IndexError"+
+			", error was %v",
+			err)
+	}
+}
+
+func TestUniqueIndexRefusesConflictingCollections(t *testing.T) {
+	store := setUp(t)
+	defer tearDown(store, t)
+
+	store.Put("artist/daft_punk", []byte("Discovery,house"))
+	store.Put("artist/justice", []byte("Cross,house"))
+	if err := store.CreateUniqueIndex("band", "genre", byGenre); err != nil {
+		t.Fatalf("Error creating index, %v", err)
+	}
+
+	for name, err := range map[string]error{
+		"copy":   store.CopyCollection("artist", "band"),
+		"rename": store.RenameCollection("artist", "band"),
+	} {
+		if _, isRightType := err.(IndexError); !isRightType {
+			t.Errorf("%s: should have returned an error of type IndexError"+
+				", error was %v",
+				name,
+				err)
+		}
+	}
+	if values, _ := store.GetAll("band"); len(values) != 0 {
+		t.Errorf("Expected no member in band, had %d", len(values))
+	}
+	if values, _ := store.GetAll("artist"); len(values) != 2 {
+		t.Errorf("Expected artist to keep its members, had %d", len(values))
+	}
+}
+
+func TestRejectedPutLeavesNoMember(t *testing.T) {
+	store := setUp(t)
+	if err := store.CreateUniqueIndex("user", "name", func(key string, value []byte) []string {
+		return []string{string(value)}
+	}); err != nil {
+		t.Fatalf("Error creating index, %v", err)
+	}
+	if err := store.Put("user/a", []byte("x")); err != nil {
+		t.Fatalf("Error putting data in, %v", err)
+	}
+	if err := store.Put("user/b", []byte("x")); err == nil {
+		t.Fatalf("Should have refused a conflicting value")
+	}
+
+	report, err := store.Verify(context.Background())
+	if err != nil {
+		t.Fatalf("Error verifying store, %v", err)
+	}
+	if len(report.Problems) != 0 {
+		t.Errorf("Expected no problem after a rejected put, got %v",
+			report.Problems)
+	}
+	if err := store.Close(); err != nil {
+		t.Fatalf("Error closing store, %v", err)
+	}
+
+	store = setUp(t)
+	defer tearDown(store, t)
+	if _, ok, _ := store.Get("user/b"); ok {
+		t.Errorf("Rejected value was found after reopening")
+	}
+	values, _ := store.GetAll("user")
+	if len(values) != 1 {
+		t.Errorf("Expected 1 member but was %d", len(values))
+	}
+}
+
+func TestIndexesFilledWhenOpening(t *testing.T) {
+	store := setUp(t)
+	store.Put("artist/daft_punk", []byte("Discovery,house"))
+	store.Put("artist/justice", []byte("Cross,electro"))
+	if err := store.Close(); err != nil {
+		t.Fatalf("Error closing store, %v", err)
+	}
+
+	store, _, err := OpenWith("./db", Options{Indexes: []Index{
+		{Coll: "artist", Name: "genre", Extract: byGenre, Unique: true},
+	}})
+	if err != nil {
+		t.Fatalf("Error opening store, %v", err)
+	}
+	defer func() { tearDown(store, t) }()
+	checkLookup(store, "artist", "genre", "electro", []string{"justice"}, t)
+
+	_, _, err = OpenWith("./db2", Options{Indexes: []Index{{Coll: "artist", Name: "genre"}}})
+	if _, isRightType := err.(IndexError); !isRightType {
+		t.Errorf("Should have refused an index without extractor"+
+			", error was %v",
+			err)
+	}
+}
@@ -5,7 +5,9 @@ import (
 	"log"
 	"os"
 	"path/filepath"
+	"strings"
 	"sync/atomic"
+	"time"
 )
 
 type janitor struct {
@@ -48,10 +50,10 @@ func (j *janitor) deleteFolder(m *member) {
 }
 
 func (j *janitor) hasNoFolderOps() chan *page {
-	if j.toCreateCount != 0 {
+	if atomic.LoadInt64(&j.toCreateCount) != 0 {
 		return nil
 	}
-	if j.toDeleteCount != 0 {
+	if atomic.LoadInt64(&j.toDeleteCount) != 0 {
 		return nil
 	}
 	return j.toWriteChan
@@ -59,25 +61,39 @@ func (j *janitor) hasNoFolderOps() chan *page {
 
 func (j *janitor) shouldDie() chan bool {
 
-	backlog := j.toCreateCount +
-		j.toDeleteCount +
-		j.toWriteCount
+	toWrite := atomic.LoadInt64(&j.toWriteCount)
+	toDelete := atomic.LoadInt64(&j.toDeleteCount)
+	toCreate := atomic.LoadInt64(&j.toCreateCount)
 
-	if backlog != 0 && len(j.mustDie) != 0 {
+	if toWrite+toDelete+toCreate != 0 && len(j.mustDie) != 0 {
 
 		log.Printf("Dying - backlog: write=%d, rmdir=%d, mkdir=%d",
-			j.toWriteCount,
-			j.toDeleteCount,
-			j.toCreateCount)
+			toWrite,
+			toDelete,
+			toCreate)
 
 		return nil
 	}
 	return j.mustDie
 }
 
+// dieRetry is how long a janitor that must die waits before looking at the
+// backlog again.  Another janitor may be the one to clear it, in which case
+// nothing else would wake this one up.
+const dieRetry = 10 * time.Millisecond
+
+// retryDie wakes up a janitor whose death was postponed by `shouldDie`.
+func (j *janitor) retryDie(die chan bool) <-chan time.Time {
+	if die != nil || len(j.mustDie) == 0 {
+		return nil
+	}
+	return time.After(dieRetry)
+}
+
 func (j *janitor) run() {
 	go func() {
 		for {
+			die := j.shouldDie()
 			select {
 			case page := <-j.hasNoFolderOps():
 				atomic.AddInt64(&j.toWriteCount, -1)
@@ -92,7 +108,9 @@ func (j *janitor) run() {
 				atomic.AddInt64(&j.toCreateCount, -1)
 				_ = createFolder(member)
 
-			case <-j.shouldDie():
+			case <-j.retryDie(die):
+
+			case <-die:
 				j.blockUntilFinished <- false
 				return
 			}
@@ -105,7 +123,7 @@ func (j *janitor) die() {
 	j.mustDie <- true
 }
 
-func (j *janitor) loadStore(s *Store) error {
+func (j *janitor) loadStore(s *Store, report *OpenReport) error {
 
 	basepath := s.storagePath
 	possibleColl, err := ioutil.ReadDir(basepath)
@@ -116,45 +134,166 @@ func (j *janitor) loadStore(s *Store) error {
 		return err
 	}
 
-	var memberPathList []string
-	var memberPath string
+	// Copies of collections that a crash interrupted
+	if err := os.RemoveAll(filepath.Join(basepath, stagingDir)); err != nil {
+		log.Printf("Can't remove unfinished copies at path %s: %v", basepath, err)
+	}
+
+	var collList []string
 	for _, file := range possibleColl {
-		if file.IsDir() {
-			memberPath = filepath.Join(basepath, file.Name())
-			memberPathList = append(memberPathList, memberPath)
-			s.coll.members[file.Name()] = newMember(basepath, file.Name())
+		if file.IsDir() && !isReservedColl(file.Name()) {
+			collList = append(collList, file.Name())
+			s.coll.members[file.Name()] = s.coll.newMember(file.Name())
 		}
 	}
 
 	var aPage *page
 	var pagePath string
-	for _, member := range memberPathList {
+	// Collections found within collections are appended as we go
+	for i := 0; i < len(collList); i++ {
+		coll := collList[i]
+		member := filepath.Join(basepath, coll)
 		possiblePage, err := ioutil.ReadDir(member)
 		if err != nil {
 			log.Printf("\t... skipping, can't list directory at path <%s>: %v",
 				basepath, err)
+			report.skipped(member, err)
 			continue
 		}
 
 		for _, file := range possiblePage {
+			pagePath = filepath.Join(member, file.Name())
+			if file.IsDir() && s.opts.Nested {
+				child := filepath.Join(coll, file.Name())
+				collList = append(collList, child)
+				s.coll.members[child] = s.coll.newMember(child)
+				continue
+			}
 			if !file.Mode().IsRegular() {
 				log.Printf("\t... skipping irregular file <%s>", file.Name())
+				report.skipped(pagePath, errorIrregularFile(pagePath))
 				continue
 			}
-			pagePath = filepath.Join(member, file.Name())
-			aPage, err = readFromFile(pagePath)
-			if err != nil {
+			if strings.HasSuffix(file.Name(), tempSuffix) {
+				log.Printf("\t... skipping unfinished write <%s>", file.Name())
+				report.skipped(pagePath, errorUnfinishedWrite(pagePath))
+				continue
+			}
+			aPage, err = readPageFile(pagePath, s.opts.keyProvider())
+			if _, wrongKey := err.(CryptoError); wrongKey {
+				// Don't let the next write replace a file that could be
+				// read with the right key
+				return err
+			} else if err != nil {
 				log.Printf("\t... skipping, error reading possible page file: %v",
 					err)
+				j.reject(s, report, pagePath, err)
 				continue
 			}
-			s.coll.members[aPage.coll].entries[aPage.key] = aPage
+			// The file only tells the last segment of its collection
+			aPage.basepath = basepath
+			aPage.coll = coll
+			pagePath = relocate(pagePath, generateFilename(aPage))
+			s.coll.members[coll].load(aPage)
+			report.loaded(pagePath)
 		}
 	}
+	if err := j.loadHistory(s, report); err != nil {
+		return err
+	}
+	j.loadTrash(s)
 	return nil
 }
 
+// reject reports a page file that couldn't be loaded, and moves it to the
+// quarantine if the store is configured to do so.
+func (j *janitor) reject(s *Store, report *OpenReport, pagePath string, reason error) {
+	if !s.opts.Quarantine {
+		report.skipped(pagePath, reason)
+		return
+	}
+
+	coll, err := filepath.Rel(s.storagePath, filepath.Dir(pagePath))
+	if err != nil {
+		report.skipped(pagePath, reason)
+		return
+	}
+	dest, err := quarantineFile(s.storagePath, coll, pagePath)
+	if err != nil {
+		report.skipped(pagePath, reason)
+		return
+	}
+	report.quarantined(dest, reason)
+}
+
+// scrub starts the scrubber of the store, if it has one.  It runs next to
+// the janitor and hands it the pages that must be rewritten.
+func (j *janitor) scrub(s *Store) {
+	if s.scrub != nil {
+		go s.scrub.run(s)
+	}
+}
+
+// pruneInterval is the time between two passes of the janitor over the
+// histories and trash of a store, forgetting what they don't keep anymore.
+var pruneInterval = time.Minute
+
+// A pruner has the janitor prune the histories and purge the trash of a store
+// regularly.
+type pruner struct {
+	stop chan bool
+	done chan bool
+}
+
+func newPruner() *pruner {
+	return &pruner{
+		stop: make(chan bool),
+		done: make(chan bool),
+	}
+}
+
+// run prunes `s` until told to stop.
+func (pr *pruner) run(s *Store) {
+	defer close(pr.done)
+	for {
+		select {
+		case <-pr.stop:
+			return
+		case <-time.After(pruneInterval):
+			now := time.Now()
+			s.pruneHistory(now)
+			s.purgeTrash(now)
+		}
+	}
+}
+
+// prune starts the pruner of the store, if it has one.
+func (j *janitor) prune(s *Store) {
+	if s.prune != nil {
+		go s.prune.run(s)
+	}
+}
+
+// background runs a task of the store next to the janitor.  The store is
+// not unloaded before its tasks are done.
+func (j *janitor) background(s *Store, task func()) {
+	s.tasks.Add(1)
+	go func() {
+		defer s.tasks.Done()
+		task()
+	}()
+}
+
 func (j *janitor) unloadStore(s *Store) error {
+	if s.scrub != nil {
+		close(s.scrub.stop)
+		<-s.scrub.done
+	}
+	if s.prune != nil {
+		close(s.prune.stop)
+		<-s.prune.done
+	}
+	s.tasks.Wait()
 	j.die()
 	<-j.blockUntilFinished
 	return nil
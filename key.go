@@ -0,0 +1,70 @@
+package dskvs
+
+// MaxKeyLength is the length in bytes of the longest full key that dskvs
+// accepts.
+const MaxKeyLength = 4096
+
+// forbiddenKeyBytes can't appear in keys.  Page files hold their key as is,
+// and tools reading them would cut it short at a NUL byte.
+const forbiddenKeyBytes = "\x00"
+
+// A Key is a full key, split in the collection and the member that it names.
+// The separator is not part of either : "artist/daft_punk" names member
+// "daft_punk" of collection "artist".  Page files hold the member, and are
+// named after it.
+type Key struct {
+	coll   string
+	member string
+}
+
+// ParseKey splits full key `fullKey` at its first CollKeySep, and verifies
+// that it names a member.  In stores opened with `Options.Nested`, the
+// collection is split further by the store itself.
+func ParseKey(fullKey string) (Key, error) {
+
+	if err := checkKeyValid(fullKey); err != nil {
+		return Key{}, err
+	}
+
+	if isCollectionKey(fullKey) {
+		return Key{}, errorGetIsColl(fullKey)
+	}
+
+	coll, member := splitKeys(fullKey)
+	return Key{coll, member}, nil
+}
+
+// NewKey returns the key of member `member` of collection `coll`, once
+// verified like `ParseKey` would.
+func NewKey(coll, member string) (Key, error) {
+
+	if err := checkCollName(coll); err != nil {
+		return Key{}, err
+	}
+
+	fullKey := joinKeys(coll, member)
+	if err := checkKeyValid(fullKey); err != nil {
+		return Key{}, err
+	}
+
+	if member == "" {
+		return Key{}, errorGetIsColl(fullKey)
+	}
+
+	return Key{coll, member}, nil
+}
+
+// Collection returns the name of the collection of the key.
+func (k Key) Collection() string {
+	return k.coll
+}
+
+// Member returns the member of the key, without the separator.
+func (k Key) Member() string {
+	return k.member
+}
+
+// String returns the full key.
+func (k Key) String() string {
+	return joinKeys(k.coll, k.member)
+}
@@ -0,0 +1,48 @@
+package dskvs
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseKey(t *testing.T) {
+	key, err := ParseKey("artist/daft_punk/discovery")
+	if err != nil {
+		t.Fatalf("Error parsing key, %v", err)
+	}
+	if key.Collection() != "artist" || key.Member() != "daft_punk/discovery" {
+		t.Errorf("Expected <artist> and <daft_punk/discovery> but were <%s> and <%s>",
+			key.Collection(), key.Member())
+	}
+	if key.String() != "artist/daft_punk/discovery" {
+		t.Errorf("Expected full key back but was <%s>", key)
+	}
+
+	if built, err := NewKey("artist", "daft_punk/discovery"); err != nil || built != key {
+		t.Errorf("Expected <%s> but was <%s>, %v", key, built, err)
+	}
+}
+
+func TestErrorWhenKeyIsTooLongOrHasForbiddenByte(t *testing.T) {
+	store := setUp(t)
+	defer tearDown(store, t)
+
+	for _, fullKey := range []string{
+		"artist/" + strings.Repeat("a", MaxKeyLength),
+		"artist/daft\x00punk",
+	} {
+		if _, err := ParseKey(fullKey); err == nil {
+			t.Errorf("Should have refused to parse key <%q>", fullKey)
+		}
+		err := store.Put(fullKey, []byte("Discovery"))
+		if _, isRightType := err.(KeyError); !isRightType {
+			t.Errorf("Should have returned an error of type KeyError"+
+				", error was %v",
+				err)
+		}
+	}
+
+	if _, err := NewKey("artist", ""); err == nil {
+		t.Errorf("Should have refused a key without member")
+	}
+}
@@ -2,6 +2,7 @@ package dskvs
 
 import (
 	"sync"
+	"sync/atomic"
 )
 
 // A member is a map protected by a RW lock to prevent concurrent
@@ -9,7 +10,23 @@ import (
 type member struct {
 	basepath string
 	coll     string
-	entries  map[string]*page
+	comp     *compression
+	keys     KeyProvider
+	changes  *changeLog
+	// hist is how much history the collection keeps, if any.  The pages of
+	// deleted members are kept in histories meanwhile.
+	hist      *HistoryPolicy
+	histories *histories
+	bin       *trashBin
+	indexes   *indexSet
+	entries   map[string]*page
+	// files is held for reading while the janitor handles the files of the
+	// pages, and for writing while they move elsewhere.
+	files sync.RWMutex
+	// epoch counts the times the member left its collection, deleted or
+	// renamed, so that handles know when to look it up again.  It changes
+	// while the collections are locked.
+	epoch uint32
 	sync.RWMutex
 }
 
@@ -21,6 +38,28 @@ func newMember(basepath, coll string) *member {
 	}
 }
 
+// load adds a page read from disk to the member.  It must not be used
+// concurrently, since the page is not locked.
+func (m *member) load(aPage *page) {
+	aPage.comp = m.comp
+	aPage.keys = m.keys
+	aPage.changes = m.changes
+	aPage.hist = m.hist
+	aPage.bin = m.bin
+	aPage.files = &m.files
+	aPage.indexes = m.indexes
+	if m.comp.keepsPacked(len(aPage.value)) {
+		aPage.value, aPage.packed = m.comp.pack(aPage.value)
+	}
+	m.entries[aPage.key] = aPage
+}
+
+// leave tells the handles of the member that it left its collection.  The
+// collections must be locked.
+func (m *member) leave() {
+	atomic.AddUint32(&m.epoch, 1)
+}
+
 func (m *member) get(key string) ([]byte, bool) {
 	m.RLock()
 	aPage, ok := m.entries[key]
@@ -30,7 +69,36 @@ func (m *member) get(key string) ([]byte, bool) {
 		return nil, false
 	}
 
-	return aPage.get(), true
+	// A page that was never given a value, like one whose put failed,
+	// holds none
+	val := aPage.get()
+	return val, val != nil
+}
+
+// getSeq is `get`, which also returns the number of the last change of the
+// value.
+func (m *member) getSeq(key string) ([]byte, uint64, bool) {
+	aPage, ok := m.page(key)
+	if !ok {
+		return nil, 0, false
+	}
+	aPage.RLock()
+	defer aPage.RUnlock()
+	if aPage.isDeleted || aPage.value == nil {
+		return nil, 0, false
+	}
+	return aPage.plainValue(), aPage.seq, true
+}
+
+// pages returns the pages of the member, as they are now.
+func (m *member) pages() []*page {
+	m.RLock()
+	pages := make([]*page, 0, len(m.entries))
+	for _, aPage := range m.entries {
+		pages = append(pages, aPage)
+	}
+	m.RUnlock()
+	return pages
 }
 
 func (m *member) getMembers() [][]byte {
@@ -74,7 +142,71 @@ func (m *member) getMembers() [][]byte {
 	return values
 }
 
-func (m *member) put(key string, value []byte) {
+func (m *member) put(key string, value []byte) error {
+	for {
+		// Operate on the page itself, which holds a more granular lock
+		aPage := m.pageOrNew(key)
+		err := aPage.set(value)
+		if err == errPageDropped {
+			// It was dropped meanwhile, so set the page that
+			// replaces it
+			continue
+		}
+		if err != nil {
+			m.dropEmpty(aPage)
+		}
+		return err
+	}
+}
+
+// putNew sets the value of the key only if it has none yet, and tells if it
+// did.
+func (m *member) putNew(key string, value []byte) (bool, error) {
+	_, ok, err := m.putNewPage(key, value)
+	return ok, err
+}
+
+// putNewPage is `putNew`, which also returns the page of the key.
+func (m *member) putNewPage(key string, value []byte) (*page, bool, error) {
+	for {
+		aPage := m.pageOrNew(key)
+		ok, err := aPage.setNew(value)
+		if err == errPageDropped {
+			continue
+		}
+		if err != nil {
+			m.dropEmpty(aPage)
+		}
+		return aPage, ok, err
+	}
+}
+
+// dropEmpty removes a page from the member if it was never given a value,
+// like one created for a write that failed, so that it's never written as a
+// member holding nothing.  Writes that got the page meanwhile find it dropped,
+// and use the page that replaces it.
+func (m *member) dropEmpty(aPage *page) {
+	m.Lock()
+	aPage.Lock()
+	if aPage.value == nil && !aPage.isDirty && !aPage.isDeleted &&
+		m.entries[aPage.key] == aPage {
+		aPage.isDropped = true
+		delete(m.entries, aPage.key)
+	}
+	aPage.Unlock()
+	m.Unlock()
+}
+
+// page returns the page of the key, if it has one.
+func (m *member) page(key string) (*page, bool) {
+	m.RLock()
+	aPage, ok := m.entries[key]
+	m.RUnlock()
+	return aPage, ok
+}
+
+// pageOrNew returns the page of the key, creating it if needed.
+func (m *member) pageOrNew(key string) *page {
 
 	// We'd rather not write-lock the whole map if we don't need to
 	m.RLock()
@@ -90,37 +222,82 @@ func (m *member) put(key string, value []byte) {
 		if !ok {
 			// It was not so go ahead and write a new entry
 			aPage = newPage(m.basepath, m.coll, key)
+			aPage.comp = m.comp
+			aPage.keys = m.keys
+			aPage.changes = m.changes
+			aPage.bin = m.bin
+			aPage.files = &m.files
+			aPage.indexes = m.indexes
+			if m.hist != nil {
+				aPage.hist = m.hist
+				aPage.revisions = m.histories.revive(joinKeys(m.coll, key))
+			}
 			m.entries[key] = aPage
 		}
 		m.Unlock()
 	}
-
-	// Operate on the page itself, which holds a more granular lock
-	aPage.set(value)
+	return aPage
 }
 
 func (m *member) delete(key string) {
+	m.deleteIf(key, nil)
+}
+
+// deleteIf deletes the key only if `cond` holds for its value and the number
+// of its last change, as they are when it's deleted, and tells if it did.  A
+// nil `cond` always holds.
+func (m *member) deleteIf(key string, cond func(value []byte, seq uint64) bool) bool {
 	// If the page is already deleted, we don't waste time
 	m.RLock()
-	aPage, ok := m.entries[key]
+	_, ok := m.entries[key]
 	m.RUnlock()
+	if !ok {
+		return false
+	}
 
-	if ok {
-		// Delete the page from the entries first
-		m.Lock()
-		delete(m.entries, key)
+	// Delete the page from the entries first, and mark it deleted before
+	// anyone can see it's gone
+	m.Lock()
+	aPage, ok := m.entries[key]
+	if !ok {
 		m.Unlock()
-		// Then let the page delete itself on a more granular lock
-		aPage.delete()
+		return false
 	}
+	aPage.Lock()
+	if cond != nil && (aPage.value == nil || !cond(aPage.plainValue(), aPage.seq)) {
+		aPage.Unlock()
+		m.Unlock()
+		return false
+	}
+	delete(m.entries, key)
+	wasDirty := aPage.erase(m.changes.next())
+	aPage.Unlock()
+	if m.hist != nil {
+		m.histories.retire(aPage)
+	}
+	m.Unlock()
+	// Then let the janitor delete its file
+	if !wasDirty {
+		jan.writePage(aPage)
+	}
+	return true
 }
 
-func (m *member) deleteAll() {
+// deleteAll marks every page deleted with change `seq`, and returns those
+// that the janitor must be told of.
+func (m *member) deleteAll(seq uint64) []*page {
 	// In this case, it makes sense to just lock the whole map :
 	// we're deleting everything...
 	m.Lock()
+	var dirty []*page
 	for _, aPage := range m.entries {
-		aPage.delete()
+		if !aPage.markDeleted(seq) {
+			dirty = append(dirty, aPage)
+		}
+		if m.hist != nil {
+			m.histories.retire(aPage)
+		}
 	}
 	m.Unlock()
+	return dirty
 }
@@ -0,0 +1,162 @@
+package dskvs
+
+import (
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Migrate rewrites in place every page file of the store at `path` that was
+// written by an older fileformat version, so that it uses the current one.
+// It returns how many files were rewritten.  Files named after a member key
+// that started with CollKeySep, as before fileformat 0.8, are renamed too.
+//
+// Every file is written to a temporary file that is then renamed over the
+// original, so a crash never leaves a half written page behind.  Files that
+// already use the current fileformat are left untouched: an interrupted
+// migration can simply be run again.  A file that can't be decoded stops the
+// migration and its error is returned.
+//
+// The store must not be open while it is migrated.  Use `MigrateWith` for
+// stores with encrypted or compressed files.
+func Migrate(path string) (int, error) {
+	return MigrateWith(path, Options{})
+}
+
+// MigrateWith is like Migrate, but decrypts, encrypts and compresses the files
+// as `opts` says, as `OpenWith` would.
+func MigrateWith(path string, opts Options) (int, error) {
+
+	if !isValidPath(path) {
+		return 0, errorPathInvalid(path)
+	}
+
+	basepath := expandPath(path)
+
+	// Hold the path for the duration of the migration, so that nobody can
+	// `Open` it meanwhile
+	storeExistsLock.Lock()
+	if storeExists[basepath] {
+		storeExistsLock.Unlock()
+		return 0, errorPathInUse(basepath)
+	}
+	storeExists[basepath] = true
+	storeExistsLock.Unlock()
+
+	defer func() {
+		storeExistsLock.Lock()
+		delete(storeExists, basepath)
+		storeExistsLock.Unlock()
+	}()
+
+	possibleColl, err := ioutil.ReadDir(basepath)
+	if os.IsNotExist(err) {
+		return 0, nil
+	} else if err != nil {
+		log.Printf("Can't list directory at path %s: %v", basepath, err)
+		return 0, err
+	}
+
+	migrated := 0
+	for _, dir := range possibleColl {
+		// Histories and the trash hold page files too
+		if !dir.IsDir() || dir.Name() == quarantineDir || dir.Name() == stagingDir {
+			continue
+		}
+		n, err := migrateCollection(basepath, dir.Name(), &opts)
+		migrated += n
+		if err != nil {
+			return migrated, err
+		}
+	}
+	return migrated, nil
+}
+
+// relocate renames the file of a page read from `filename` to `expected`, the
+// name it has now, and returns where it is.  Files written before fileformat
+// 0.8 are named after a member key that started with CollKeySep.
+func relocate(filename, expected string) string {
+	if filename == expected {
+		return filename
+	}
+	if err := os.Rename(filename, expected); err != nil {
+		log.Printf("Couldn't rename <%s> to <%s> : %v", filename, expected, err)
+		return filename
+	}
+	return expected
+}
+
+// migrateCollection migrates the page files of directory `coll` under
+// `basepath`, and of the directories within it.
+func migrateCollection(basepath, coll string, opts *Options) (int, error) {
+	collPath := filepath.Join(basepath, coll)
+	possiblePage, err := ioutil.ReadDir(collPath)
+	if err != nil {
+		log.Printf("Can't list directory at path %s: %v", collPath, err)
+		return 0, err
+	}
+
+	migrated := 0
+	for _, file := range possiblePage {
+		if file.IsDir() {
+			n, err := migrateCollection(basepath, filepath.Join(coll, file.Name()), opts)
+			migrated += n
+			if err != nil {
+				return migrated, err
+			}
+			continue
+		}
+		if !file.Mode().IsRegular() {
+			continue
+		}
+		filename := filepath.Join(collPath, file.Name())
+
+		// Leftover of an interrupted migration, the original is intact
+		if strings.HasSuffix(filename, tempSuffix) {
+			if err := deleteFile(filename); err != nil {
+				return migrated, err
+			}
+			continue
+		}
+
+		data, err := ioutil.ReadFile(filename)
+		if err != nil {
+			log.Printf("Error reading file <%s> : %v", filename, err)
+			return migrated, err
+		}
+
+		if isCurrentFormat(data) {
+			continue
+		}
+
+		aPage, err := decodePageFile(filename, data, opts.keyProvider())
+		if err != nil {
+			return migrated, err
+		}
+		aPage.basepath = basepath
+		aPage.coll = coll
+		aPage.comp = opts.compressionFor(coll)
+		aPage.keys = opts.keyProvider()
+
+		newData, err := fromPageToBytes(aPage)
+		if err != nil {
+			return migrated, err
+		}
+
+		// Written under its new name first, so that a crash leaves the
+		// page under either name or both
+		newFilename := generateFilename(aPage)
+		if err := writeFileAtomic(newFilename, newData); err != nil {
+			return migrated, err
+		}
+		if newFilename != filename {
+			if err := deleteFile(filename); err != nil {
+				return migrated, err
+			}
+		}
+		migrated++
+	}
+	return migrated, nil
+}
@@ -0,0 +1,175 @@
+package dskvs
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// legacyPageBytes encodes a page the way fileformat 0.4 did
+func legacyPageBytes(aPage *page, t *testing.T) []byte {
+	current := newFileHeader(aPage)
+	legacy := fileHeader04{
+		0,
+		4,
+		2,
+		current.Checksum,
+		current.KeyNameLength,
+		current.PayloadLength,
+	}
+	buf := new(bytes.Buffer)
+	if err := binary.Write(buf, binary.BigEndian, &legacy); err != nil {
+		t.Fatalf("Couldn't write legacy header, %v", err)
+	}
+	buf.WriteString(aPage.key)
+	buf.Write(aPage.value)
+	return buf.Bytes()
+}
+
+func writeLegacyPage(basepath, coll, key string, value []byte, t *testing.T) string {
+	aPage := newPage(basepath, coll, key)
+	aPage.value = value
+	filename := generateFilename(aPage)
+	if err := os.MkdirAll(filepath.Dir(filename), DIR_PERM); err != nil {
+		t.Fatalf("Couldn't create collection folder, %v", err)
+	}
+	err := ioutil.WriteFile(filename, legacyPageBytes(aPage, t), FILE_PERM)
+	if err != nil {
+		t.Fatalf("Couldn't write legacy page <%s> : %v", filename, err)
+	}
+	return filename
+}
+
+func TestReadLegacyPage(t *testing.T) {
+	basepath := "legacy"
+	defer os.RemoveAll(basepath)
+
+	expected := []byte("Around the world")
+	filename := writeLegacyPage(basepath, "artist", "/daft_punk", expected, t)
+
+	actual, err := readFromFile(filename)
+	if err != nil {
+		t.Fatalf("Failed reading legacy file. %v", err)
+	}
+	// Member keys lost their leading separator in fileformat 0.8
+	if actual.key != "daft_punk" {
+		t.Errorf("Expected key <%s> but was <%s>", "daft_punk", actual.key)
+	}
+	if !bytes.Equal(actual.value, expected) {
+		t.Errorf("Expected value <%s> but was <%s>", expected, actual.value)
+	}
+}
+
+func TestErrorWhenReadingForeignMagicFile(t *testing.T) {
+	filename := "unknown_version.test"
+	aPage := newPage("imdb", "FMJ", "Pyle")
+	aPage.value = []byte("Private Pyle")
+
+	header := newFileHeader(aPage)
+	header.Minor = MinorVersion + 1
+	headerBytes, err := headerToBytes(header)
+	if err != nil {
+		t.Fatalf("Couldn't get fake header, %v", err)
+	}
+	pageBytes, err := fromPageToBytes(aPage)
+	if err != nil {
+		t.Fatalf("Couldn't encode page, %v", err)
+	}
+	copy(pageBytes, headerBytes)
+
+	if err := ioutil.WriteFile(filename, pageBytes, FILE_PERM); err != nil {
+		t.Fatalf("Couldn't write file <%s> : %v", filename, err)
+	}
+	defer os.Remove(filename)
+
+	_, err = readFromFile(filename)
+	if _, isRightType := err.(FileError); !isRightType {
+		t.Errorf("Should have returned an error of type FileError"+
+			", error was %v",
+			err)
+	}
+}
+
+func TestMigrateRewritesLegacyFiles(t *testing.T) {
+	basepath := "db"
+	defer os.RemoveAll(basepath)
+
+	expected := []byte("Harder, better, faster, stronger")
+	filename := writeLegacyPage(expandPath(basepath), "artist", "/daft_punk",
+		expected, t)
+
+	n, err := Migrate(basepath)
+	if err != nil {
+		t.Fatalf("Error migrating store, %v", err)
+	}
+	if n != 1 {
+		t.Errorf("Expected 1 file to be migrated but was %d", n)
+	}
+
+	// The file is named after the member key without separator
+	if _, err := os.Stat(filename); !os.IsNotExist(err) {
+		t.Errorf("Expected legacy file to be renamed, %v", err)
+	}
+	filename = generateFilename(newPage(expandPath(basepath), "artist", "daft_punk"))
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		t.Fatalf("Couldn't read migrated file, %v", err)
+	}
+	if !isCurrentFormat(data) {
+		t.Errorf("Migrated file is not in the current fileformat")
+	}
+
+	n, err = Migrate(basepath)
+	if err != nil {
+		t.Fatalf("Error migrating store a second time, %v", err)
+	}
+	if n != 0 {
+		t.Errorf("Expected nothing left to migrate but %d were", n)
+	}
+
+	store := setUp(t)
+	defer tearDown(store, t)
+
+	actual, ok, err := store.Get("artist/daft_punk")
+	if err != nil {
+		t.Fatalf("Error getting data back, %v", err)
+	}
+	if !ok {
+		t.Fatalf("Data was not there when it should have")
+	}
+	if !bytes.Equal(expected, actual) {
+		t.Errorf("Expected <%s> but was <%s>", expected, actual)
+	}
+}
+
+func TestOpenRenamesLegacyFiles(t *testing.T) {
+	basepath := "db"
+	filename := writeLegacyPage(expandPath(basepath), "artist", "/daft_punk",
+		[]byte("Homework"), t)
+
+	store := setUp(t)
+	defer func() { tearDown(store, t) }()
+	if _, err := os.Stat(filename); !os.IsNotExist(err) {
+		t.Errorf("Expected legacy file to be renamed, %v", err)
+	}
+
+	// Deleting the member leaves no file behind to bring it back
+	store.Delete("artist/daft_punk")
+	store.Close()
+	store = setUp(t)
+	if _, ok, _ := store.Get("artist/daft_punk"); ok {
+		t.Errorf("Deleted member came back")
+	}
+}
+
+func TestErrorWhenMigratingOpenStore(t *testing.T) {
+	store := setUp(t)
+	defer tearDown(store, t)
+
+	if _, err := Migrate(store.storagePath); err == nil {
+		t.Errorf("Should have refused to migrate an open store")
+	}
+}
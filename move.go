@@ -0,0 +1,399 @@
+package dskvs
+
+import (
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// stagingDir is where `CopyCollection` writes a copy before moving it in
+// place, under the storage path of a store.  What a crash leaves there is
+// removed when the store is opened.
+const stagingDir = ".staging"
+
+// RenameCollection renames collection `from` to `to`, which must not exist.
+// Its directory is renamed at once, so that should it crash, the store holds
+// the collection under one name or the other, never both nor a part of it.
+// The members take the compression and history of the new name, as `Options`
+// say.  Members deleted before the rename stay in the trash, and in the
+// history, under the old name.  With nested collections, the collections
+// within it are renamed along.  It fails if members would conflict in a unique
+// index of the new name.
+func (s Store) RenameCollection(from, to string) error {
+
+	if err := s.coll.checkCollName(from); err != nil {
+		return err
+	}
+
+	if err := s.coll.checkCollName(to); err != nil {
+		return err
+	}
+
+	return s.coll.rename(from, to)
+}
+
+// CopyCollection copies every member of collection `from` to collection `to`,
+// which must not exist, as they are now.  The copy is written aside then
+// moved in place, so that should it crash, the store holds all of it or none.
+// With nested collections, the collections within it are not copied.  It fails
+// if members would conflict in a unique index of `to`.
+func (s Store) CopyCollection(from, to string) error {
+
+	if err := s.coll.checkCollName(from); err != nil {
+		return err
+	}
+
+	if err := s.coll.checkCollName(to); err != nil {
+		return err
+	}
+
+	if _, ok := s.coll.member(from); !ok {
+		return errorNoSuchColl(from)
+	}
+	if _, ok := s.coll.member(to); ok {
+		return errorCollExists(to)
+	}
+
+	staging := filepath.Join(s.storagePath, stagingDir)
+	dir := filepath.Join(staging, to)
+	if err := os.RemoveAll(dir); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(dir, DIR_PERM); err != nil {
+		log.Printf("Couldn't create directory <%s> : %v", dir, err)
+		return err
+	}
+
+	m := s.coll.newMember(to)
+	var pages []*page
+	var err error
+	sn := s.Snapshot()
+	sn.iterate(from, func(fullKey string, value []byte) bool {
+		aPage := newPage(staging, to, fullKey[len(from)+len(CollKeySep):])
+		aPage.comp = m.comp
+		aPage.keys = m.keys
+		aPage.value = value
+		var data []byte
+		var payload pagePayload
+		data, payload, err = encodePage(aPage)
+		if err == nil {
+			err = writeFileAtomic(generateFilename(aPage), data)
+		}
+		aPage.basepath = s.storagePath
+		aPage.keyID = payload.keyID
+		pages = append(pages, aPage)
+		return err == nil
+	})
+	sn.Release()
+	if err != nil {
+		_ = os.RemoveAll(dir)
+		return err
+	}
+
+	return s.coll.publish(m, pages, dir)
+}
+
+// Move moves member `fromFullKey` to `toFullKey`, which must hold no value,
+// within a collection or to another one.  The new file is written before the
+// old one is deleted, so that should it crash in between, the value is found
+// under both keys, never under neither.  Should `fromFullKey` be written while
+// it's moved, it keeps what was written, as if that came after the move.
+func (s Store) Move(fromFullKey, toFullKey string) error {
+
+	if err := s.coll.checkKey(fromFullKey); err != nil {
+		return err
+	}
+
+	if s.coll.isCollectionKey(fromFullKey) {
+		return errorDeleteIsColl(fromFullKey)
+	}
+
+	if err := s.coll.checkKey(toFullKey); err != nil {
+		return err
+	}
+
+	if s.coll.isCollectionKey(toFullKey) {
+		return errorPutIsColl(toFullKey, "")
+	}
+
+	fromColl, fromKey := s.coll.splitKeys(fromFullKey)
+	toColl, toKey := s.coll.splitKeys(toFullKey)
+
+	from, ok := s.coll.member(fromColl)
+	if !ok {
+		return errorNoSuchKey(fromFullKey)
+	}
+	value, fromSeq, ok := from.getSeq(fromKey)
+	if !ok {
+		return errorNoSuchKey(fromFullKey)
+	}
+
+	aPage, ok, err := s.coll.memberOrNew(toColl).putNewPage(toKey, value)
+	if err != nil {
+		return err
+	} else if !ok {
+		return errorKeyExists(toFullKey)
+	}
+
+	// The janitor writes files in no particular order, so don't let it
+	// delete the old one first
+	dir := filepath.Join(s.storagePath, toColl)
+	if err := os.MkdirAll(dir, DIR_PERM); err != nil {
+		log.Printf("Couldn't create directory <%s> : %v", dir, err)
+		return err
+	}
+	if err := writeToFile(aPage); err != nil {
+		return err
+	}
+
+	from.deleteIf(fromKey, func(value []byte, seq uint64) bool {
+		return seq == fromSeq
+	})
+	return nil
+}
+
+// rename moves the members of collection `from` and their files to
+// collection `to`, as one change.  With nested collections, those within it
+// move along.
+func (c *collections) rename(from, to string) error {
+	for {
+		c.RLock()
+		members := c.subtree(from)
+		c.RUnlock()
+		if len(members) == 0 {
+			return errorNoSuchColl(from)
+		}
+		if done, err := c.renameMembers(from, to, members); done {
+			return err
+		}
+		// Collections within it were added or deleted in between
+	}
+}
+
+// renameMembers is `rename` for the members of the subtree of collection
+// `from`.  It isn't done if the subtree changed since it was read.
+func (c *collections) renameMembers(from, to string, members []*member) (bool, error) {
+	// Unique indexes must know every conflict before they're checked
+	for _, m := range members {
+		c.indexSet(to + m.coll[len(from):]).waitUnique()
+	}
+
+	// Wait for the janitor to be done with the files of the members, and
+	// keep it away until they moved.  They are sorted, so that renames of
+	// the same collections take them in the same order.
+	for _, m := range members {
+		m.files.Lock()
+	}
+	defer func() {
+		for _, m := range members {
+			m.files.Unlock()
+		}
+	}()
+
+	c.Lock()
+	defer c.Unlock()
+	current := c.subtree(from)
+	if len(current) != len(members) {
+		return false, nil
+	}
+	for i := range current {
+		if current[i] != members[i] {
+			return false, nil
+		}
+	}
+	if len(c.subtree(to)) != 0 {
+		return true, errorCollExists(to)
+	}
+
+	var pages []*page
+	for _, m := range members {
+		m.Lock()
+		for _, aPage := range m.entries {
+			aPage.Lock()
+			pages = append(pages, aPage)
+		}
+	}
+	defer func() {
+		for _, aPage := range pages {
+			aPage.Unlock()
+		}
+		for _, m := range members {
+			m.Unlock()
+		}
+	}()
+
+	for _, m := range members {
+		memberPages := make([]*page, 0, len(m.entries))
+		for _, aPage := range m.entries {
+			memberPages = append(memberPages, aPage)
+		}
+		if err := c.checkUnique(to+m.coll[len(from):], memberPages); err != nil {
+			return true, err
+		}
+	}
+
+	// The janitor may not have created the directory of a new collection
+	// yet
+	fromDir := filepath.Join(c.basepath, from)
+	toDir := filepath.Join(c.basepath, to)
+	for _, dir := range []string{fromDir, filepath.Dir(toDir)} {
+		if err := os.MkdirAll(dir, DIR_PERM); err != nil {
+			log.Printf("Couldn't create directory <%s> : %v", dir, err)
+			return true, err
+		}
+	}
+	if err := os.Rename(fromDir, toDir); err != nil {
+		log.Printf("Couldn't rename <%s> to <%s> : %v", fromDir, toDir, err)
+		return true, err
+	}
+
+	seq := c.changes.next()
+	for _, m := range members {
+		coll := to + m.coll[len(from):]
+		c.changes.tombstone(m.coll+string(filepath.Separator), seq)
+		delete(c.members, m.coll)
+		m.leave()
+		m.coll = coll
+		m.comp = c.opts.compressionFor(coll)
+		m.hist = c.opts.historyFor(coll)
+		m.indexes = c.indexSet(coll)
+		c.members[coll] = m
+	}
+	c.addParents(to)
+
+	for _, m := range members {
+		known := make(map[string]bool, len(m.entries))
+		for _, aPage := range m.entries {
+			aPage.moveTo(m.coll, m.comp, m.hist, m.indexes, seq)
+			known[filepath.Base(generateFilename(aPage))] = true
+		}
+		removeStrays(filepath.Join(c.basepath, m.coll), known)
+	}
+	return true, nil
+}
+
+// removeStrays deletes the files of directory `dir` that are not `known`.
+// They belong to pages deleted before a rename, which moved along before the
+// janitor deleted them.
+func removeStrays(dir string, known map[string]bool) {
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		log.Printf("Can't list directory at path %s: %v", dir, err)
+		return
+	}
+	for _, file := range files {
+		if !file.IsDir() && !known[file.Name()] {
+			_ = deleteFile(filepath.Join(dir, file.Name()))
+		}
+	}
+}
+
+// moveTo moves the page to collection `coll`, configured with `comp`, `hist`
+// and `indexes`, with change `seq`.  Its file must have moved already.  The
+// page must be locked.
+func (p *page) moveTo(coll string, comp *compression, hist *HistoryPolicy, indexes *indexSet, seq uint64) {
+	if _, needed := p.changes.needed(seq); needed {
+		// Snapshots still see the page under its old name
+		old := &page{
+			basepath: p.basepath,
+			coll:     p.coll,
+			key:      p.key,
+			value:    p.value,
+			comp:     p.comp,
+			packed:   p.packed,
+			changes:  p.changes,
+			seq:      p.seq,
+			versions: p.versions,
+		}
+		old.markDeleted(seq)
+	}
+
+	oldHistory := historyFilename(p)
+	value := p.plainValue()
+	p.coll = coll
+	p.comp = comp
+	p.value = value
+	p.packed = false
+	if value != nil && comp.keepsPacked(len(value)) {
+		p.value, p.packed = comp.pack(value)
+	}
+	p.seq = seq
+	p.versions = nil
+
+	// Conflicts in unique indexes of the new collection were checked
+	// already
+	p.indexes.remove(p.key)
+	p.indexes = indexes
+	if value != nil {
+		indexes.put(p.key, indexes.extract(p.key, value), true)
+	}
+
+	if p.hist != nil && hist != nil {
+		newHistory := historyFilename(p)
+		err := os.MkdirAll(filepath.Dir(newHistory), DIR_PERM)
+		if err == nil {
+			err = os.Rename(oldHistory, newHistory)
+		}
+		if err != nil && !os.IsNotExist(err) {
+			log.Printf("Couldn't move history <%s> : %v", oldHistory, err)
+		}
+	} else if p.hist != nil {
+		_ = deleteFile(oldHistory)
+		p.revisions = nil
+	}
+	p.hist = hist
+}
+
+// publish moves the files of the pages of a new member, written in `dir`,
+// into place, and adds the member to the collections as one change.
+func (c *collections) publish(m *member, pages []*page, dir string) error {
+	m.indexes.waitUnique()
+	c.Lock()
+	if _, exists := c.members[m.coll]; exists {
+		c.Unlock()
+		_ = os.RemoveAll(dir)
+		return errorCollExists(m.coll)
+	}
+	if err := c.checkUnique(m.coll, pages); err != nil {
+		c.Unlock()
+		_ = os.RemoveAll(dir)
+		return err
+	}
+	collDir := filepath.Join(c.basepath, m.coll)
+	err := os.MkdirAll(filepath.Dir(collDir), DIR_PERM)
+	if err == nil {
+		err = os.Rename(dir, collDir)
+	}
+	if err != nil {
+		c.Unlock()
+		log.Printf("Couldn't rename <%s> to <%s> : %v", dir, collDir, err)
+		_ = os.RemoveAll(dir)
+		return err
+	}
+	c.addParents(m.coll)
+
+	seq := c.changes.next()
+	now := time.Now()
+	for _, aPage := range pages {
+		m.load(aPage)
+		// Conflicts were checked while the collections were locked
+		m.indexes.put(aPage.key, m.indexes.extract(aPage.key, aPage.plainValue()), true)
+		aPage.seq = seq
+		if m.hist != nil {
+			aPage.revisions = c.histories.revive(joinKeys(m.coll, aPage.key))
+			aPage.record(Revision{Time: now, Value: aPage.plainValue()})
+		}
+	}
+	c.members[m.coll] = m
+	c.Unlock()
+
+	// Have the janitor write their history
+	if m.hist != nil {
+		for _, aPage := range pages {
+			aPage.touch()
+		}
+	}
+	return nil
+}
@@ -0,0 +1,169 @@
+package dskvs
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestRenameCollection(t *testing.T) {
+	store := setUp(t)
+	fillStore(store, backupData, t)
+	sn := store.Snapshot()
+	defer sn.Release()
+
+	if err := store.RenameCollection("artist", "band"); err != nil {
+		t.Fatalf("Error renaming collection, %v", err)
+	}
+	if _, ok, _ := store.Get("artist/justice"); ok {
+		t.Errorf("Old collection still holds its members")
+	}
+	if val, _, _ := store.Get("band/justice"); string(val) != "Cross" {
+		t.Errorf("Expected <Cross> at band/justice but was <%s>", val)
+	}
+	// Snapshots still see it as it was
+	if val, _, _ := sn.Get("artist/justice"); string(val) != "Cross" {
+		t.Errorf("Expected snapshot to see <Cross> but was <%s>", val)
+	}
+	if _, ok, _ := sn.Get("band/justice"); ok {
+		t.Errorf("Snapshot sees a collection renamed after it was taken")
+	}
+
+	store.Put("band/air", []byte("Moon Safari"))
+	if err := store.Close(); err != nil {
+		t.Fatalf("Error closing store, %v", err)
+	}
+
+	defer os.RemoveAll("./db")
+	checkStoreHolds("./db", map[string][]byte{
+		"band/daft_punk": []byte("Discovery"),
+		"band/justice":   []byte("Cross"),
+		"band/air":       []byte("Moon Safari"),
+		"album/homework": []byte("1997"),
+	}, t)
+	if _, err := os.Stat(filepath.Join("db", "artist")); !os.IsNotExist(err) {
+		t.Errorf("Expected old collection directory to be gone, %v", err)
+	}
+}
+
+func TestErrorWhenRenamingOntoExistingCollection(t *testing.T) {
+	store := setUp(t)
+	defer tearDown(store, t)
+	fillStore(store, backupData, t)
+
+	err := store.RenameCollection("artist", "album")
+	if _, isRightType := err.(KeyError); !isRightType {
+		t.Errorf("Should have returned an error of type KeyError"+
+			", error was %v",
+			err)
+	}
+
+	err = store.RenameCollection("label", "band")
+	if _, isRightType := err.(KeyError); !isRightType {
+		t.Errorf("Should have returned an error of type KeyError"+
+			", error was %v",
+			err)
+	}
+}
+
+func TestCopyCollection(t *testing.T) {
+	store := setUp(t)
+	fillStore(store, backupData, t)
+
+	if err := store.CopyCollection("artist", "band"); err != nil {
+		t.Fatalf("Error copying collection, %v", err)
+	}
+	store.Put("artist/justice", []byte("Audio, Video, Disco"))
+	if val, _, _ := store.Get("band/justice"); string(val) != "Cross" {
+		t.Errorf("Expected <Cross> at band/justice but was <%s>", val)
+	}
+
+	err := store.CopyCollection("artist", "album")
+	if _, isRightType := err.(KeyError); !isRightType {
+		t.Errorf("Should have returned an error of type KeyError"+
+			", error was %v",
+			err)
+	}
+
+	// A copy that a crash interrupted is forgotten
+	staging := filepath.Join("db", stagingDir, "label")
+	if err := os.MkdirAll(staging, DIR_PERM); err != nil {
+		t.Fatalf("Error creating staging directory, %v", err)
+	}
+	if err := store.Close(); err != nil {
+		t.Fatalf("Error closing store, %v", err)
+	}
+
+	defer os.RemoveAll("./db")
+	checkStoreHolds("./db", map[string][]byte{
+		"artist/daft_punk": []byte("Discovery"),
+		"artist/justice":   []byte("Audio, Video, Disco"),
+		"band/daft_punk":   []byte("Discovery"),
+		"band/justice":     []byte("Cross"),
+	}, t)
+	if _, err := os.Stat(staging); !os.IsNotExist(err) {
+		t.Errorf("Expected unfinished copy to be removed, %v", err)
+	}
+}
+
+func TestMove(t *testing.T) {
+	store := setUp(t)
+	fillStore(store, backupData, t)
+
+	if err := store.Move("artist/justice", "band/justice"); err != nil {
+		t.Fatalf("Error moving member, %v", err)
+	}
+	if _, ok, _ := store.Get("artist/justice"); ok {
+		t.Errorf("Member is still under its old key")
+	}
+
+	err := store.Move("artist/daft_punk", "album/homework")
+	if _, isRightType := err.(KeyError); !isRightType {
+		t.Errorf("Should have returned an error of type KeyError"+
+			", error was %v",
+			err)
+	}
+	err = store.Move("artist/justice", "band/cross")
+	if _, isRightType := err.(KeyError); !isRightType {
+		t.Errorf("Should have returned an error of type KeyError"+
+			", error was %v",
+			err)
+	}
+	if err := store.Close(); err != nil {
+		t.Fatalf("Error closing store, %v", err)
+	}
+
+	defer os.RemoveAll("./db")
+	checkStoreHolds("./db", map[string][]byte{
+		"artist/daft_punk": []byte("Discovery"),
+		"band/justice":     []byte("Cross"),
+		"album/homework":   []byte("1997"),
+	}, t)
+}
+
+func TestMoveKeepsConcurrentPut(t *testing.T) {
+	store := setUp(t)
+	defer tearDown(store, t)
+
+	for i := 0; i < 200; i++ {
+		store.Delete("band/justice")
+		store.Put("artist/justice", []byte("Cross"))
+		done := make(chan bool)
+		go func() {
+			store.Put("artist/justice", []byte("Audio, Video, Disco"))
+			close(done)
+		}()
+		if err := store.Move("artist/justice", "band/justice"); err != nil {
+			t.Fatalf("Error moving member, %v", err)
+		}
+		<-done
+
+		// The put came either before the move, or after it
+		from, _, _ := store.Get("artist/justice")
+		to, _, _ := store.Get("band/justice")
+		if string(from) != "Audio, Video, Disco" && string(to) != "Audio, Video, Disco" {
+			t.Fatalf("Put made while moving was lost, found <%s> and <%s>",
+				from, to)
+		}
+	}
+}
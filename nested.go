@@ -0,0 +1,144 @@
+package dskvs
+
+import (
+	"sort"
+	"strings"
+)
+
+// Collections returns the names of the collections within collection
+// `parent`, sorted, or of the top collections if `parent` is empty.  Only
+// stores opened with `Options.Nested` have collections within others.
+func (s Store) Collections(parent string) ([]string, error) {
+
+	prefix := ""
+	if parent != "" {
+		if err := s.coll.checkKey(parent); err != nil {
+			return nil, err
+		}
+
+		if !s.coll.isCollectionKey(parent) {
+			return nil, errorGetAllIsNotColl(parent)
+		}
+
+		prefix = strings.TrimSuffix(parent, CollKeySep) + CollKeySep
+	}
+
+	var names []string
+	for name := range s.coll.snapshot() {
+		if !strings.HasPrefix(name, prefix) {
+			continue
+		}
+		// Collections within the children are not listed
+		if strings.Contains(name[len(prefix):], CollKeySep) {
+			continue
+		}
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// checkKey is checkKeyValid, but with nested collections, it also refuses
+// collection names with empty segments, or segments that would lead their
+// directory out of the store.
+func (c *collections) checkKey(key string) error {
+	if err := checkKeyValid(key); err != nil {
+		return err
+	}
+	if !c.opts.Nested {
+		return nil
+	}
+	idx := strings.LastIndex(key, CollKeySep)
+	if idx < 0 {
+		return nil
+	}
+	for _, segment := range strings.Split(key[:idx], CollKeySep) {
+		if segment == "" || segment == "." || segment == ".." {
+			return errorBadSegment(key)
+		}
+	}
+	return nil
+}
+
+// checkCollName verifies that `coll` is the name of a collection, which only
+// holds separators between its segments when collections are nested.
+func (c *collections) checkCollName(coll string) error {
+	if !c.opts.Nested {
+		return checkCollName(coll)
+	}
+	return c.checkKey(coll + CollKeySep)
+}
+
+// isCollectionKey tells if `key` is a collection key.  With nested
+// collections, only keys without a separator or ending with one are.
+func (c *collections) isCollectionKey(key string) bool {
+	if !c.opts.Nested {
+		return isCollectionKey(key)
+	}
+	return !strings.Contains(key, CollKeySep) || strings.HasSuffix(key, CollKeySep)
+}
+
+// collName returns the name of the collection of collection key `key`.
+func (c *collections) collName(key string) string {
+	if !c.opts.Nested {
+		return key
+	}
+	return strings.TrimSuffix(key, CollKeySep)
+}
+
+// splitKeys splits a full key in a (collection, member) tuple.  With nested
+// collections, the member is only the last segment.
+func (c *collections) splitKeys(fullKey string) (string, string) {
+	if !c.opts.Nested {
+		return splitKeys(fullKey)
+	}
+	idx := strings.LastIndex(fullKey, CollKeySep)
+	return fullKey[:idx], fullKey[idx+len(CollKeySep):]
+}
+
+// addMember adds a member for collection `coll` and, with nested collections,
+// for the parents it lacks.  It returns the members added, parents first.
+// The collections must be locked.
+func (c *collections) addMember(coll string) []*member {
+	added := c.addParents(coll)
+	m := c.newMember(coll)
+	c.members[coll] = m
+	return append(added, m)
+}
+
+// addParents adds a member for the parents of collection `coll` that lack
+// one, and returns them.  The collections must be locked.
+func (c *collections) addParents(coll string) []*member {
+	if !c.opts.Nested {
+		return nil
+	}
+	var added []*member
+	for i := 0; i < len(coll); i++ {
+		if !strings.HasPrefix(coll[i:], CollKeySep) {
+			continue
+		}
+		parent := coll[:i]
+		if _, ok := c.members[parent]; !ok {
+			m := c.newMember(parent)
+			c.members[parent] = m
+			added = append(added, m)
+		}
+	}
+	return added
+}
+
+// subtree returns the member of collection `coll`, if any, and with nested
+// collections, those of the collections within it, sorted by collection.
+// The collections must be locked.
+func (c *collections) subtree(coll string) []*member {
+	var members []*member
+	for name, m := range c.members {
+		if name == coll || c.opts.Nested && strings.HasPrefix(name, coll+CollKeySep) {
+			members = append(members, m)
+		}
+	}
+	sort.Slice(members, func(i, j int) bool {
+		return members[i].coll < members[j].coll
+	})
+	return members
+}
@@ -0,0 +1,146 @@
+package dskvs
+
+import (
+	"os"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+)
+
+var nestedData = map[string][]byte{
+	"artist/daft_punk":           []byte("French house"),
+	"artist/daft_punk/discovery": []byte("2001"),
+	"artist/daft_punk/homework":  []byte("1997"),
+	"artist/justice/cross":       []byte("2007"),
+	"label/ed_banger":            []byte("Paris"),
+}
+
+func openNested(t *testing.T) *Store {
+	store, _, err := OpenWith("./db", Options{Nested: true})
+	if err != nil {
+		t.Fatalf("Error opening store, %v", err)
+	}
+	return store
+}
+
+func checkGetAll(store *Store, coll string, expected []string, t *testing.T) {
+	values, err := store.GetAll(coll)
+	if err != nil {
+		t.Fatalf("Error getting collection %s, %v", coll, err)
+	}
+	var actual []string
+	for _, value := range values {
+		actual = append(actual, string(value))
+	}
+	sort.Strings(actual)
+	if !reflect.DeepEqual(expected, actual) {
+		t.Errorf("Expected %v in %s but was %v", expected, coll, actual)
+	}
+}
+
+func TestNestedCollections(t *testing.T) {
+	store := openNested(t)
+	fillStore(store, nestedData, t)
+
+	checkGetAll(store, "artist", []string{"French house"}, t)
+	checkGetAll(store, "artist/daft_punk/", []string{"1997", "2001"}, t)
+	children, _ := store.Collections("artist")
+	expected := []string{"artist/daft_punk", "artist/justice"}
+	if !reflect.DeepEqual(expected, children) {
+		t.Errorf("Expected children %v but were %v", expected, children)
+	}
+
+	if err := store.Close(); err != nil {
+		t.Fatalf("Error closing store, %v", err)
+	}
+
+	// Collections within others are found again, with their own directory
+	store = openNested(t)
+	defer func() { tearDown(store, t) }()
+	if _, err := os.Stat("db/artist/daft_punk"); err != nil {
+		t.Errorf("Expected a directory for the nested collection, %v", err)
+	}
+	for key, value := range nestedData {
+		if val, _, _ := store.Get(key); string(val) != string(value) {
+			t.Errorf("Expected <%s> at %s but was <%s>", value, key, val)
+		}
+	}
+
+	store.DeleteAll("artist")
+	if _, ok, _ := store.Get("artist/daft_punk/discovery"); ok {
+		t.Errorf("Expected collections within to be deleted")
+	}
+	top, _ := store.Collections("")
+	if !reflect.DeepEqual([]string{"label"}, top) {
+		t.Errorf("Expected only <label> left, was %v", top)
+	}
+}
+
+func TestRenameNestedCollection(t *testing.T) {
+	store := openNested(t)
+	fillStore(store, nestedData, t)
+
+	if err := store.RenameCollection("artist", "band"); err != nil {
+		t.Fatalf("Error renaming collection, %v", err)
+	}
+	if err := store.Close(); err != nil {
+		t.Fatalf("Error closing store, %v", err)
+	}
+
+	store = openNested(t)
+	defer func() { tearDown(store, t) }()
+	checkGetAll(store, "band/daft_punk/", []string{"1997", "2001"}, t)
+	if _, ok, _ := store.Get("artist/justice/cross"); ok {
+		t.Errorf("Old collection still holds its members")
+	}
+}
+
+func TestErrorWhenNestedKeyHasBadSegment(t *testing.T) {
+	store := openNested(t)
+	defer tearDown(store, t)
+
+	for _, key := range []string{"artist//air", "artist/../air", "artist/./air"} {
+		err := store.Put(key, []byte("Moon Safari"))
+		if _, isRightType := err.(KeyError); !isRightType {
+			t.Errorf("Should have returned an error of type KeyError"+
+				" for %s, error was %v",
+				key, err)
+		}
+	}
+
+	_, err := store.GetAll("artist/daft_punk")
+	if _, isRightType := err.(KeyError); !isRightType {
+		t.Errorf("Should have returned an error of type KeyError"+
+			", error was %v",
+			err)
+	}
+}
+
+func TestRestoreNestedCollection(t *testing.T) {
+	opts := Options{Nested: true, Trash: time.Hour}
+	store, _, err := OpenWith("./db", opts)
+	if err != nil {
+		t.Fatalf("Error opening store, %v", err)
+	}
+	fillStore(store, nestedData, t)
+	store.DeleteAll("artist")
+	if err := store.Close(); err != nil {
+		t.Fatalf("Error closing store, %v", err)
+	}
+
+	store, _, err = OpenWith("./db", opts)
+	if err != nil {
+		t.Fatalf("Error opening store, %v", err)
+	}
+	defer func() { tearDown(store, t) }()
+	restored, err := store.RestoreCollection("artist")
+	if err != nil || restored != 4 {
+		t.Errorf("Expected 4 members restored, was %d, %v", restored, err)
+	}
+	for key, value := range nestedData {
+		if val, _, _ := store.Get(key); string(val) != string(value) {
+			t.Errorf("Expected <%s> at %s but was <%s>", value, key, val)
+		}
+	}
+}
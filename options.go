@@ -0,0 +1,106 @@
+package dskvs
+
+import (
+	"time"
+)
+
+// Options tune how a Store loads and persists its data.  The zero value gives
+// the behavior of `Open`.
+type Options struct {
+	// Quarantine moves the page files that can't be loaded, because they
+	// are corrupted or of an incompatible version, into the `.quarantine`
+	// directory of the store.  Otherwise they are left in place, and the
+	// next write to their key overwrites them.
+	Quarantine bool
+
+	// ScrubRate enables the scrubber of the store, which re-reads the page
+	// files in the background at about ScrubRate bytes per second, and has
+	// the janitor rewrite those that don't match memory.  Zero disables it.
+	ScrubRate int64
+
+	// Compression compresses the values of every collection before they are
+	// written to disk.  Nil leaves them as they are.
+	Compression Compressor
+	// CollectionCompression overrides Compression for the collections it
+	// holds.  A collection mapped to nil is not compressed.
+	CollectionCompression map[string]Compressor
+	// CompressMinSize is the size in bytes below which values are not
+	// compressed, the overhead not being worth it.
+	CompressMinSize int
+	// CompressInMemory keeps the compressed values in memory too, trading
+	// the time to decompress them on every read for memory.
+	CompressInMemory bool
+
+	// EncryptionKey encrypts page files with AES-GCM.  It must be 16, 24 or
+	// 32 bytes long.  It is the same as a KeyProvider holding only this key,
+	// with ID 1.
+	EncryptionKey []byte
+	// KeyProvider encrypts page files with AES-GCM using the keys it
+	// provides.  It takes precedence over EncryptionKey.  Opening a store
+	// holding files encrypted with a key the provider doesn't have fails.
+	KeyProvider KeyProvider
+
+	// History has the collections it holds keep the history of their
+	// members, as their policy says, for `History` and `GetAt`.
+	History map[string]HistoryPolicy
+
+	// Trash has `Delete` and `DeleteAll` move members to the `.trash`
+	// directory of the store, where they are kept for this long, for
+	// `Undelete` and `RestoreCollection`.  Zero deletes them at once.
+	Trash time.Duration
+
+	// Nested makes every segment of a full key but the last a collection
+	// within the previous one, with a directory of its own.  Collection
+	// keys then end with CollKeySep, unless they have a single segment,
+	// and `DeleteAll` deletes the collections within too.  A store must
+	// always be opened with the same setting.
+	Nested bool
+
+	// Indexes are created when the store is opened, and filled in the
+	// background.  `Lookup` waits until they are, and so do the writes to
+	// a collection with a unique one, so that it refuses every conflict.
+	Indexes []Index
+
+	// FullText has the collections it holds keep a full-text index of their
+	// values, for `Search`.  It is written when the store is closed, and
+	// built again in the background when the store is opened without it.
+	FullText []string
+}
+
+// historyFor tells how much history collection `coll` keeps, or nil if it
+// keeps none.
+func (o *Options) historyFor(coll string) *HistoryPolicy {
+	policy, ok := o.History[coll]
+	if !ok {
+		return nil
+	}
+	return &policy
+}
+
+// keyProvider returns the keys used to encrypt page files, or nil if they
+// are not encrypted.
+func (o *Options) keyProvider() KeyProvider {
+	if o.KeyProvider != nil {
+		return o.KeyProvider
+	}
+	if len(o.EncryptionKey) != 0 {
+		return KeyRing{1, map[uint32][]byte{1: o.EncryptionKey}}
+	}
+	return nil
+}
+
+// compressionFor tells how the values of collection `coll` are compressed.
+func (o *Options) compressionFor(coll string) *compression {
+	c, ok := o.CollectionCompression[coll]
+	if !ok {
+		c = o.Compression
+	}
+	if c == nil {
+		return nil
+	}
+	return &compression{
+		c:        c,
+		minSize:  o.CompressMinSize,
+		inMemory: o.CompressInMemory,
+	}
+}
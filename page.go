@@ -1,19 +1,61 @@
 package dskvs
 
 import (
+	"errors"
 	"sync"
+	"time"
 )
 
 type page struct {
 	isDirty   bool
 	isDeleted bool
+	// isDropped is true once the page was removed from its member without
+	// ever holding a value.  Nothing may be set on it anymore.
+	isDropped bool
 	basepath  string
 	coll      string
 	key       string
 	value     []byte
+	// comp is how the member of this page compresses values.  When packed,
+	// value is held compressed by it.
+	comp   *compression
+	packed bool
+	// keys encrypt the file of the page when not nil.  keyID is the key
+	// that encrypted the current file.
+	keys  KeyProvider
+	keyID uint32
+	// seq is the number of the last change of the page in changes.
+	// versions are the previous values of the page that snapshots can
+	// still see.
+	changes  *changeLog
+	seq      uint64
+	versions []version
+	// hist is how much of its history the page keeps, if any, in
+	// revisions.
+	hist      *HistoryPolicy
+	revisions []Revision
+	// bin is the trash bin of the store, if it has one.  Once the page is
+	// deleted, trashed holds its value until the janitor moves it to the
+	// trash.
+	bin           *trashBin
+	trashed       []byte
+	trashedPacked bool
+	// files is the lock of the files of the member of the page.
+	files *sync.RWMutex
+	// indexes are those of the collection of the page.
+	indexes *indexSet
 	sync.RWMutex
 }
 
+// A version is a value that a page held from change `seq` until change
+// `until`.
+type version struct {
+	seq    uint64
+	until  uint64
+	value  []byte
+	packed bool
+}
+
 func newPage(basepath, coll, key string) *page {
 	return &page{
 		isDirty:   false,
@@ -28,31 +70,262 @@ func newPage(basepath, coll, key string) *page {
 func (p *page) get() []byte {
 	p.RLock()
 	if p.isDeleted {
+		p.RUnlock()
 		return nil
 	}
+	value := p.plainValue()
 	p.RUnlock()
-	return p.value
+	return value
 }
 
-func (p *page) set(value []byte) {
-	p.Lock()
+// live tells if the page holds a value.
+func (p *page) live() bool {
+	p.RLock()
+	live := !p.isDeleted && p.value != nil
+	p.RUnlock()
+	return live
+}
+
+// plainValue returns the value of the page, uncompressed.  The page must be
+// locked for reading.
+func (p *page) plainValue() []byte {
+	return p.unpacked(p.value, p.packed)
+}
+
+func (p *page) unpacked(value []byte, packed bool) []byte {
+	if packed {
+		return p.comp.unpack(value)
+	}
+	return value
+}
+
+// valueAt returns the value that the page held after change `seq`, if any.
+// The page must be locked for reading.
+func (p *page) valueAt(seq uint64) ([]byte, bool) {
+	if p.seq <= seq {
+		if p.isDeleted || p.value == nil {
+			return nil, false
+		}
+		return p.plainValue(), true
+	}
+	for _, v := range p.versions {
+		if v.seq <= seq && seq < v.until {
+			return p.unpacked(v.value, v.packed), true
+		}
+	}
+	return nil, false
+}
+
+// retire keeps the value of the page for the snapshots that can see it, before
+// change `seq` replaces it, and drops the versions that no snapshot can see
+// anymore.  It tells if a snapshot can see the page as it is.  The page must
+// be locked.
+func (p *page) retire(seq uint64) bool {
+	oldest, needed := p.changes.needed(seq)
+	if !needed {
+		p.versions = nil
+		return false
+	}
+	var kept []version
+	for _, v := range p.versions {
+		if v.until > oldest {
+			kept = append(kept, v)
+		}
+	}
+	if p.value != nil && !p.isDeleted {
+		kept = append(kept, version{p.seq, seq, p.value, p.packed})
+	}
+	p.versions = kept
+	return true
+}
+
+// payload returns the value of the page as it must be written in its file:
+// compressed and encrypted as the member of the page says.  The page must be
+// locked for reading.
+func (p *page) payload() (pagePayload, error) {
+	payload := pagePayload{data: p.value, length: uint64(len(p.value))}
+	if p.packed {
+		payload.compression = p.comp.c.ID()
+		payload.length = uint64(len(p.plainValue()))
+	} else if p.comp.shouldPack(len(p.value)) {
+		if packed, ok := p.comp.pack(p.value); ok {
+			payload.data = packed
+			payload.compression = p.comp.c.ID()
+		}
+	}
+	if p.keys == nil {
+		return payload, nil
+	}
+	err := seal(p.keys, p.key, &payload)
+	return payload, err
+}
+
+// errPageDropped tells that a page was dropped by its member, so that the
+// write must be made on the page that replaces it.
+var errPageDropped = errors.New("page was dropped")
+
+func (p *page) set(value []byte) error {
+	_, err := p.store(value, false)
+	return err
+}
+
+// setNew sets the value of the page only if it has none yet, and tells if it
+// did.
+func (p *page) setNew(value []byte) (bool, error) {
+	return p.store(value, true)
+}
+
+// store sets the value of the page, unless `onlyNew` says to only do so if it
+// has none yet, and tells if it did.  A conflict in a unique index fails it,
+// and so does a dropped page, with errPageDropped.
+func (p *page) store(value []byte, onlyNew bool) (bool, error) {
 	newBytes := make([]byte, len(value))
 	copy(newBytes, value)
-	p.value = newBytes
-	wasDirty := p.isDirty
-	p.isDirty = true
+	plain := newBytes
+	packed := false
+	if p.comp.keepsPacked(len(newBytes)) {
+		newBytes, packed = p.comp.pack(newBytes)
+	}
+
+	p.waitIndexes()
+	p.Lock()
+	if p.isDropped {
+		p.Unlock()
+		return false, errPageDropped
+	}
+	// A deleted page was removed from its member, so a value set on it
+	// would be lost
+	if onlyNew && (p.value != nil || p.isDeleted) {
+		p.Unlock()
+		return false, nil
+	}
+	wasDirty, err := p.replace(plain, newBytes, packed)
 	p.Unlock()
+	if err != nil {
+		return false, err
+	}
+	if !wasDirty {
+		jan.writePage(p)
+	}
+	return true, nil
+}
+
+// update replaces the value of the page, nil if it has none, with what `fn`
+// makes of it, as one change, unless `onlyExisting` says to only do so if it
+// has one.  `fn` must return a new slice.  It tells if it did, which it
+// doesn't once the page is deleted or dropped, nor if `fn` fails.
+func (p *page) update(fn func(old []byte) ([]byte, error), onlyExisting bool) (bool, error) {
+	p.waitIndexes()
+	p.Lock()
+	// A deleted page was removed from its member, so a value set on it
+	// would be lost
+	if p.isDeleted || p.isDropped || (onlyExisting && p.value == nil) {
+		p.Unlock()
+		return false, nil
+	}
+	var old []byte
+	if p.value != nil {
+		old = p.plainValue()
+	}
+	plain, err := fn(old)
+	if err != nil {
+		p.Unlock()
+		return false, err
+	}
+	newBytes, packed := plain, false
+	if p.comp.keepsPacked(len(plain)) {
+		newBytes, packed = p.comp.pack(plain)
+	}
+	wasDirty, err := p.replace(plain, newBytes, packed)
+	p.Unlock()
+	if err != nil {
+		return false, err
+	}
 	if !wasDirty {
 		jan.writePage(p)
 	}
+	return true, nil
+}
+
+// waitIndexes waits until the unique indexes of the page can tell conflicts.
+func (p *page) waitIndexes() {
+	p.RLock()
+	indexes := p.indexes
+	p.RUnlock()
+	indexes.waitUnique()
+}
+
+// replace sets the value of the page to `plain`, held as `value`, as one
+// change, and tells if the page was dirty already.  A conflict in a unique
+// index fails it.  The page must be locked.
+func (p *page) replace(plain, value []byte, packed bool) (bool, error) {
+	// A deleted page is not in the indexes anymore, nor should it be
+	// put back
+	if !p.isDeleted {
+		if err := p.indexes.put(p.key, p.indexes.extract(p.key, plain), false); err != nil {
+			return false, err
+		}
+	}
+	seq := p.changes.next()
+	p.retire(seq)
+	if p.hist != nil {
+		p.record(Revision{Time: time.Now(), Value: plain})
+	}
+	p.value = value
+	p.packed = packed
+	p.seq = seq
+	wasDirty := p.isDirty
+	p.isDirty = true
+	return wasDirty, nil
 }
 
-func (p *page) delete() {
+// markDeleted deletes the value of the page with change `seq`, and leaves a
+// tombstone for it, but doesn't have the janitor delete its file.  It tells
+// if the page was already dirty, in which case the janitor already knows of
+// it.
+func (p *page) markDeleted(seq uint64) bool {
 	p.Lock()
+	wasDirty := p.erase(seq)
+	p.Unlock()
+	return wasDirty
+}
+
+// erase is `markDeleted` for a page that is locked.
+func (p *page) erase(seq uint64) bool {
+	if p.retire(seq) {
+		p.changes.bury(p, seq)
+	}
+	if p.hist != nil && !p.isDeleted {
+		p.record(Revision{Time: time.Now(), Deleted: true})
+	}
+	if !p.isDeleted {
+		p.indexes.remove(p.key)
+	}
+	if p.bin != nil && p.value != nil && !p.isDeleted {
+		p.trashed = p.value
+		p.trashedPacked = p.packed
+		p.bin.add(p)
+	}
 	wasDirty := p.isDirty
 	p.value = nil
+	p.packed = false
 	p.isDirty = true
 	p.isDeleted = true
+	p.seq = seq
+	p.changes.tombstone(entryName(p), seq)
+	return wasDirty
+}
+
+// touch flags the page as dirty so that the janitor writes it again, even
+// though its value didn't change.  A page without a value is left alone.
+func (p *page) touch() {
+	p.Lock()
+	if p.isDeleted || p.value == nil {
+		p.Unlock()
+		return
+	}
+	wasDirty := p.isDirty
+	p.isDirty = true
 	p.Unlock()
 	if !wasDirty {
 		jan.writePage(p)
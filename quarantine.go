@@ -0,0 +1,66 @@
+package dskvs
+
+import (
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+)
+
+// quarantineDir is where page files that failed to load are moved, under the
+// storage path of a store.
+const quarantineDir = ".quarantine"
+
+// An OpenReport tells what `OpenWith` did with every file it found on disk.
+type OpenReport struct {
+	// Loaded lists the page files that were loaded in memory.
+	Loaded []string
+	// Skipped lists the files that were not loaded and left in place.
+	Skipped []FileReport
+	// Quarantined lists the page files that were not loaded and were moved
+	// to the quarantine directory of the store.
+	Quarantined []FileReport
+}
+
+// A FileReport explains why a file was not loaded.  When the file was
+// quarantined, Filename is where it was moved to.
+type FileReport struct {
+	Filename string
+	Reason   error
+}
+
+func (r *OpenReport) loaded(filename string) {
+	r.Loaded = append(r.Loaded, filename)
+}
+
+func (r *OpenReport) skipped(filename string, reason error) {
+	r.Skipped = append(r.Skipped, FileReport{filename, reason})
+}
+
+func (r *OpenReport) quarantined(filename string, reason error) {
+	r.Quarantined = append(r.Quarantined, FileReport{filename, reason})
+}
+
+// quarantineFile moves `filename`, a page file of collection `coll`, into the
+// quarantine directory of the store at `basepath`.  It returns the new
+// location of the file.
+func quarantineFile(basepath, coll, filename string) (string, error) {
+	folderName := filepath.Join(basepath, quarantineDir, coll)
+	if err := os.MkdirAll(folderName, DIR_PERM); err != nil {
+		log.Printf("Couldn't create directory <%s> : %v", folderName, err)
+		return "", err
+	}
+
+	dest := filepath.Join(folderName, filepath.Base(filename))
+	// Don't overwrite a file quarantined by a previous `Open`
+	if _, err := os.Stat(dest); err == nil {
+		dest += "." + strconv.FormatInt(time.Now().UnixNano(), 10)
+	}
+
+	if err := os.Rename(filename, dest); err != nil {
+		log.Printf("Couldn't move <%s> to quarantine : %v", filename, err)
+		return "", err
+	}
+	return dest, nil
+}
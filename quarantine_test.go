@@ -0,0 +1,87 @@
+package dskvs
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func writeJunkPage(t *testing.T) string {
+	store := setUp(t)
+	if err := store.Put("artist/daft_punk", []byte("Discovery")); err != nil {
+		t.Fatalf("Error putting data in, %v", err)
+	}
+	if err := store.Close(); err != nil {
+		t.Fatalf("Error closing store, %v", err)
+	}
+
+	filename := filepath.Join(store.storagePath, "artist", "junk")
+	err := ioutil.WriteFile(filename, []byte{0xDE, 0xAD, 0xBE, 0xEF}, FILE_PERM)
+	if err != nil {
+		t.Fatalf("Couldn't write file <%s> : %v", filename, err)
+	}
+	return filename
+}
+
+func TestOpenReportsSkippedFiles(t *testing.T) {
+	junk := writeJunkPage(t)
+
+	store, report, err := OpenWith("./db", Options{})
+	if err != nil {
+		t.Fatalf("Error opening store, %v", err)
+	}
+	defer tearDown(store, t)
+
+	if len(report.Loaded) != 1 {
+		t.Errorf("Expected 1 file loaded but was %d", len(report.Loaded))
+	}
+	if len(report.Skipped) != 1 {
+		t.Fatalf("Expected 1 file skipped but was %d", len(report.Skipped))
+	}
+	if report.Skipped[0].Filename != junk {
+		t.Errorf("Expected <%s> to be skipped but was <%s>",
+			junk, report.Skipped[0].Filename)
+	}
+	if _, isRightType := report.Skipped[0].Reason.(FileError); !isRightType {
+		t.Errorf("Should have a reason of type FileError, was %v",
+			report.Skipped[0].Reason)
+	}
+	if _, err := os.Stat(junk); err != nil {
+		t.Errorf("Skipped file should have been left in place, %v", err)
+	}
+}
+
+func TestOpenQuarantinesBadFiles(t *testing.T) {
+	junk := writeJunkPage(t)
+
+	store, report, err := OpenWith("./db", Options{Quarantine: true})
+	if err != nil {
+		t.Fatalf("Error opening store, %v", err)
+	}
+	defer tearDown(store, t)
+
+	if len(report.Skipped) != 0 {
+		t.Errorf("Expected no file skipped but was %d", len(report.Skipped))
+	}
+	if len(report.Quarantined) != 1 {
+		t.Fatalf("Expected 1 file quarantined but was %d",
+			len(report.Quarantined))
+	}
+
+	expected := filepath.Join(store.storagePath, quarantineDir, "artist", "junk")
+	if report.Quarantined[0].Filename != expected {
+		t.Errorf("Expected file quarantined at <%s> but was <%s>",
+			expected, report.Quarantined[0].Filename)
+	}
+	if _, err := os.Stat(junk); !os.IsNotExist(err) {
+		t.Errorf("Quarantined file should have been moved, %v", err)
+	}
+	if _, err := os.Stat(expected); err != nil {
+		t.Errorf("Quarantined file should be in quarantine, %v", err)
+	}
+
+	if _, err := store.GetAll(quarantineDir); err == nil {
+		t.Errorf("Quarantine should not be usable as a collection")
+	}
+}
@@ -0,0 +1,568 @@
+package dskvs
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// KeyField is the path that stands for the member itself, rather than a field
+// of its value, in queries.
+const KeyField = "$key"
+
+// An Op is the comparison made by a query predicate.
+type Op int
+
+const (
+	// OpEq matches fields equal to the value.
+	OpEq Op = iota
+	// OpLt matches fields lesser than the value.
+	OpLt
+	// OpGt matches fields greater than the value.
+	OpGt
+	// OpIn matches fields equal to one of the values.
+	OpIn
+	// OpExists matches values that have the field.
+	OpExists
+)
+
+var opNames = map[Op]string{
+	OpEq:     "eq",
+	OpLt:     "lt",
+	OpGt:     "gt",
+	OpIn:     "in",
+	OpExists: "exists",
+}
+
+func (op Op) String() string {
+	if name, ok := opNames[op]; ok {
+		return name
+	}
+	return fmt.Sprintf("Op(%d)", int(op))
+}
+
+// A Predicate selects the members whose JSON field at Path compares to Value,
+// or for OpIn to one of Values, as Op says.  Paths are separated by dots, like
+// "address.city", and their segments index arrays when they are numbers.
+// Only numbers, strings and booleans of the same kind are lesser or greater
+// than others.
+type Predicate struct {
+	Path   string
+	Op     Op
+	Value  interface{}
+	Values []interface{}
+}
+
+// Eq selects the members whose field at `path` equals `value`.
+func Eq(path string, value interface{}) Predicate {
+	return Predicate{Path: path, Op: OpEq, Value: value}
+}
+
+// Lt selects the members whose field at `path` is lesser than `value`.
+func Lt(path string, value interface{}) Predicate {
+	return Predicate{Path: path, Op: OpLt, Value: value}
+}
+
+// Gt selects the members whose field at `path` is greater than `value`.
+func Gt(path string, value interface{}) Predicate {
+	return Predicate{Path: path, Op: OpGt, Value: value}
+}
+
+// In selects the members whose field at `path` equals one of `values`.
+func In(path string, values ...interface{}) Predicate {
+	return Predicate{Path: path, Op: OpIn, Values: values}
+}
+
+// Exists selects the members that have a field at `path`.
+func Exists(path string) Predicate {
+	return Predicate{Path: path, Op: OpExists}
+}
+
+// A Query selects members of a collection holding JSON values, that satisfy
+// every predicate of Where.  They are sorted by their field at path SortBy, or
+// by member if it's empty, then the first Offset are skipped, and at most
+// Limit are returned, unless it's zero.  Members lacking the field come last,
+// and members that sort the same are sorted by member.
+type Query struct {
+	Where  []Predicate
+	SortBy string
+	Desc   bool
+	Offset int
+	Limit  int
+}
+
+// A Match is a member selected by a query, and its value.
+type Match struct {
+	Key   string
+	Value []byte
+}
+
+// A PlanKind says how a query finds the members that it considers.
+type PlanKind int
+
+const (
+	// FullScan considers every member of the collection.
+	FullScan PlanKind = iota
+	// KeyLookup considers the members that a predicate on KeyField names.
+	KeyLookup
+	// IndexLookup considers the members that a field index finds.
+	IndexLookup
+	// KeyRangeScan considers the members within the bounds that
+	// predicates on KeyField set, in order.
+	KeyRangeScan
+)
+
+// A Plan tells how a query is run.  Path is the field of the predicate that
+// chose the members considered, and Index the index it used, if any.  Every
+// predicate is then verified on their value.
+type Plan struct {
+	Kind  PlanKind
+	Path  string
+	Index string
+}
+
+func (p Plan) String() string {
+	switch p.Kind {
+	case KeyLookup:
+		return "key lookup"
+	case IndexLookup:
+		return fmt.Sprintf("index lookup on <%s> for %s", p.Index, p.Path)
+	case KeyRangeScan:
+		return "key range scan"
+	}
+	return "full scan"
+}
+
+// Query returns the members of collection `coll` that query `q` selects.  It
+// finds them with a secondary index or in a range of keys when it can, and
+// reads every member otherwise; `Explain` tells which.  Values that are not
+// JSON only match queries that don't look into them.
+//
+// ATTENTION : do not modify the value of the slices that are returned to
+// you.
+func (s Store) Query(coll string, q Query) ([]Match, error) {
+	preds, plan, err := s.plan(coll, q)
+	if err != nil {
+		return nil, err
+	}
+	m, ok := s.coll.member(coll)
+	if !ok {
+		return nil, nil
+	}
+
+	decode := q.SortBy != "" && q.SortBy != KeyField
+	for _, pred := range preds {
+		decode = decode || pred.Path != KeyField
+	}
+
+	var found []queryMatch
+	for _, aPage := range candidates(m, plan, preds) {
+		value := aPage.get()
+		if value == nil {
+			continue
+		}
+		match := queryMatch{Match: Match{aPage.key, value}}
+		if decode && json.Unmarshal(value, &match.doc) != nil {
+			continue
+		}
+		if match.satisfies(preds) {
+			found = append(found, match)
+		}
+	}
+
+	sortMatches(found, q.SortBy, q.Desc)
+	if q.Offset >= len(found) {
+		return nil, nil
+	}
+	found = found[q.Offset:]
+	if q.Limit > 0 && q.Limit < len(found) {
+		found = found[:q.Limit]
+	}
+	matches := make([]Match, len(found))
+	for i, match := range found {
+		matches[i] = match.Match
+	}
+	return matches, nil
+}
+
+// Explain tells how `Query` would run query `q` on collection `coll`.
+func (s Store) Explain(coll string, q Query) (Plan, error) {
+	_, plan, err := s.plan(coll, q)
+	return plan, err
+}
+
+// plan verifies query `q` and returns its predicates, with their values as
+// JSON decodes them, and the way to run it.
+func (s Store) plan(coll string, q Query) ([]Predicate, Plan, error) {
+	if err := s.coll.checkCollName(coll); err != nil {
+		return nil, Plan{}, err
+	}
+	if q.Offset < 0 || q.Limit < 0 {
+		return nil, Plan{}, errorBadQuery("negative offset or limit")
+	}
+
+	preds := make([]Predicate, len(q.Where))
+	for i, pred := range q.Where {
+		normalized, err := normalizePredicate(pred)
+		if err != nil {
+			return nil, Plan{}, err
+		}
+		preds[i] = normalized
+	}
+
+	set := s.coll.indexSet(coll)
+	plan := Plan{Kind: FullScan}
+	for _, pred := range preds {
+		switch {
+		case pred.Path == KeyField && (pred.Op == OpEq || pred.Op == OpIn):
+			return preds, Plan{Kind: KeyLookup, Path: KeyField}, nil
+		case plan.Kind != IndexLookup && (pred.Op == OpEq || pred.Op == OpIn) &&
+			pred.Path != KeyField && allTerms(pred):
+			if idx, ok := set.byField(pred.Path); ok {
+				plan = Plan{Kind: IndexLookup, Path: pred.Path, Index: idx.Name}
+			}
+		case plan.Kind == FullScan && pred.Path == KeyField &&
+			(pred.Op == OpLt || pred.Op == OpGt):
+			plan = Plan{Kind: KeyRangeScan, Path: KeyField}
+		}
+	}
+	return preds, plan, nil
+}
+
+// normalizePredicate verifies predicate `pred`, and returns it with its values
+// as JSON decodes them, so that they compare to those of the members.
+func normalizePredicate(pred Predicate) (Predicate, error) {
+	if _, ok := opNames[pred.Op]; !ok {
+		return pred, errorBadQuery(fmt.Sprintf("unknown operator %v on %s", pred.Op, pred.Path))
+	}
+	if pred.Path == "" {
+		return pred, errorBadQuery("predicate without path")
+	}
+
+	var err error
+	switch pred.Op {
+	case OpEq, OpLt, OpGt:
+		pred.Value, err = normalizeValue(pred.Value)
+	case OpIn:
+		values := make([]interface{}, len(pred.Values))
+		for i, value := range pred.Values {
+			if values[i], err = normalizeValue(value); err != nil {
+				break
+			}
+		}
+		pred.Values = values
+	}
+	if err != nil {
+		return pred, errorBadQuery(fmt.Sprintf("value of %s : %v", pred.Path, err))
+	}
+
+	if pred.Op == OpLt || pred.Op == OpGt {
+		if _, ok := compareValues(pred.Value, pred.Value); !ok {
+			return pred, errorBadQuery(fmt.Sprintf("%s can't compare to %v", pred.Path, pred.Value))
+		}
+	}
+	if pred.Path == KeyField && pred.Op != OpExists {
+		for _, value := range predValues(pred) {
+			if _, isString := value.(string); !isString {
+				return pred, errorBadQuery(fmt.Sprintf("%s compares to strings only", KeyField))
+			}
+		}
+	}
+	return pred, nil
+}
+
+func normalizeValue(value interface{}) (interface{}, error) {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return nil, err
+	}
+	var normalized interface{}
+	err = json.Unmarshal(data, &normalized)
+	return normalized, err
+}
+
+// candidates returns the pages that query plan `plan` considers, of member
+// `m`.
+func candidates(m *member, plan Plan, preds []Predicate) []*page {
+	switch plan.Kind {
+	case KeyLookup, IndexLookup:
+		var keys []string
+		for _, pred := range preds {
+			if pred.Path != plan.Path || (pred.Op != OpEq && pred.Op != OpIn) {
+				continue
+			}
+			if plan.Kind == IndexLookup && !allTerms(pred) {
+				continue
+			}
+			keys = plannedKeys(m, plan, pred)
+			break
+		}
+		var pages []*page
+		m.RLock()
+		for _, key := range keys {
+			if aPage, ok := m.entries[key]; ok {
+				pages = append(pages, aPage)
+			}
+		}
+		m.RUnlock()
+		return pages
+
+	case KeyRangeScan:
+		pages := m.pages()
+		sort.Slice(pages, func(i, j int) bool {
+			return pages[i].key < pages[j].key
+		})
+		from, to := 0, len(pages)
+		for _, pred := range preds {
+			if pred.Path != KeyField {
+				continue
+			}
+			bound := pred.Value.(string)
+			switch pred.Op {
+			case OpGt:
+				if i := sort.Search(len(pages), func(i int) bool { return pages[i].key > bound }); i > from {
+					from = i
+				}
+			case OpLt:
+				if i := sort.Search(len(pages), func(i int) bool { return pages[i].key >= bound }); i < to {
+					to = i
+				}
+			}
+		}
+		if from >= to {
+			return nil
+		}
+		return pages[from:to]
+	}
+	return m.pages()
+}
+
+// plannedKeys returns the members that predicate `pred` names, or finds in the
+// index of plan `plan`.
+func plannedKeys(m *member, plan Plan, pred Predicate) []string {
+	seen := make(map[string]bool)
+	var keys []string
+	for _, value := range predValues(pred) {
+		var found []string
+		if plan.Kind == KeyLookup {
+			if key, isString := value.(string); isString {
+				found = []string{key}
+			}
+		} else if idx, ok := m.indexes.get(plan.Index); ok {
+			term, _ := termOf(value)
+			found = m.indexes.lookup(idx, term)
+		}
+		for _, key := range found {
+			if !seen[key] {
+				seen[key] = true
+				keys = append(keys, key)
+			}
+		}
+	}
+	return keys
+}
+
+// A queryMatch is a member considered by a query, with its value decoded.
+type queryMatch struct {
+	Match
+	doc interface{}
+}
+
+// field returns the field at path `path` of the member.
+func (match queryMatch) field(path string) (interface{}, bool) {
+	if path == KeyField {
+		return match.Key, true
+	}
+	return lookupPath(match.doc, path)
+}
+
+func (match queryMatch) satisfies(preds []Predicate) bool {
+	for _, pred := range preds {
+		field, found := match.field(pred.Path)
+		if !found {
+			return false
+		}
+		switch pred.Op {
+		case OpEq:
+			if !reflect.DeepEqual(field, pred.Value) {
+				return false
+			}
+		case OpIn:
+			in := false
+			for _, value := range pred.Values {
+				in = in || reflect.DeepEqual(field, value)
+			}
+			if !in {
+				return false
+			}
+		case OpLt, OpGt:
+			cmp, ok := compareValues(field, pred.Value)
+			if !ok || (pred.Op == OpLt && cmp >= 0) || (pred.Op == OpGt && cmp <= 0) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// sortMatches sorts matches by their field at path `path`, then by member.
+func sortMatches(matches []queryMatch, path string, desc bool) {
+	sort.SliceStable(matches, func(i, j int) bool {
+		if path != "" {
+			a, aFound := matches[i].field(path)
+			b, bFound := matches[j].field(path)
+			if aFound != bFound {
+				return aFound
+			}
+			if aFound {
+				cmp := orderValues(a, b)
+				if desc {
+					cmp = -cmp
+				}
+				if cmp != 0 {
+					return cmp < 0
+				}
+			}
+		} else if desc {
+			return matches[i].Key > matches[j].Key
+		}
+		return matches[i].Key < matches[j].Key
+	})
+}
+
+// lookupPath returns the field of JSON document `doc` at path `path`.
+func lookupPath(doc interface{}, path string) (interface{}, bool) {
+	for _, segment := range strings.Split(path, ".") {
+		switch node := doc.(type) {
+		case map[string]interface{}:
+			field, ok := node[segment]
+			if !ok {
+				return nil, false
+			}
+			doc = field
+		case []interface{}:
+			i, err := strconv.Atoi(segment)
+			if err != nil || i < 0 || i >= len(node) {
+				return nil, false
+			}
+			doc = node[i]
+		default:
+			return nil, false
+		}
+	}
+	return doc, true
+}
+
+// compareValues compares two numbers, strings or booleans of the same kind,
+// and tells if it could.
+func compareValues(a, b interface{}) (int, bool) {
+	switch a := a.(type) {
+	case float64:
+		if b, ok := b.(float64); ok {
+			switch {
+			case a < b:
+				return -1, true
+			case a > b:
+				return 1, true
+			}
+			return 0, true
+		}
+	case string:
+		if b, ok := b.(string); ok {
+			return strings.Compare(a, b), true
+		}
+	case bool:
+		if b, ok := b.(bool); ok {
+			switch {
+			case a == b:
+				return 0, true
+			case b:
+				return -1, true
+			}
+			return 1, true
+		}
+	}
+	return 0, false
+}
+
+// orderValues orders any two JSON values : null, then booleans, numbers,
+// strings, and objects or arrays, which sort the same.
+func orderValues(a, b interface{}) int {
+	if cmp, ok := compareValues(a, b); ok {
+		return cmp
+	}
+	return kindRank(a) - kindRank(b)
+}
+
+func kindRank(value interface{}) int {
+	switch value.(type) {
+	case nil:
+		return 0
+	case bool:
+		return 1
+	case float64:
+		return 2
+	case string:
+		return 3
+	}
+	return 4
+}
+
+// termOf returns the term of a JSON number, string, boolean or null in a field
+// index.
+func termOf(value interface{}) (string, bool) {
+	switch value := value.(type) {
+	case nil:
+		return "null", true
+	case bool:
+		return "b:" + strconv.FormatBool(value), true
+	case float64:
+		return "n:" + strconv.FormatFloat(value, 'g', -1, 64), true
+	case string:
+		return "s:" + value, true
+	}
+	return "", false
+}
+
+// predValues returns the values that predicate `pred` compares to.
+func predValues(pred Predicate) []interface{} {
+	switch pred.Op {
+	case OpIn:
+		return pred.Values
+	case OpExists:
+		return nil
+	}
+	return []interface{}{pred.Value}
+}
+
+// allTerms tells if the values of predicate `pred` can be found in a field
+// index.
+func allTerms(pred Predicate) bool {
+	for _, value := range predValues(pred) {
+		if _, ok := termOf(value); !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// fieldExtractor returns the extractor of a field index on JSON field `path`.
+func fieldExtractor(path string) Extractor {
+	return func(key string, value []byte) []string {
+		var doc interface{}
+		if json.Unmarshal(value, &doc) != nil {
+			return nil
+		}
+		field, found := lookupPath(doc, path)
+		if !found {
+			return nil
+		}
+		if term, ok := termOf(field); ok {
+			return []string{term}
+		}
+		return nil
+	}
+}
@@ -0,0 +1,89 @@
+package dskvs
+
+import (
+	"reflect"
+	"testing"
+)
+
+var queryData = map[string][]byte{
+	"user/ada":     []byte(`{"name":"Ada","age":36,"address":{"city":"London"},"tags":["math"]}`),
+	"user/alan":    []byte(`{"name":"Alan","age":41,"address":{"city":"Manchester"}}`),
+	"user/grace":   []byte(`{"name":"Grace","age":85,"address":{"city":"New York"},"tags":["navy"]}`),
+	"user/linus":   []byte(`{"name":"Linus","age":28,"address":{"city":"Helsinki"}}`),
+	"user/unknown": []byte(`not json`),
+}
+
+func checkQuery(store *Store, q Query, expected []string, t *testing.T) {
+	matches, err := store.Query("user", q)
+	if err != nil {
+		t.Fatalf("Error querying %+v, %v", q, err)
+	}
+	var keys []string
+	for _, match := range matches {
+		keys = append(keys, match.Key)
+	}
+	if !reflect.DeepEqual(expected, keys) {
+		t.Errorf("Expected %v for %+v but was %v", expected, q, keys)
+	}
+}
+
+func checkPlan(store *Store, q Query, expected PlanKind, t *testing.T) {
+	plan, err := store.Explain("user", q)
+	if err != nil {
+		t.Fatalf("Error explaining %+v, %v", q, err)
+	}
+	if plan.Kind != expected {
+		t.Errorf("Expected plan %v for %+v but was %v", expected, q, plan)
+	}
+}
+
+func TestQuery(t *testing.T) {
+	store := setUp(t)
+	defer tearDown(store, t)
+	fillStore(store, queryData, t)
+
+	checkQuery(store, Query{}, []string{"ada", "alan", "grace", "linus", "unknown"}, t)
+	checkQuery(store, Query{Where: []Predicate{Eq("address.city", "London")}},
+		[]string{"ada"}, t)
+	checkQuery(store, Query{Where: []Predicate{Gt("age", 30), Lt("age", 50)}},
+		[]string{"ada", "alan"}, t)
+	checkQuery(store, Query{Where: []Predicate{In("name", "Grace", "Linus", 12)}},
+		[]string{"grace", "linus"}, t)
+	checkQuery(store, Query{Where: []Predicate{Exists("tags.0")}},
+		[]string{"ada", "grace"}, t)
+	checkQuery(store, Query{SortBy: "age", Desc: true, Offset: 1, Limit: 2},
+		[]string{"alan", "ada"}, t)
+	checkQuery(store, Query{SortBy: "tags.0"},
+		[]string{"ada", "grace", "alan", "linus"}, t)
+
+	_, err := store.Query("user", Query{Where: []Predicate{Lt("address", map[string]string{})}})
+	if _, isRightType := err.(QueryError); !isRightType {
+		t.Errorf("Should have returned an error of type QueryError"+
+			", error was %v",
+			err)
+	}
+}
+
+func TestQueryPlans(t *testing.T) {
+	store := setUp(t)
+	defer tearDown(store, t)
+	fillStore(store, queryData, t)
+
+	byCity := Query{Where: []Predicate{Gt("age", 30), Eq("address.city", "London")}}
+	checkPlan(store, byCity, FullScan, t)
+	if err := store.CreateFieldIndex("user", "address.city"); err != nil {
+		t.Fatalf("Error creating index, %v", err)
+	}
+	checkPlan(store, byCity, IndexLookup, t)
+	checkQuery(store, byCity, []string{"ada"}, t)
+	store.Put("user/alan", []byte(`{"name":"Alan","age":41,"address":{"city":"London"}}`))
+	checkQuery(store, byCity, []string{"ada", "alan"}, t)
+
+	byKeys := Query{Where: []Predicate{Eq("address.city", "London"), In(KeyField, "alan", "linus")}}
+	checkPlan(store, byKeys, KeyLookup, t)
+	checkQuery(store, byKeys, []string{"alan"}, t)
+
+	byRange := Query{Where: []Predicate{Gt(KeyField, "alan"), Lt(KeyField, "unknown")}}
+	checkPlan(store, byRange, KeyRangeScan, t)
+	checkQuery(store, byRange, []string{"grace", "linus"}, t)
+}
@@ -0,0 +1,486 @@
+package dskvs
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// DefaultVisibility is how long a dequeued message stays hidden from other
+// consumers, unless `QueueOptions` say otherwise.
+const DefaultVisibility = 30 * time.Second
+
+// queueHeaderSize is the size of the header of the messages of a queue : the
+// number of times they were dequeued, then when they are visible again, in
+// nanoseconds since the epoch, zero if they are visible.  Their body follows.
+const queueHeaderSize = 4 + 8
+
+// QueueOptions tune how a `Queue` delivers its messages.
+type QueueOptions struct {
+	// Visibility is how long a dequeued message stays hidden from other
+	// consumers, until it is acked or nacked.  Zero means
+	// DefaultVisibility.
+	Visibility time.Duration
+	// MaxAttempts is how many times a message is dequeued before it is
+	// moved to the DeadLetter queue instead.  Zero doesn't limit it.
+	MaxAttempts int
+	// DeadLetter is the queue where the messages dequeued MaxAttempts times
+	// go.  Empty means the name of the queue followed by ".dead".
+	DeadLetter string
+}
+
+// A Queue is a durable FIFO queue of messages, backed by the collection named
+// after it, where every message is a member.  Their keys follow the order in
+// which they were enqueued, and like any member, they survive restarts.
+//
+// A message is delivered at least once : a dequeued message is hidden from
+// other consumers until it is acked, nacked, or until its visibility timeout
+// expires, after which it's delivered again.  It's safe for concurrent use.
+type Queue struct {
+	s     Store
+	name  string
+	opts  QueueOptions
+	coll  *Collection
+	state *queueState
+}
+
+// A Message is a message of a queue.  ID is its member in the collection of
+// the queue, and Attempts the number of times it was dequeued, this time
+// included.  Only the delivery that returned it may ack or nack it.
+type Message struct {
+	ID       string
+	Body     []byte
+	Attempts int
+	// receipt is when the message is visible again, in nanoseconds since
+	// the epoch, as this delivery made it.
+	receipt int64
+}
+
+// Queue returns the queue named `name`, with the default `QueueOptions`.
+func (s Store) Queue(name string) *Queue {
+	return s.QueueWith(name, QueueOptions{})
+}
+
+// QueueWith returns the queue named `name`, configured with `opts`.  Should
+// `name` not be a valid collection name, every call to the queue that can
+// fail returns why.
+func (s Store) QueueWith(name string, opts QueueOptions) *Queue {
+	if opts.Visibility <= 0 {
+		opts.Visibility = DefaultVisibility
+	}
+	if opts.DeadLetter == "" {
+		opts.DeadLetter = name + ".dead"
+	}
+	q := &Queue{
+		s:    s,
+		name: name,
+		opts: opts,
+		coll: s.Collection(name),
+	}
+	if q.coll.err == nil {
+		q.state = s.coll.queueState(name)
+	}
+	return q
+}
+
+// Enqueue adds a message holding `body` at the tail of the queue, and returns
+// its ID.  It wakes the consumers waiting for one.
+func (q *Queue) Enqueue(body []byte) (string, error) {
+	return q.enqueue(body, 0)
+}
+
+// Dequeue takes the message at the head of the queue that is visible, and
+// hides it for the visibility timeout of the queue.  If there is none, it
+// waits at most `wait` for one to be enqueued or to become visible again, and
+// ok will be false if none did.
+//
+// ATTENTION : do not modify the body of the messages that are returned to
+// you.
+func (q *Queue) Dequeue(wait time.Duration) (Message, bool, error) {
+	if q.coll.err != nil {
+		return Message{}, false, q.coll.err
+	}
+	deadline := time.Now().Add(wait)
+	for {
+		// Taken before looking, so that a message enqueued meanwhile
+		// wakes us
+		wake := q.state.waiting()
+		msg, ok, next, err := q.dequeue()
+		if err != nil || ok {
+			return msg, ok, err
+		}
+
+		now := time.Now()
+		if !now.Before(deadline) {
+			return Message{}, false, nil
+		}
+		sleep := deadline.Sub(now)
+		if !next.IsZero() && next.Sub(now) < sleep {
+			sleep = next.Sub(now)
+		}
+		timer := time.NewTimer(sleep)
+		select {
+		case <-wake:
+		case <-timer.C:
+		}
+		timer.Stop()
+	}
+}
+
+// Ack removes message `msg`, once it was handled.  It fails if the message was
+// delivered again since `Dequeue` returned it, because its visibility timeout
+// expired.
+func (q *Queue) Ack(msg Message) error {
+	if err := q.coll.checkMember(msg.ID); err != nil {
+		return err
+	}
+	fullKey := joinKeys(q.name, msg.ID)
+	if !q.s.coll.deleteIf(q.name, msg.ID, func(value []byte, seq uint64) bool {
+		return isDelivery(fullKey, value, msg)
+	}) {
+		return errorNotDelivered(fullKey)
+	}
+	if seq, ok := parseQueueID(msg.ID); ok {
+		q.state.remove(seq)
+	}
+	return nil
+}
+
+// Nack makes message `msg` visible again at once, so that it's dequeued again,
+// and wakes the consumers waiting for one.  Like `Ack`, it fails if the
+// message was delivered again since.
+func (q *Queue) Nack(msg Message) error {
+	if err := q.coll.checkMember(msg.ID); err != nil {
+		return err
+	}
+	fullKey := joinKeys(q.name, msg.ID)
+	done, err := q.s.coll.updateExisting(q.name, msg.ID, func(old []byte) ([]byte, error) {
+		if !isDelivery(fullKey, old, msg) {
+			return nil, errorNotDelivered(fullKey)
+		}
+		attempts, _, body, _ := decodeMessage(fullKey, old)
+		return encodeMessage(attempts, time.Time{}, body), nil
+	})
+	if err != nil {
+		return err
+	} else if !done {
+		return errorNotDelivered(fullKey)
+	}
+	if seq, ok := parseQueueID(msg.ID); ok {
+		q.state.show(seq)
+	}
+	q.state.wake()
+	return nil
+}
+
+// Len returns the number of messages of the queue, those hidden included.
+func (q *Queue) Len() int {
+	return q.coll.Len()
+}
+
+func (q *Queue) enqueue(body []byte, attempts uint32) (string, error) {
+	if q.coll.err != nil {
+		return "", q.coll.err
+	}
+	value := encodeMessage(attempts, time.Time{}, body)
+	for {
+		seq := q.state.next(q)
+		id := queueID(seq)
+		ok, err := q.s.coll.putNew(q.name, id, value)
+		if err != nil {
+			return "", err
+		}
+		if ok {
+			q.state.add(seq, time.Time{})
+			q.state.wake()
+			return id, nil
+		}
+		// Something else was put under this key
+	}
+}
+
+// dequeue takes the first visible message, if any, and otherwise returns when
+// the next hidden one becomes visible again, if any.
+func (q *Queue) dequeue() (Message, bool, time.Time, error) {
+	for {
+		now := time.Now()
+		visibleAt := now.Add(q.opts.Visibility)
+		seq, next, ok := q.state.take(q, now, visibleAt)
+		if !ok {
+			return Message{}, false, next, nil
+		}
+
+		id := queueID(seq)
+		fullKey := joinKeys(q.name, id)
+		var msg Message
+		var dead bool
+		var hiddenUntil time.Time
+		done, err := q.s.coll.updateExisting(q.name, id, func(old []byte) ([]byte, error) {
+			attempts, oldVisibleAt, body, err := decodeMessage(fullKey, old)
+			if err != nil {
+				return nil, err
+			}
+			if oldVisibleAt.After(now) {
+				// Hidden by another store that shares the
+				// directory, or put there by hand
+				hiddenUntil = oldVisibleAt
+				return nil, errSkipMessage
+			}
+			if q.opts.MaxAttempts > 0 && int(attempts) >= q.opts.MaxAttempts {
+				dead = true
+			} else {
+				attempts++
+			}
+			msg = Message{
+				ID:       id,
+				Body:     body,
+				Attempts: int(attempts),
+				receipt:  visibleAt.UnixNano(),
+			}
+			return encodeMessage(attempts, visibleAt, body), nil
+		})
+		if err == errSkipMessage {
+			q.state.add(seq, hiddenUntil)
+			continue
+		} else if _, notMessage := err.(ValueError); notMessage || (err == nil && !done) {
+			// Acked meanwhile, or not a message, put there by
+			// something else
+			q.state.remove(seq)
+			continue
+		} else if err != nil {
+			q.state.show(seq)
+			return Message{}, false, time.Time{}, err
+		}
+		if dead {
+			if err := q.deadLetter(msg); err != nil {
+				return Message{}, false, time.Time{}, err
+			}
+			continue
+		}
+		return msg, true, time.Time{}, nil
+	}
+}
+
+// deadLetter moves message `msg`, which was dequeued too many times, to the
+// dead letter queue.  It's hidden meanwhile, so that should it crash in
+// between, it's moved again, once visible.
+func (q *Queue) deadLetter(msg Message) error {
+	dead := q.s.QueueWith(q.opts.DeadLetter, QueueOptions{})
+	if _, err := dead.enqueue(msg.Body, uint32(msg.Attempts)); err != nil {
+		return err
+	}
+	err := q.Ack(msg)
+	if _, stale := err.(KeyError); stale {
+		// It was acked, or delivered again, meanwhile
+		return nil
+	}
+	return err
+}
+
+// errSkipMessage tells `update` to leave a message as it is.
+var errSkipMessage = errors.New("message is not visible")
+
+// isDelivery tells if message `value` is still hidden by the delivery that
+// returned `msg`.
+func isDelivery(fullKey string, value []byte, msg Message) bool {
+	_, visibleAt, _, err := decodeMessage(fullKey, value)
+	return err == nil && !visibleAt.IsZero() && visibleAt.UnixNano() == msg.receipt
+}
+
+// queueIDLength is the length of the members of the messages of a queue.
+const queueIDLength = 20
+
+// queueID returns the member of the message enqueued `seq`th, which sorts
+// with the others in the order they were enqueued.
+func queueID(seq uint64) string {
+	return fmt.Sprintf("%0*d", queueIDLength, seq)
+}
+
+// parseQueueID returns the sequence of the message of member `id`, if it's
+// one.
+func parseQueueID(id string) (uint64, bool) {
+	if len(id) != queueIDLength {
+		return 0, false
+	}
+	seq, err := strconv.ParseUint(id, 10, 64)
+	return seq, err == nil
+}
+
+func encodeMessage(attempts uint32, visibleAt time.Time, body []byte) []byte {
+	value := make([]byte, queueHeaderSize+len(body))
+	binary.BigEndian.PutUint32(value, attempts)
+	var nanos int64
+	if !visibleAt.IsZero() {
+		nanos = visibleAt.UnixNano()
+	}
+	binary.BigEndian.PutUint64(value[4:], uint64(nanos))
+	copy(value[queueHeaderSize:], body)
+	return value
+}
+
+func decodeMessage(fullKey string, value []byte) (uint32, time.Time, []byte, error) {
+	if len(value) < queueHeaderSize {
+		return 0, time.Time{}, nil, errorWrongKind(fullKey, "queue message")
+	}
+	attempts := binary.BigEndian.Uint32(value)
+	var visibleAt time.Time
+	if nanos := int64(binary.BigEndian.Uint64(value[4:])); nanos != 0 {
+		visibleAt = time.Unix(0, nanos)
+	}
+	return attempts, visibleAt, value[queueHeaderSize:], nil
+}
+
+// A queueState is what the queues of the same name share within a store : the
+// sequence of their messages, those pending, and the consumers waiting for
+// one.  It's read from the collection of the queue the first time it's used,
+// and then kept along with it.
+type queueState struct {
+	sync.Mutex
+	loaded bool
+	seq    uint64
+	// pending are the sequences of the messages, in order, and hidden
+	// when those that were dequeued are visible again.
+	pending []uint64
+	hidden  map[uint64]time.Time
+	wakeUp  chan struct{}
+}
+
+// queueState returns the state of queue `name`, creating it if needed.
+func (c *collections) queueState(name string) *queueState {
+	c.queueLock.Lock()
+	state, ok := c.queues[name]
+	if !ok {
+		state = &queueState{
+			hidden: make(map[uint64]time.Time),
+			wakeUp: make(chan struct{}),
+		}
+		c.queues[name] = state
+	}
+	c.queueLock.Unlock()
+	return state
+}
+
+// load reads the messages of queue `q` the first time.  The state must be
+// locked.
+func (state *queueState) load(q *Queue) {
+	if state.loaded {
+		return
+	}
+	state.loaded = true
+	for _, id := range q.coll.Keys() {
+		seq, ok := parseQueueID(id)
+		if !ok {
+			continue
+		}
+		if seq > state.seq {
+			state.seq = seq
+		}
+		value, ok, _ := q.coll.Get(id)
+		if !ok {
+			continue
+		}
+		_, visibleAt, _, err := decodeMessage(joinKeys(q.name, id), value)
+		if err != nil {
+			continue
+		}
+		state.pending = append(state.pending, seq)
+		if !visibleAt.IsZero() {
+			state.hidden[seq] = visibleAt
+		}
+	}
+	sort.Slice(state.pending, func(i, j int) bool {
+		return state.pending[i] < state.pending[j]
+	})
+}
+
+// next returns the sequence of the next message of queue `q`.
+func (state *queueState) next(q *Queue) uint64 {
+	state.Lock()
+	defer state.Unlock()
+	state.load(q)
+	state.seq++
+	return state.seq
+}
+
+// take returns the first pending message of queue `q` that is visible at
+// `now`, and hides it until `visibleAt`.  Should there be none, it returns
+// when the next one is visible again, if any.
+func (state *queueState) take(q *Queue, now, visibleAt time.Time) (uint64, time.Time, bool) {
+	state.Lock()
+	defer state.Unlock()
+	state.load(q)
+	var next time.Time
+	for _, seq := range state.pending {
+		if hiddenUntil, ok := state.hidden[seq]; ok && hiddenUntil.After(now) {
+			if next.IsZero() || hiddenUntil.Before(next) {
+				next = hiddenUntil
+			}
+			continue
+		}
+		state.hidden[seq] = visibleAt
+		return seq, time.Time{}, true
+	}
+	return 0, next, false
+}
+
+// add makes message `seq` pending, hidden until `hiddenUntil` unless it's
+// zero.
+func (state *queueState) add(seq uint64, hiddenUntil time.Time) {
+	state.Lock()
+	defer state.Unlock()
+	if hiddenUntil.IsZero() {
+		delete(state.hidden, seq)
+	} else {
+		state.hidden[seq] = hiddenUntil
+	}
+	i := sort.Search(len(state.pending), func(i int) bool {
+		return state.pending[i] >= seq
+	})
+	if i < len(state.pending) && state.pending[i] == seq {
+		return
+	}
+	// Messages are mostly enqueued in order, at the tail
+	state.pending = append(state.pending, 0)
+	copy(state.pending[i+1:], state.pending[i:])
+	state.pending[i] = seq
+}
+
+// show makes message `seq` visible again.
+func (state *queueState) show(seq uint64) {
+	state.Lock()
+	delete(state.hidden, seq)
+	state.Unlock()
+}
+
+// remove forgets message `seq`, which is gone.
+func (state *queueState) remove(seq uint64) {
+	state.Lock()
+	defer state.Unlock()
+	delete(state.hidden, seq)
+	i := sort.Search(len(state.pending), func(i int) bool {
+		return state.pending[i] >= seq
+	})
+	if i < len(state.pending) && state.pending[i] == seq {
+		state.pending = append(state.pending[:i], state.pending[i+1:]...)
+	}
+}
+
+// waiting returns a channel closed when a message is enqueued or nacked.
+func (state *queueState) waiting() <-chan struct{} {
+	state.Lock()
+	wakeUp := state.wakeUp
+	state.Unlock()
+	return wakeUp
+}
+
+// wake wakes the consumers waiting for a message.
+func (state *queueState) wake() {
+	state.Lock()
+	close(state.wakeUp)
+	state.wakeUp = make(chan struct{})
+	state.Unlock()
+}
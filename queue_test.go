@@ -0,0 +1,169 @@
+package dskvs
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestQueue(t *testing.T) {
+	store := setUp(t)
+
+	jobs := store.Queue("jobs")
+	for _, body := range []string{"first", "second", "third"} {
+		if _, err := jobs.Enqueue([]byte(body)); err != nil {
+			t.Fatalf("Error enqueuing, %v", err)
+		}
+	}
+	msg, ok, err := jobs.Dequeue(0)
+	if !ok || err != nil || string(msg.Body) != "first" || msg.Attempts != 1 {
+		t.Fatalf("Expected first message, was %+v, %v, %v", msg, ok, err)
+	}
+	jobs.Ack(msg)
+	msg, _, _ = jobs.Dequeue(0)
+	jobs.Nack(msg)
+	if msg, _, _ = jobs.Dequeue(0); string(msg.Body) != "second" || msg.Attempts != 2 {
+		t.Errorf("Expected second message again, was %+v", msg)
+	}
+	if err := store.Close(); err != nil {
+		t.Fatalf("Error closing store, %v", err)
+	}
+
+	// Messages survive restarts, and keep their order
+	store = setUp(t)
+	defer func() { tearDown(store, t) }()
+	jobs = store.Queue("jobs")
+	jobs.Enqueue([]byte("fourth"))
+	if jobs.Len() != 3 {
+		t.Errorf("Expected 3 messages, had %d", jobs.Len())
+	}
+	if msg, _, _ = jobs.Dequeue(0); string(msg.Body) != "third" {
+		t.Errorf("Expected third message while second is hidden, was %+v", msg)
+	}
+	if msg, _, _ = jobs.Dequeue(0); string(msg.Body) != "fourth" {
+		t.Errorf("Expected fourth message, was %+v", msg)
+	}
+	if _, ok, _ := jobs.Dequeue(0); ok {
+		t.Errorf("Expected every message to be hidden")
+	}
+}
+
+func TestQueueVisibilityAndDeadLetter(t *testing.T) {
+	store := setUp(t)
+	defer tearDown(store, t)
+
+	jobs := store.QueueWith("jobs", QueueOptions{
+		Visibility:  20 * time.Millisecond,
+		MaxAttempts: 2,
+	})
+	jobs.Enqueue([]byte("flaky"))
+	for attempt := 1; attempt <= 2; attempt++ {
+		msg, ok, _ := jobs.Dequeue(time.Second)
+		if !ok || msg.Attempts != attempt {
+			t.Fatalf("Expected attempt %d, was %+v, %v", attempt, msg, ok)
+		}
+	}
+	// Not acked twice, so it goes to the dead letter queue
+	if _, ok, _ := jobs.Dequeue(50 * time.Millisecond); ok {
+		t.Errorf("Expected message to be dead-lettered")
+	}
+	dead, ok, _ := store.Queue("jobs.dead").Dequeue(0)
+	if !ok || string(dead.Body) != "flaky" {
+		t.Errorf("Expected message in dead letter queue, was %+v, %v", dead, ok)
+	}
+}
+
+func TestAckAfterRedelivery(t *testing.T) {
+	store := setUp(t)
+	defer tearDown(store, t)
+
+	jobs := store.QueueWith("jobs", QueueOptions{Visibility: 20 * time.Millisecond})
+	jobs.Enqueue([]byte("slow"))
+	late, _, _ := jobs.Dequeue(0)
+	current, ok, _ := jobs.Dequeue(time.Second)
+	if !ok || current.Attempts != 2 {
+		t.Fatalf("Expected message to be delivered again, was %+v, %v", current, ok)
+	}
+
+	// The consumer whose delivery expired can't ack the other's
+	err := jobs.Ack(late)
+	if _, isRightType := err.(KeyError); !isRightType {
+		t.Errorf("Should have returned an error of type KeyError"+
+			", error was %v",
+			err)
+	}
+	if err := jobs.Nack(late); err == nil {
+		t.Errorf("Should have refused to nack an expired delivery")
+	}
+	if jobs.Len() != 1 {
+		t.Errorf("Expected message to be kept, had %d", jobs.Len())
+	}
+	if err := jobs.Ack(current); err != nil {
+		t.Errorf("Error acking current delivery, %v", err)
+	}
+	if jobs.Len() != 0 {
+		t.Errorf("Expected message to be acked, had %d", jobs.Len())
+	}
+}
+
+func TestDequeueWaitsForEnqueue(t *testing.T) {
+	store := setUp(t)
+	defer tearDown(store, t)
+
+	jobs := store.Queue("jobs")
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		store.Queue("jobs").Enqueue([]byte("late"))
+	}()
+	start := time.Now()
+	msg, ok, err := jobs.Dequeue(5 * time.Second)
+	if !ok || err != nil || string(msg.Body) != "late" {
+		t.Errorf("Expected late message, was %+v, %v, %v", msg, ok, err)
+	}
+	if time.Since(start) > 2*time.Second {
+		t.Errorf("Expected to be woken by the enqueue")
+	}
+}
+
+func TestConcurrentConsumersLeaveNoMember(t *testing.T) {
+	store := setUp(t)
+	defer tearDown(store, t)
+
+	jobs := store.QueueWith("jobs", QueueOptions{Visibility: time.Millisecond})
+	const count = 300
+	for i := 0; i < count; i++ {
+		jobs.Enqueue([]byte("job"))
+	}
+	var wg sync.WaitGroup
+	for i := 0; i < 16; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				msg, ok, err := jobs.Dequeue(10 * time.Millisecond)
+				if err != nil {
+					t.Errorf("Error dequeuing, %v", err)
+					return
+				}
+				if !ok {
+					return
+				}
+				jobs.Ack(msg)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if jobs.Len() != 0 {
+		t.Errorf("Expected every message to be acked, had %d", jobs.Len())
+	}
+	report, err := store.Verify(context.Background())
+	if err != nil {
+		t.Fatalf("Error verifying store, %v", err)
+	}
+	if len(report.Problems) != 0 {
+		t.Errorf("Expected no problem, got %d like %v",
+			len(report.Problems), report.Problems[0])
+	}
+}
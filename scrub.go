@@ -0,0 +1,117 @@
+package dskvs
+
+import (
+	"log"
+	"sync/atomic"
+	"time"
+)
+
+// scrubInterval is the least time between two passes of a scrubber over a
+// store.  It prevents small stores from being scrubbed in a tight loop.
+var scrubInterval = time.Second
+
+// ScrubStats counts what the scrubber of a store has done since it was opened.
+type ScrubStats struct {
+	// Passes is how many times every page of the store was scrubbed.
+	Passes int64
+	// FilesScrubbed is how many page files were read and verified.
+	FilesScrubbed int64
+	// BytesScrubbed is how many bytes those files held.
+	BytesScrubbed int64
+	// CorruptionsFound is how many page files didn't hold the value of
+	// their page, or were missing.  Each of them is rewritten from memory.
+	CorruptionsFound int64
+}
+
+// A scrubber slowly re-reads the page files of a store, to find bit-rot long
+// before the store is opened again.
+type scrubber struct {
+	rate  int64
+	stop  chan bool
+	done  chan bool
+	stats ScrubStats
+}
+
+func newScrubber(rate int64) *scrubber {
+	return &scrubber{
+		rate: rate,
+		stop: make(chan bool),
+		done: make(chan bool),
+	}
+}
+
+// run scrubs `s` until told to stop.
+func (sc *scrubber) run(s *Store) {
+	defer close(sc.done)
+	for {
+		started := time.Now()
+		if !sc.pass(s) {
+			return
+		}
+		atomic.AddInt64(&sc.stats.Passes, 1)
+
+		select {
+		case <-sc.stop:
+			return
+		case <-time.After(scrubInterval - time.Since(started)):
+		}
+	}
+}
+
+// pass scrubs every page of `s` once.  It returns false if the scrubber was
+// told to stop meanwhile.
+func (sc *scrubber) pass(s *Store) bool {
+	for _, m := range s.coll.snapshot() {
+		for _, aPage := range m.pages() {
+			size := sc.scrubPage(aPage)
+
+			// Sleep long enough to hold to the rate
+			pause := time.Duration(size) * time.Second / time.Duration(sc.rate)
+			select {
+			case <-sc.stop:
+				return false
+			case <-time.After(pause):
+			}
+		}
+	}
+	return true
+}
+
+// scrubPage verifies the file of a page, and has the janitor rewrite it if it
+// is corrupted.  It returns the size of the file.
+func (sc *scrubber) scrubPage(aPage *page) int64 {
+	aPage.RLock()
+	size := int64(fileHeaderSize + len(aPage.key) + len(aPage.value))
+	filename := generateFilename(aPage)
+	aPage.RUnlock()
+
+	problem, ok := checkPageFile(aPage, filename)
+
+	atomic.AddInt64(&sc.stats.FilesScrubbed, 1)
+	atomic.AddInt64(&sc.stats.BytesScrubbed, size)
+	if !ok {
+		log.Printf("Scrubber found %v at <%s> : %v, rewriting it from memory",
+			problem.Kind, filename, problem.Reason)
+		atomic.AddInt64(&sc.stats.CorruptionsFound, 1)
+		aPage.touch()
+	}
+	return size
+}
+
+func (sc *scrubber) snapshot() ScrubStats {
+	return ScrubStats{
+		Passes:           atomic.LoadInt64(&sc.stats.Passes),
+		FilesScrubbed:    atomic.LoadInt64(&sc.stats.FilesScrubbed),
+		BytesScrubbed:    atomic.LoadInt64(&sc.stats.BytesScrubbed),
+		CorruptionsFound: atomic.LoadInt64(&sc.stats.CorruptionsFound),
+	}
+}
+
+// ScrubStats returns the counters of the scrubber of this store.  They are all
+// zero if the store was not opened with `Options.ScrubRate`.
+func (s Store) ScrubStats() ScrubStats {
+	if s.scrub == nil {
+		return ScrubStats{}
+	}
+	return s.scrub.snapshot()
+}
@@ -0,0 +1,65 @@
+package dskvs
+
+import (
+	"bytes"
+	"io/ioutil"
+	"testing"
+	"time"
+)
+
+func TestScrubberRewritesCorruptedFiles(t *testing.T) {
+	defer func(interval time.Duration) { scrubInterval = interval }(scrubInterval)
+	scrubInterval = 10 * time.Millisecond
+
+	key := "artist/daft_punk"
+	expected := []byte("One more time")
+
+	store := setUp(t)
+	if err := store.Put(key, expected); err != nil {
+		t.Fatalf("Error putting data in, %v", err)
+	}
+	if err := store.Close(); err != nil {
+		t.Fatalf("Error closing store, %v", err)
+	}
+
+	store, _, err := OpenWith("./db", Options{ScrubRate: 1 << 30})
+	if err != nil {
+		t.Fatalf("Error opening store, %v", err)
+	}
+
+	m, _ := store.coll.member("artist")
+	filename := generateFilename(m.entries["daft_punk"])
+	err = ioutil.WriteFile(filename, []byte{0xDE, 0xAD, 0xBE, 0xEF}, FILE_PERM)
+	if err != nil {
+		t.Fatalf("Couldn't corrupt file <%s> : %v", filename, err)
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for store.ScrubStats().CorruptionsFound == 0 {
+		if time.Now().After(deadline) {
+			t.Fatalf("Scrubber didn't find the corruption, stats=%+v",
+				store.ScrubStats())
+		}
+		time.Sleep(scrubInterval)
+	}
+
+	stats := store.ScrubStats()
+	if stats.FilesScrubbed == 0 || stats.BytesScrubbed == 0 {
+		t.Errorf("Expected files and bytes to be counted, stats=%+v", stats)
+	}
+
+	if err := store.Close(); err != nil {
+		t.Fatalf("Error closing store, %v", err)
+	}
+
+	aPage, err := readFromFile(filename)
+	if err != nil {
+		t.Fatalf("File should have been rewritten, %v", err)
+	}
+	if !bytes.Equal(aPage.value, expected) {
+		t.Errorf("Expected <%s> but was <%s>", expected, aPage.value)
+	}
+
+	store = setUp(t)
+	tearDown(store, t)
+}
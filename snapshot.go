@@ -0,0 +1,147 @@
+package dskvs
+
+import (
+	"sort"
+	"sync/atomic"
+)
+
+// A Snapshot is a read-only view of a store, frozen after the last change made
+// when it was taken.  Taking one doesn't copy anything nor hold writers back :
+// instead, while it lives, pages keep the values that it can see when they
+// change.  Release it when done, so that they stop doing so.
+type Snapshot struct {
+	coll     *collections
+	seq      uint64
+	released int32
+}
+
+// Snapshot takes a snapshot of the store as it is now.
+func (s Store) Snapshot() *Snapshot {
+	return &Snapshot{
+		coll: s.coll,
+		seq:  s.coll.changes.snapshot(),
+	}
+}
+
+// Release lets the store forget the values that only this snapshot could see.
+// The snapshot must not be used afterward.
+func (sn *Snapshot) Release() {
+	if atomic.CompareAndSwapInt32(&sn.released, 0, 1) {
+		sn.coll.changes.release(sn.seq)
+	}
+}
+
+// Get returns the value that `fullKey` had when the snapshot was taken, like
+// `Store.Get` would have then.
+func (sn *Snapshot) Get(fullKey string) ([]byte, bool, error) {
+
+	if err := sn.coll.checkKey(fullKey); err != nil {
+		return nil, false, err
+	}
+
+	if sn.coll.isCollectionKey(fullKey) {
+		return nil, false, errorGetIsColl(fullKey)
+	}
+
+	coll, key := sn.coll.splitKeys(fullKey)
+
+	if m, ok := sn.coll.member(coll); ok {
+		m.RLock()
+		aPage, ok := m.entries[key]
+		m.RUnlock()
+		if ok {
+			if value, ok := pageAt(aPage, sn.seq); ok {
+				return value, true, nil
+			}
+		}
+	}
+
+	// The member was deleted since, or deleted and put again
+	for _, aPage := range sn.coll.changes.buried(coll) {
+		if aPage.key != key {
+			continue
+		}
+		if value, ok := pageAt(aPage, sn.seq); ok {
+			return value, true, nil
+		}
+	}
+	return nil, false, nil
+}
+
+// GetAll returns the values that the members of collection `coll` had when
+// the snapshot was taken, sorted by key.
+func (sn *Snapshot) GetAll(coll string) ([][]byte, error) {
+
+	if err := sn.coll.checkKey(coll); err != nil {
+		return nil, err
+	}
+
+	if !sn.coll.isCollectionKey(coll) {
+		return nil, errorGetAllIsNotColl(coll)
+	}
+
+	var values [][]byte
+	sn.iterate(sn.coll.collName(coll), func(_ string, value []byte) bool {
+		values = append(values, value)
+		return true
+	})
+	return values, nil
+}
+
+// Iterate calls `fn` with the full key and value of every member of the store
+// when the snapshot was taken, sorted by full key, until `fn` returns false.
+//
+// ATTENTION : do not modify the values given to `fn`.
+func (sn *Snapshot) Iterate(fn func(fullKey string, value []byte) bool) {
+	sn.iterate("", fn)
+}
+
+// iterate is `Iterate` restricted to collection `coll`, if not empty.
+func (sn *Snapshot) iterate(coll string, fn func(fullKey string, value []byte) bool) {
+	// The collection of live pages is read from their member, as it
+	// changes when it is renamed
+	var pages []*page
+	var colls []string
+	for name, m := range sn.coll.snapshot() {
+		if coll == "" || name == coll {
+			for _, aPage := range m.pages() {
+				pages = append(pages, aPage)
+				colls = append(colls, name)
+			}
+		}
+	}
+	// Pages deleted since come last, as the live page of a key wins
+	for _, aPage := range sn.coll.changes.buried(coll) {
+		pages = append(pages, aPage)
+		colls = append(colls, aPage.coll)
+	}
+
+	values := make(map[string][]byte)
+	for i, aPage := range pages {
+		fullKey := joinKeys(colls[i], aPage.key)
+		if _, seen := values[fullKey]; seen {
+			continue
+		}
+		if value, ok := pageAt(aPage, sn.seq); ok {
+			values[fullKey] = value
+		}
+	}
+
+	fullKeys := make([]string, 0, len(values))
+	for fullKey := range values {
+		fullKeys = append(fullKeys, fullKey)
+	}
+	sort.Strings(fullKeys)
+	for _, fullKey := range fullKeys {
+		if !fn(fullKey, values[fullKey]) {
+			return
+		}
+	}
+}
+
+func pageAt(aPage *page, seq uint64) ([]byte, bool) {
+	aPage.RLock()
+	value, ok := aPage.valueAt(seq)
+	aPage.RUnlock()
+	return value, ok
+}
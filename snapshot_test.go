@@ -0,0 +1,92 @@
+package dskvs
+
+import (
+	"strconv"
+	"sync"
+	"testing"
+)
+
+func TestSnapshotIsFrozen(t *testing.T) {
+	store := setUp(t)
+	defer tearDown(store, t)
+	fillStore(store, backupData, t)
+
+	snap := store.Snapshot()
+	defer snap.Release()
+
+	store.Put("artist/daft_punk", []byte("Homework"))
+	store.Put("artist/air", []byte("Moon Safari"))
+	store.Delete("artist/justice")
+	store.DeleteAll("album")
+	store.Put("album/homework", []byte("Again"))
+
+	for key, expected := range backupData {
+		actual, ok, err := snap.Get(key)
+		if err != nil || !ok {
+			t.Fatalf("Error getting <%s> from snapshot, ok=%v, %v", key, ok, err)
+		}
+		if string(actual) != string(expected) {
+			t.Errorf("Expected <%s> but was <%s>", expected, actual)
+		}
+	}
+	if _, ok, _ := snap.Get("artist/air"); ok {
+		t.Errorf("Snapshot sees a member put after it was taken")
+	}
+
+	values, err := snap.GetAll("artist")
+	if err != nil || len(values) != 2 {
+		t.Errorf("Expected 2 artists in snapshot, was %d, %v", len(values), err)
+	}
+
+	var keys []string
+	snap.Iterate(func(fullKey string, value []byte) bool {
+		keys = append(keys, fullKey)
+		return true
+	})
+	if len(keys) != 3 || keys[0] != "album/homework" {
+		t.Errorf("Expected the 3 members sorted, was %v", keys)
+	}
+
+	// The store itself moved on
+	if val, _, _ := store.Get("album/homework"); string(val) != "Again" {
+		t.Errorf("Expected store to hold the new value, was <%s>", val)
+	}
+}
+
+func TestSnapshotDoesntSeeHalfAppliedWrites(t *testing.T) {
+	store := setUp(t)
+	defer tearDown(store, t)
+
+	const keys = 50
+	const rounds = 100
+
+	// Every round writes the keys in order, so a consistent view never sees
+	// a key of a later round than a key before it
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for round := 0; round < rounds; round++ {
+			for i := 0; i < keys; i++ {
+				key := "round/" + strconv.Itoa(1000+i)
+				store.Put(key, []byte(strconv.Itoa(round)))
+			}
+		}
+	}()
+
+	for n := 0; n < 50; n++ {
+		snap := store.Snapshot()
+		last := rounds
+		snap.Iterate(func(fullKey string, value []byte) bool {
+			round, _ := strconv.Atoi(string(value))
+			if round > last {
+				t.Errorf("Snapshot sees %s at round %d after round %d",
+					fullKey, round, last)
+			}
+			last = round
+			return true
+		})
+		snap.Release()
+	}
+	wg.Wait()
+}
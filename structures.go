@@ -0,0 +1,281 @@
+package dskvs
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"sort"
+)
+
+// The kinds of structured values.  They are held as a NUL byte and their kind,
+// then the number of their items and every item, all as uvarints followed by
+// bytes.  Sets hold their members sorted, and hashes their fields sorted,
+// each followed by its value.
+const (
+	listKind byte = 'L'
+	setKind  byte = 'S'
+	hashKind byte = 'H'
+)
+
+var kindNames = map[byte]string{
+	listKind: "list",
+	setKind:  "set",
+	hashKind: "hash",
+}
+
+// LPush adds `values` at the head of the list held by member `fullKey`, one
+// after the other, so that the last one comes first, and returns the length
+// of the list.  A member that holds nothing is an empty list.  Like every
+// change of a list, set or hash, it is made as one change.
+func (s Store) LPush(fullKey string, values ...[]byte) (int, error) {
+	var length int
+	err := s.updateStructure(fullKey, listKind, func(items [][]byte) [][]byte {
+		pushed := make([][]byte, 0, len(values)+len(items))
+		for i := len(values) - 1; i >= 0; i-- {
+			pushed = append(pushed, values[i])
+		}
+		pushed = append(pushed, items...)
+		length = len(pushed)
+		return pushed
+	})
+	return length, err
+}
+
+// RPop removes the value at the tail of the list held by member `fullKey`,
+// and returns it.  If the list is empty, ok will be false, and nothing
+// changes : a member that holds nothing is not created.  An empty list is
+// kept until the member is deleted.
+func (s Store) RPop(fullKey string) ([]byte, bool, error) {
+	var popped []byte
+	var ok bool
+	err := s.changeStructure(fullKey, listKind, false, func(items [][]byte) ([][]byte, bool) {
+		if len(items) == 0 {
+			return items, false
+		}
+		popped, ok = items[len(items)-1], true
+		return items[:len(items)-1], true
+	})
+	return popped, ok, err
+}
+
+// LRange returns the values of the list held by member `fullKey` from index
+// `start` to index `stop`, included.  Negative indexes count from the tail, -1
+// being the last value.
+//
+// ATTENTION : do not modify the value of the slices that are returned to
+// you.
+func (s Store) LRange(fullKey string, start, stop int) ([][]byte, error) {
+	items, err := s.readStructure(fullKey, listKind)
+	if err != nil {
+		return nil, err
+	}
+	n := len(items)
+	if start < 0 {
+		start += n
+	}
+	if stop < 0 {
+		stop += n
+	}
+	if start < 0 {
+		start = 0
+	}
+	if stop >= n {
+		stop = n - 1
+	}
+	if start > stop {
+		return nil, nil
+	}
+	return items[start : stop+1], nil
+}
+
+// SAdd adds `members` to the set held by member `fullKey`, and returns how
+// many it didn't hold yet.  A member that holds nothing is an empty set.
+func (s Store) SAdd(fullKey string, members ...[]byte) (int, error) {
+	var added int
+	err := s.updateStructure(fullKey, setKind, func(items [][]byte) [][]byte {
+		added = 0
+		for _, member := range members {
+			i, found := searchItems(items, member, 1)
+			if found {
+				continue
+			}
+			items = append(items, nil)
+			copy(items[i+1:], items[i:])
+			items[i] = member
+			added++
+		}
+		return items
+	})
+	return added, err
+}
+
+// SMembers returns the members of the set held by member `fullKey`, sorted.
+//
+// ATTENTION : do not modify the value of the slices that are returned to
+// you.
+func (s Store) SMembers(fullKey string) ([][]byte, error) {
+	return s.readStructure(fullKey, setKind)
+}
+
+// SIsMember tells if the set held by member `fullKey` holds `member`.
+func (s Store) SIsMember(fullKey string, member []byte) (bool, error) {
+	items, err := s.readStructure(fullKey, setKind)
+	if err != nil {
+		return false, err
+	}
+	_, found := searchItems(items, member, 1)
+	return found, nil
+}
+
+// HSet sets field `field` of the hash held by member `fullKey` to `value`, and
+// tells if the hash didn't have the field yet.  A member that holds nothing is
+// an empty hash.
+func (s Store) HSet(fullKey, field string, value []byte) (bool, error) {
+	var added bool
+	err := s.updateStructure(fullKey, hashKind, func(items [][]byte) [][]byte {
+		i, found := searchItems(items, []byte(field), 2)
+		added = !found
+		if found {
+			items[i+1] = value
+			return items
+		}
+		items = append(items, nil, nil)
+		copy(items[i+2:], items[i:])
+		items[i], items[i+1] = []byte(field), value
+		return items
+	})
+	return added, err
+}
+
+// HGet returns field `field` of the hash held by member `fullKey`.  If the
+// hash doesn't have the field, ok will be false.
+//
+// ATTENTION : do not modify the value of the slices that are returned to
+// you.
+func (s Store) HGet(fullKey, field string) ([]byte, bool, error) {
+	items, err := s.readStructure(fullKey, hashKind)
+	if err != nil {
+		return nil, false, err
+	}
+	i, found := searchItems(items, []byte(field), 2)
+	if !found {
+		return nil, false, nil
+	}
+	return items[i+1], true, nil
+}
+
+// updateStructure replaces the items of the structured value of kind `kind`
+// held by member `fullKey` with what `fn` makes of them, as one change.  `fn`
+// may modify the slice it's given, but not the items.
+func (s Store) updateStructure(fullKey string, kind byte, fn func(items [][]byte) [][]byte) error {
+	return s.changeStructure(fullKey, kind, true, func(items [][]byte) ([][]byte, bool) {
+		return fn(items), true
+	})
+}
+
+// errUnchanged tells `update` to leave a structured value as it is.
+var errUnchanged = errors.New("structure is unchanged")
+
+// changeStructure is `updateStructure`, but `fn` also tells if it changed the
+// items, and nothing is written if it didn't.  Unless `create` says so, a
+// member that holds nothing is left alone.
+func (s Store) changeStructure(fullKey string, kind byte, create bool,
+	fn func(items [][]byte) ([][]byte, bool)) error {
+
+	if err := s.coll.checkKey(fullKey); err != nil {
+		return err
+	}
+
+	if s.coll.isCollectionKey(fullKey) {
+		return errorPutIsColl(fullKey, "")
+	}
+
+	coll, key := s.coll.splitKeys(fullKey)
+
+	change := func(old []byte) ([]byte, error) {
+		items, err := decodeStructure(fullKey, kind, old)
+		if err != nil {
+			return nil, err
+		}
+		items, changed := fn(items)
+		if !changed {
+			return nil, errUnchanged
+		}
+		return encodeStructure(kind, items), nil
+	}
+	var err error
+	if create {
+		err = s.coll.update(coll, key, change)
+	} else {
+		_, err = s.coll.updateExisting(coll, key, change)
+	}
+	if err == errUnchanged {
+		return nil
+	}
+	return err
+}
+
+// readStructure returns the items of the structured value of kind `kind` held
+// by member `fullKey`, none if it holds nothing.
+func (s Store) readStructure(fullKey string, kind byte) ([][]byte, error) {
+	val, ok, err := s.Get(fullKey)
+	if err != nil || !ok {
+		return nil, err
+	}
+	return decodeStructure(fullKey, kind, val)
+}
+
+// searchItems returns where `item` is, or would be, among the sorted items
+// that every `stride` items hold.
+func searchItems(items [][]byte, item []byte, stride int) (int, bool) {
+	n := len(items) / stride
+	i := sort.Search(n, func(i int) bool {
+		return bytes.Compare(items[i*stride], item) >= 0
+	})
+	return i * stride, i < n && bytes.Equal(items[i*stride], item)
+}
+
+func encodeStructure(kind byte, items [][]byte) []byte {
+	size := 2 + binary.MaxVarintLen64
+	for _, item := range items {
+		size += binary.MaxVarintLen64 + len(item)
+	}
+	buf := make([]byte, 0, size)
+	buf = append(buf, 0, kind)
+	buf = binary.AppendUvarint(buf, uint64(len(items)))
+	for _, item := range items {
+		buf = binary.AppendUvarint(buf, uint64(len(item)))
+		buf = append(buf, item...)
+	}
+	return buf
+}
+
+// decodeStructure returns the items of structured value `value`, which must be
+// of kind `kind`, or none if it is nil.  They refer to `value`.
+func decodeStructure(fullKey string, kind byte, value []byte) ([][]byte, error) {
+	if value == nil {
+		return nil, nil
+	}
+	if len(value) < 2 || value[0] != 0 || value[1] != kind {
+		return nil, errorWrongKind(fullKey, kindNames[kind])
+	}
+	data := value[2:]
+	count, n := binary.Uvarint(data)
+	if n <= 0 || count > uint64(len(data)) {
+		return nil, errorWrongKind(fullKey, kindNames[kind])
+	}
+	data = data[n:]
+	items := make([][]byte, 0, count)
+	for i := uint64(0); i < count; i++ {
+		length, n := binary.Uvarint(data)
+		if n <= 0 || length > uint64(len(data)-n) {
+			return nil, errorWrongKind(fullKey, kindNames[kind])
+		}
+		items = append(items, data[n:n+int(length)])
+		data = data[n+int(length):]
+	}
+	if len(data) != 0 {
+		return nil, errorWrongKind(fullKey, kindNames[kind])
+	}
+	return items, nil
+}
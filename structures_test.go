@@ -0,0 +1,103 @@
+package dskvs
+
+import (
+	"reflect"
+	"testing"
+)
+
+func strs(values [][]byte) []string {
+	var s []string
+	for _, value := range values {
+		s = append(s, string(value))
+	}
+	return s
+}
+
+func TestList(t *testing.T) {
+	store := setUp(t)
+
+	if n, err := store.LPush("user/ada/inbox", []byte("a"), []byte("b")); err != nil || n != 2 {
+		t.Errorf("Expected a list of 2, was %d, %v", n, err)
+	}
+	store.LPush("user/ada/inbox", []byte("c"))
+	if err := store.Close(); err != nil {
+		t.Fatalf("Error closing store, %v", err)
+	}
+
+	store = setUp(t)
+	defer func() { tearDown(store, t) }()
+	values, _ := store.LRange("user/ada/inbox", 0, -1)
+	if expected := []string{"c", "b", "a"}; !reflect.DeepEqual(expected, strs(values)) {
+		t.Errorf("Expected %v after reopening but was %v", expected, strs(values))
+	}
+	values, _ = store.LRange("user/ada/inbox", -2, 10)
+	if expected := []string{"b", "a"}; !reflect.DeepEqual(expected, strs(values)) {
+		t.Errorf("Expected %v but was %v", expected, strs(values))
+	}
+
+	for _, expected := range []string{"a", "b", "c"} {
+		if value, ok, err := store.RPop("user/ada/inbox"); !ok || err != nil || string(value) != expected {
+			t.Errorf("Expected to pop <%s>, was <%s>, %v, %v", expected, value, ok, err)
+		}
+	}
+	if _, ok, _ := store.RPop("user/ada/inbox"); ok {
+		t.Errorf("Expected nothing to pop from an empty list")
+	}
+}
+
+func TestRPopOnMissingKey(t *testing.T) {
+	store := setUp(t)
+	defer tearDown(store, t)
+
+	if _, ok, err := store.RPop("user/ada/inbox"); ok || err != nil {
+		t.Errorf("Expected nothing to pop, was %v, %v", ok, err)
+	}
+	if _, ok, _ := store.Get("user/ada/inbox"); ok {
+		t.Errorf("Popping a missing key created it")
+	}
+}
+
+func TestSetAndHash(t *testing.T) {
+	store := setUp(t)
+	defer tearDown(store, t)
+
+	if n, _ := store.SAdd("user/ada/tags", []byte("math"), []byte("code"), []byte("math")); n != 2 {
+		t.Errorf("Expected 2 members added, was %d", n)
+	}
+	if n, _ := store.SAdd("user/ada/tags", []byte("code"), []byte("poetry")); n != 1 {
+		t.Errorf("Expected 1 member added, was %d", n)
+	}
+	members, _ := store.SMembers("user/ada/tags")
+	if expected := []string{"code", "math", "poetry"}; !reflect.DeepEqual(expected, strs(members)) {
+		t.Errorf("Expected %v but was %v", expected, strs(members))
+	}
+	if ok, _ := store.SIsMember("user/ada/tags", []byte("poetry")); !ok {
+		t.Errorf("Expected <poetry> in the set")
+	}
+
+	if added, _ := store.HSet("user/ada/profile", "name", []byte("Ada")); !added {
+		t.Errorf("Expected field to be added")
+	}
+	store.HSet("user/ada/profile", "born", []byte("1815"))
+	if added, _ := store.HSet("user/ada/profile", "name", []byte("Ada Lovelace")); added {
+		t.Errorf("Expected field to be replaced")
+	}
+	if value, ok, _ := store.HGet("user/ada/profile", "name"); !ok || string(value) != "Ada Lovelace" {
+		t.Errorf("Expected <Ada Lovelace> but was <%s>", value)
+	}
+	if _, ok, _ := store.HGet("user/ada/profile", "died"); ok {
+		t.Errorf("Expected no such field")
+	}
+
+	// A member holds one kind of value
+	for _, err := range []error{
+		func() error { _, err := store.LPush("user/ada/tags", []byte("x")); return err }(),
+		func() error { _, _, err := store.HGet("user/ada/tags", "x"); return err }(),
+	} {
+		if _, isRightType := err.(ValueError); !isRightType {
+			t.Errorf("Should have returned an error of type ValueError"+
+				", error was %v",
+				err)
+		}
+	}
+}
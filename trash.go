@@ -0,0 +1,313 @@
+package dskvs
+
+import (
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// trashDir is where the janitor moves deleted members when the store has a
+// trash, under the storage path of a store.
+const trashDir = ".trash"
+
+// A trashBin holds the deleted pages whose value the janitor didn't move to
+// the trash yet.
+type trashBin struct {
+	sync.Mutex
+	pending map[string]*page
+}
+
+func newTrashBin() *trashBin {
+	return &trashBin{pending: make(map[string]*page)}
+}
+
+func (b *trashBin) add(aPage *page) {
+	b.Lock()
+	b.pending[joinKeys(aPage.coll, aPage.key)] = aPage
+	b.Unlock()
+}
+
+// done forgets a page once its value is in the trash, unless another page of
+// the same key was deleted since.
+func (b *trashBin) done(aPage *page) {
+	b.Lock()
+	if b.pending[joinKeys(aPage.coll, aPage.key)] == aPage {
+		delete(b.pending, joinKeys(aPage.coll, aPage.key))
+	}
+	b.Unlock()
+}
+
+func (b *trashBin) get(fullKey string) *page {
+	b.Lock()
+	aPage := b.pending[fullKey]
+	b.Unlock()
+	return aPage
+}
+
+// keys returns the keys of the pending pages of collection `coll` and, if
+// `within` says so, of the collections within it, by collection.
+func (b *trashBin) keys(coll string, within bool) map[string][]string {
+	b.Lock()
+	defer b.Unlock()
+	keys := make(map[string][]string)
+	for _, aPage := range b.pending {
+		if aPage.coll == coll || within && strings.HasPrefix(aPage.coll, coll+CollKeySep) {
+			keys[aPage.coll] = append(keys[aPage.coll], aPage.key)
+		}
+	}
+	return keys
+}
+
+// trashFilename is where the value of a deleted page is kept.
+func trashFilename(basepath, coll string, aPage *page) string {
+	return filepath.Join(basepath, trashDir, coll,
+		filepath.Base(generateFilename(aPage)))
+}
+
+// trashPage has the janitor write the value of a deleted page to the trash,
+// in the fileformat of page files.  The page is held meanwhile, so that an
+// undelete either takes the value before it is written, or finds it in the
+// trash.
+func trashPage(aPage *page) error {
+	if aPage.bin == nil {
+		return nil
+	}
+
+	aPage.Lock()
+	if aPage.trashed == nil {
+		aPage.Unlock()
+		return nil
+	}
+	filename := trashFilename(aPage.basepath, aPage.coll, aPage)
+	data, _, err := encodePage(&page{
+		basepath: aPage.basepath,
+		coll:     aPage.coll,
+		key:      aPage.key,
+		value:    aPage.trashed,
+		comp:     aPage.comp,
+		packed:   aPage.trashedPacked,
+		keys:     aPage.keys,
+	})
+	if err == nil {
+		err = os.MkdirAll(filepath.Dir(filename), DIR_PERM)
+	}
+	if err == nil {
+		err = writeFileAtomic(filename, data)
+	}
+	if err != nil {
+		aPage.Unlock()
+		log.Printf("Couldn't move page to trash <%s> : %v", filename, err)
+		return err
+	}
+	aPage.trashed = nil
+	aPage.Unlock()
+
+	aPage.bin.done(aPage)
+	return nil
+}
+
+// loadTrash renames the files in the trash that are named after a member key
+// starting with CollKeySep, as files written before fileformat 0.8 were.
+func (j *janitor) loadTrash(s *Store) {
+	basepath := filepath.Join(s.storagePath, trashDir)
+	keys := s.opts.keyProvider()
+	_ = filepath.Walk(basepath, func(filename string, info os.FileInfo, err error) error {
+		if err != nil || !info.Mode().IsRegular() {
+			return nil
+		}
+		data, err := ioutil.ReadFile(filename)
+		if err != nil {
+			log.Printf("Error reading file <%s> : %v", filename, err)
+			return nil
+		}
+		if version, err := fileVersion(data); err != nil || !hasLegacyKey(version) {
+			return nil
+		}
+		aPage, err := decodePageFile(filename, data, keys)
+		if err != nil {
+			log.Printf("\t... skipping, error reading trashed file: %v", err)
+			return nil
+		}
+		coll, err := filepath.Rel(basepath, filepath.Dir(filename))
+		if err == nil {
+			relocate(filename, trashFilename(s.storagePath, coll, aPage))
+		}
+		return nil
+	})
+}
+
+// Undelete brings back member `fullKey` from the trash, with the value it had
+// when it was last deleted.  The store must have a trash, as `Options.Trash`
+// says, and the member must not have been put again since.
+func (s Store) Undelete(fullKey string) error {
+
+	if err := s.coll.checkKey(fullKey); err != nil {
+		return err
+	}
+
+	if s.coll.isCollectionKey(fullKey) {
+		return errorDeleteIsColl(fullKey)
+	}
+
+	if s.opts.Trash <= 0 {
+		return errorStoreNoTrash()
+	}
+
+	coll, key := s.coll.splitKeys(fullKey)
+	return s.undelete(coll, key)
+}
+
+// RestoreCollection brings back from the trash every member of collection
+// `coll` that was deleted and not put again since, and returns how many.  With
+// nested collections, those of the collections within it come back along.
+func (s Store) RestoreCollection(coll string) (int, error) {
+
+	if err := s.coll.checkKey(coll); err != nil {
+		return 0, err
+	}
+
+	if !s.coll.isCollectionKey(coll) {
+		return 0, errorDeleteAllIsNotColl(coll)
+	}
+
+	if s.opts.Trash <= 0 {
+		return 0, errorStoreNoTrash()
+	}
+
+	coll = s.coll.collName(coll)
+	trashed := make(map[string]map[string]bool)
+	for c, keys := range s.coll.bin.keys(coll, s.opts.Nested) {
+		for _, key := range keys {
+			addTrashed(trashed, c, key)
+		}
+	}
+	if err := s.listTrash(coll, trashed); err != nil {
+		return 0, err
+	}
+
+	restored := 0
+	for c, keys := range trashed {
+		for key := range keys {
+			err := s.undelete(c, key)
+			if _, exists := err.(KeyError); exists {
+				// Put again since, the new value wins
+				continue
+			} else if err != nil {
+				return restored, err
+			}
+			restored++
+		}
+	}
+	return restored, nil
+}
+
+// listTrash adds the members of collection `coll` found in the trash to
+// `trashed` and, with nested collections, those of the collections within it.
+func (s Store) listTrash(coll string, trashed map[string]map[string]bool) error {
+	dir := filepath.Join(s.storagePath, trashDir, coll)
+	files, err := ioutil.ReadDir(dir)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	for _, file := range files {
+		if file.IsDir() {
+			// The trash of a collection within this one
+			if s.opts.Nested {
+				if err := s.listTrash(coll+CollKeySep+file.Name(), trashed); err != nil {
+					return err
+				}
+			}
+			continue
+		}
+		aPage, err := readPageFile(filepath.Join(dir, file.Name()), s.opts.keyProvider())
+		if err != nil {
+			return err
+		}
+		addTrashed(trashed, coll, aPage.key)
+	}
+	return nil
+}
+
+func addTrashed(trashed map[string]map[string]bool, coll, key string) {
+	if trashed[coll] == nil {
+		trashed[coll] = make(map[string]bool)
+	}
+	trashed[coll][key] = true
+}
+
+// undelete puts back the value of a deleted member, then empties its place in
+// the trash.  Should it crash in between, the value is both in the store and
+// in the trash until it expires.
+func (s Store) undelete(coll, key string) error {
+	filename := trashFilename(s.storagePath, coll, &page{key: key})
+
+	var value []byte
+	pending := s.coll.bin.get(joinKeys(coll, key))
+	if pending != nil {
+		pending.RLock()
+		if pending.trashed != nil {
+			value = pending.unpacked(pending.trashed, pending.trashedPacked)
+		}
+		pending.RUnlock()
+	}
+	if value == nil {
+		aPage, err := readPageFile(filename, s.opts.keyProvider())
+		if os.IsNotExist(err) {
+			return errorNoSuchKey(joinKeys(coll, key))
+		} else if err != nil {
+			return err
+		}
+		value = aPage.value
+	}
+
+	if ok, err := s.coll.putNew(coll, key, value); err != nil {
+		return err
+	} else if !ok {
+		return errorKeyExists(joinKeys(coll, key))
+	}
+
+	if pending != nil {
+		pending.Lock()
+		pending.trashed = nil
+		pending.Unlock()
+		s.coll.bin.done(pending)
+	}
+	return deleteFile(filename)
+}
+
+// purgeTrash deletes the members that have been in the trash for longer than
+// the store keeps them at time `now`.
+func (s *Store) purgeTrash(now time.Time) {
+	if s.opts.Trash <= 0 {
+		return
+	}
+	expired := now.Add(-s.opts.Trash)
+
+	basepath := filepath.Join(s.storagePath, trashDir)
+	var dirs []string
+	_ = filepath.Walk(basepath, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			if !os.IsNotExist(err) {
+				log.Printf("Can't list trash directory <%s> : %v", path, err)
+			}
+			return nil
+		}
+		if info.IsDir() {
+			if path != basepath {
+				dirs = append(dirs, path)
+			}
+		} else if info.ModTime().Before(expired) {
+			_ = deleteFile(path)
+		}
+		return nil
+	})
+	// Collections within others come after them, and only succeed once
+	// empty
+	for i := len(dirs) - 1; i >= 0; i-- {
+		_ = os.Remove(dirs[i])
+	}
+}
@@ -0,0 +1,76 @@
+package dskvs
+
+import (
+	"testing"
+	"time"
+)
+
+func TestUndeleteAndRestoreCollection(t *testing.T) {
+	opts := Options{Trash: time.Hour}
+	store, _, err := OpenWith("./db", opts)
+	if err != nil {
+		t.Fatalf("Error opening store, %v", err)
+	}
+	fillStore(store, backupData, t)
+
+	store.Delete("artist/justice")
+	if err := store.Undelete("artist/justice"); err != nil {
+		t.Fatalf("Error undeleting member, %v", err)
+	}
+	if val, ok, _ := store.Get("artist/justice"); !ok || string(val) != "Cross" {
+		t.Errorf("Expected member back, was <%s>, ok=%v", val, ok)
+	}
+
+	store.DeleteAll("artist")
+	if err := store.Close(); err != nil {
+		t.Fatalf("Error closing store, %v", err)
+	}
+
+	// The trash outlives the store
+	store, _, err = OpenWith("./db", opts)
+	if err != nil {
+		t.Fatalf("Error opening store, %v", err)
+	}
+	defer func() { tearDown(store, t) }()
+	if values, _ := store.GetAll("artist"); len(values) != 0 {
+		t.Fatalf("Expected collection to be deleted, was %v", values)
+	}
+
+	store.Put("artist/justice", []byte("Audio, Video, Disco"))
+	restored, err := store.RestoreCollection("artist")
+	if err != nil || restored != 1 {
+		t.Errorf("Expected 1 member restored, was %d, %v", restored, err)
+	}
+	expected := map[string]string{
+		"artist/daft_punk": "Discovery",
+		"artist/justice":   "Audio, Video, Disco",
+	}
+	for key, value := range expected {
+		if val, _, _ := store.Get(key); string(val) != value {
+			t.Errorf("Expected <%s> at %s but was <%s>", value, key, val)
+		}
+	}
+
+	store.Delete("album/homework")
+	store.Close()
+	store, _, _ = OpenWith("./db", opts)
+	store.purgeTrash(time.Now().Add(2 * time.Hour))
+	err = store.Undelete("album/homework")
+	if _, isRightType := err.(KeyError); !isRightType {
+		t.Errorf("Expected trash to be purged, error was %v", err)
+	}
+}
+
+func TestErrorWhenStoreHasNoTrash(t *testing.T) {
+	store := setUp(t)
+	defer tearDown(store, t)
+	store.Put("artist/air", []byte("Moon Safari"))
+	store.Delete("artist/air")
+
+	err := store.Undelete("artist/air")
+	if _, isRightType := err.(StoreError); !isRightType {
+		t.Errorf("Should have returned an error of type StoreError"+
+			", error was %v",
+			err)
+	}
+}
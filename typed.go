@@ -0,0 +1,143 @@
+package dskvs
+
+import (
+	"bytes"
+	"iter"
+	"log"
+	"sync"
+)
+
+// A TypedCollection is a `Collection` whose values are of type T, encoded by a
+// `Codec`.  It can cache the values it decodes, so that hot reads skip
+// decoding them again.  It's safe for concurrent use.
+type TypedCollection[T any] struct {
+	coll  *Collection
+	codec Codec[T]
+
+	// cache holds at most cacheSize decoded values, with the bytes that
+	// they were decoded from.  A cached value is only used while the store
+	// holds the same bytes, so that it can't go stale.
+	cacheSize int
+	cacheLock sync.Mutex
+	cache     map[string]decoded[T]
+}
+
+type decoded[T any] struct {
+	data  []byte
+	value T
+}
+
+// NewTypedCollection returns a typed handle on collection `coll` of store `s`,
+// encoding values with `codec`.  Up to `cacheSize` decoded values are kept in
+// memory; zero disables the cache.
+//
+// ATTENTION : cached values are shared between the reads that return them, do
+// not modify them.
+func NewTypedCollection[T any](s *Store, coll string, codec Codec[T], cacheSize int) *TypedCollection[T] {
+	return &TypedCollection[T]{
+		coll:      s.Collection(coll),
+		codec:     codec,
+		cacheSize: cacheSize,
+		cache:     make(map[string]decoded[T]),
+	}
+}
+
+// Collection returns the untyped handle on the collection.
+func (tc *TypedCollection[T]) Collection() *Collection {
+	return tc.coll
+}
+
+// Get returns the value of member `key`, decoded.
+func (tc *TypedCollection[T]) Get(key string) (T, bool, error) {
+	var zero T
+	data, ok, err := tc.coll.Get(key)
+	if err != nil || !ok {
+		tc.forget(key)
+		return zero, ok, err
+	}
+	value, err := tc.decode(key, data)
+	if err != nil {
+		return zero, false, err
+	}
+	return value, true, nil
+}
+
+// Put encodes `value` and saves it as member `key`.
+func (tc *TypedCollection[T]) Put(key string, value T) error {
+	data, err := tc.codec.Encode(value)
+	if err != nil {
+		return errorEncoding(joinKeys(tc.coll.Name(), key), err)
+	}
+	if err := tc.coll.Put(key, data); err != nil {
+		return err
+	}
+	tc.remember(key, data, value)
+	return nil
+}
+
+// Delete removes member `key`.
+func (tc *TypedCollection[T]) Delete(key string) error {
+	tc.forget(key)
+	return tc.coll.Delete(key)
+}
+
+// All iterates over the members of the collection and their decoded value,
+// sorted by member, like `Collection.Iterate`.  Values that can't be decoded
+// are logged and skipped.
+func (tc *TypedCollection[T]) All() iter.Seq2[string, T] {
+	return func(yield func(string, T) bool) {
+		tc.coll.Iterate(func(key string, data []byte) bool {
+			value, err := tc.decode(key, data)
+			if err != nil {
+				log.Printf("Skipping member : %v", err)
+				return true
+			}
+			return yield(key, value)
+		})
+	}
+}
+
+// decode returns the value that `data` encodes, from the cache if it holds it.
+func (tc *TypedCollection[T]) decode(key string, data []byte) (T, error) {
+	if tc.cacheSize > 0 {
+		tc.cacheLock.Lock()
+		cached, ok := tc.cache[key]
+		tc.cacheLock.Unlock()
+		if ok && bytes.Equal(cached.data, data) {
+			return cached.value, nil
+		}
+	}
+
+	value, err := tc.codec.Decode(data)
+	if err != nil {
+		var zero T
+		return zero, errorDecoding(joinKeys(tc.coll.Name(), key), err)
+	}
+	tc.remember(key, data, value)
+	return value, nil
+}
+
+func (tc *TypedCollection[T]) remember(key string, data []byte, value T) {
+	if tc.cacheSize <= 0 {
+		return
+	}
+	tc.cacheLock.Lock()
+	if _, ok := tc.cache[key]; !ok && len(tc.cache) >= tc.cacheSize {
+		// Make room by forgetting any one of them
+		for other := range tc.cache {
+			delete(tc.cache, other)
+			break
+		}
+	}
+	tc.cache[key] = decoded[T]{data, value}
+	tc.cacheLock.Unlock()
+}
+
+func (tc *TypedCollection[T]) forget(key string) {
+	if tc.cacheSize <= 0 {
+		return
+	}
+	tc.cacheLock.Lock()
+	delete(tc.cache, key)
+	tc.cacheLock.Unlock()
+}
@@ -0,0 +1,70 @@
+package dskvs
+
+import (
+	"reflect"
+	"testing"
+)
+
+type album struct {
+	Title string
+	Year  int
+}
+
+func TestTypedCollection(t *testing.T) {
+	store := setUp(t)
+	defer tearDown(store, t)
+
+	for _, codec := range []Codec[album]{JSONCodec[album](), GobCodec[album]()} {
+		for _, cacheSize := range []int{0, 1} {
+			albums := NewTypedCollection(store, "album", codec, cacheSize)
+			albums.Put("discovery", album{"Discovery", 2001})
+			albums.Put("homework", album{"Homework", 1997})
+
+			if value, ok, err := albums.Get("discovery"); !ok || err != nil || value.Year != 2001 {
+				t.Errorf("Expected Discovery from 2001, was %v, %v, %v", value, ok, err)
+			}
+			// Changes made without the typed collection are seen
+			data, _ := codec.Encode(album{"Homework", 1996})
+			store.Put("album/homework", data)
+			if value, _, _ := albums.Get("homework"); value.Year != 1996 {
+				t.Errorf("Expected value put in the store, was %v", value)
+			}
+
+			var titles []string
+			for key, value := range albums.All() {
+				if key == "homework" {
+					break
+				}
+				titles = append(titles, value.Title)
+			}
+			if !reflect.DeepEqual([]string{"Discovery"}, titles) {
+				t.Errorf("Expected to stop after Discovery, had %v", titles)
+			}
+
+			albums.Delete("homework")
+			if _, ok, _ := albums.Get("homework"); ok {
+				t.Errorf("Expected deleted member to be gone")
+			}
+			store.DeleteAll("album")
+		}
+	}
+}
+
+func TestErrorWhenTypedValueCantBeDecoded(t *testing.T) {
+	store := setUp(t)
+	defer tearDown(store, t)
+
+	store.Put("album/discovery", []byte("not json"))
+	albums := NewTypedCollection(store, "album", JSONCodec[album](), 10)
+	_, _, err := albums.Get("discovery")
+	if _, isRightType := err.(CodecError); !isRightType {
+		t.Errorf("Should have returned an error of type CodecError"+
+			", error was %v",
+			err)
+	}
+
+	raw := NewTypedCollection(store, "album", RawCodec, 0)
+	if value, _, _ := raw.Get("discovery"); string(value) != "not json" {
+		t.Errorf("Expected raw bytes, was <%s>", value)
+	}
+}
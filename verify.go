@@ -0,0 +1,292 @@
+package dskvs
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// A ProblemKind classifies what `Verify` found wrong on disk.
+type ProblemKind int
+
+const (
+	// ValueMismatch means the page file holds another key or value than
+	// the page in memory.
+	ValueMismatch ProblemKind = iota
+	// CorruptFile means the page file can't be decoded, for instance
+	// because it fails its checksum.
+	CorruptFile
+	// MissingFile means a page that has no pending write has no file.
+	MissingFile
+	// OrphanFile means a file has no page in memory.
+	OrphanFile
+	// OrphanCollection means a directory has no collection in memory.
+	OrphanCollection
+)
+
+func (k ProblemKind) String() string {
+	switch k {
+	case ValueMismatch:
+		return "value mismatch"
+	case CorruptFile:
+		return "corrupt file"
+	case MissingFile:
+		return "missing file"
+	case OrphanFile:
+		return "orphan file"
+	case OrphanCollection:
+		return "orphan collection"
+	}
+	return "unknown problem"
+}
+
+// A Problem is a difference between the content of a store in memory and on
+// disk.
+type Problem struct {
+	Kind     ProblemKind
+	Filename string
+	// Key is the full key of the member concerned, when it is known.
+	Key    string
+	Reason error
+	// Repaired is set by `Repair` once the problem has been fixed.
+	Repaired bool
+}
+
+// A VerifyReport lists the problems found by `Verify` or `Repair`.
+type VerifyReport struct {
+	// Checked is how many page files were read.
+	Checked  int
+	Problems []Problem
+}
+
+// Verify re-reads every page file of the store and compares it with the value
+// held in memory.  It reports values that differ, files that are corrupted or
+// missing, and files or collection directories that have no counterpart in
+// memory.  Pages with a pending write are not verified, the janitor is about
+// to rewrite them anyway.
+//
+// Verify returns early with ctx.Err() if `ctx` is done, along with the
+// problems found so far.
+func (s *Store) Verify(ctx context.Context) (*VerifyReport, error) {
+	if s == nil {
+		return nil, errorStoreNotLoaded()
+	}
+	return s.verify(ctx, false)
+}
+
+// Repair verifies the store like `Verify` does, and fixes the problems it
+// finds with memory as the source of truth: pages are written again, and
+// orphan files and directories are removed, or moved to the quarantine if the
+// store was opened with `Options.Quarantine`.
+func (s *Store) Repair(ctx context.Context) (*VerifyReport, error) {
+	if s == nil {
+		return nil, errorStoreNotLoaded()
+	}
+	return s.verify(ctx, true)
+}
+
+func (s *Store) verify(ctx context.Context, repair bool) (*VerifyReport, error) {
+	report := new(VerifyReport)
+
+	members := s.coll.snapshot()
+
+	for coll, m := range members {
+		if err := s.verifyMember(ctx, coll, m, members, report, repair); err != nil {
+			return report, err
+		}
+	}
+
+	possibleColl, err := ioutil.ReadDir(s.storagePath)
+	if os.IsNotExist(err) {
+		return report, nil
+	} else if err != nil {
+		return report, err
+	}
+	for _, dir := range possibleColl {
+		if !dir.IsDir() || isReservedColl(dir.Name()) {
+			continue
+		}
+		if _, ok := members[dir.Name()]; ok {
+			continue
+		}
+		if _, ok := s.coll.member(dir.Name()); ok {
+			// Created since we started
+			continue
+		}
+		problem := Problem{
+			Kind:     OrphanCollection,
+			Filename: filepath.Join(s.storagePath, dir.Name()),
+		}
+		if repair {
+			problem.Reason = s.discard("", problem.Filename)
+			problem.Repaired = problem.Reason == nil
+		}
+		report.Problems = append(report.Problems, problem)
+	}
+
+	return report, nil
+}
+
+func (s *Store) verifyMember(ctx context.Context, coll string, m *member,
+	members map[string]*member, report *VerifyReport, repair bool) error {
+
+	// Snapshot the pages we expect to find on disk, by filename
+	expected := make(map[string]*page)
+	for _, aPage := range m.pages() {
+		expected[generateFilename(aPage)] = aPage
+	}
+
+	collPath := filepath.Join(s.storagePath, coll)
+	possiblePage, err := ioutil.ReadDir(collPath)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	for _, file := range possiblePage {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if file.IsDir() && s.opts.Nested {
+			s.verifyChild(coll, file.Name(), members, report, repair)
+			continue
+		}
+		if !file.Mode().IsRegular() || strings.HasSuffix(file.Name(), tempSuffix) {
+			continue
+		}
+		filename := filepath.Join(collPath, file.Name())
+		aPage, ok := expected[filename]
+		delete(expected, filename)
+
+		report.Checked++
+		if ok {
+			s.verifyPage(aPage, filename, report, repair)
+			continue
+		}
+
+		// No page should have this file, unless it was created since our
+		// snapshot
+		data, err := ioutil.ReadFile(filename)
+		if os.IsNotExist(err) {
+			continue
+		}
+		problem := Problem{Kind: OrphanFile, Filename: filename}
+		if err == nil {
+			var onDisk *page
+			onDisk, err = decodePageFile(filename, data, s.opts.keyProvider())
+			if err == nil {
+				problem.Key = joinKeys(coll, onDisk.key)
+				if _, ok := m.get(onDisk.key); ok {
+					continue
+				}
+			}
+		}
+		problem.Reason = err
+		if repair {
+			problem.Reason = s.discard(coll, filename)
+			problem.Repaired = problem.Reason == nil
+		}
+		report.Problems = append(report.Problems, problem)
+	}
+
+	for filename, aPage := range expected {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		s.verifyPage(aPage, filename, report, repair)
+	}
+	return nil
+}
+
+// verifyChild reports directory `name` of collection `coll` if it is the
+// directory of no collection within it.  With nested collections, those have
+// their own member, which verifies their directory.
+func (s *Store) verifyChild(coll, name string, members map[string]*member,
+	report *VerifyReport, repair bool) {
+
+	child := coll + CollKeySep + name
+	if _, ok := members[child]; ok {
+		return
+	}
+	if _, ok := s.coll.member(child); ok {
+		// Created since we started
+		return
+	}
+	problem := Problem{
+		Kind:     OrphanCollection,
+		Filename: filepath.Join(s.storagePath, coll, name),
+	}
+	if repair {
+		problem.Reason = s.discard(coll, problem.Filename)
+		problem.Repaired = problem.Reason == nil
+	}
+	report.Problems = append(report.Problems, problem)
+}
+
+// verifyPage compares a page with its file, and reports whether the page
+// was found to be in a bad state.
+func (s *Store) verifyPage(aPage *page, filename string, report *VerifyReport,
+	repair bool) bool {
+
+	problem, ok := checkPageFile(aPage, filename)
+	if ok {
+		return false
+	}
+	if repair {
+		aPage.touch()
+		problem.Repaired = true
+	}
+	report.Problems = append(report.Problems, problem)
+	return true
+}
+
+// checkPageFile reads the file of a page and tells whether it holds the same
+// key and value as the page.  Pages that will be written by the janitor are
+// always considered fine, and so are those that were never given a value,
+// which must never be written.
+func checkPageFile(aPage *page, filename string) (Problem, bool) {
+	aPage.RLock()
+	pending := aPage.isDirty || aPage.isDeleted || aPage.value == nil
+	stored := aPage.value
+	value := aPage.plainValue()
+	aPage.RUnlock()
+	if pending {
+		return Problem{}, true
+	}
+
+	problem := Problem{Filename: filename, Key: joinKeys(aPage.coll, aPage.key)}
+
+	data, err := ioutil.ReadFile(filename)
+	if os.IsNotExist(err) {
+		problem.Kind = MissingFile
+	} else if err != nil {
+		problem.Kind = CorruptFile
+		problem.Reason = err
+	} else if onDisk, err := decodePageFile(filename, data, aPage.keys); err != nil {
+		problem.Kind = CorruptFile
+		problem.Reason = err
+	} else if onDisk.key == aPage.key && bytes.Equal(onDisk.value, value) {
+		return Problem{}, true
+	} else {
+		problem.Kind = ValueMismatch
+	}
+
+	// The page could have been written while we were reading the file
+	aPage.RLock()
+	changed := aPage.isDirty || aPage.isDeleted || !bytes.Equal(aPage.value, stored)
+	aPage.RUnlock()
+	return problem, changed
+}
+
+// discard gets rid of a file or directory that has no counterpart in memory.
+// Top collection directories are given an empty `coll`, and those of
+// collections within another the collection they are in.
+func (s *Store) discard(coll, filename string) error {
+	if s.opts.Quarantine {
+		_, err := quarantineFile(s.storagePath, coll, filename)
+		return err
+	}
+	return os.RemoveAll(filename)
+}
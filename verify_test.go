@@ -0,0 +1,191 @@
+package dskvs
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func countProblems(report *VerifyReport) map[ProblemKind]int {
+	kinds := make(map[ProblemKind]int)
+	for _, problem := range report.Problems {
+		kinds[problem.Kind]++
+	}
+	return kinds
+}
+
+func TestVerifyAndRepair(t *testing.T) {
+	store := setUp(t)
+	keys := []string{"artist/daft_punk", "artist/justice", "artist/air"}
+	for _, key := range keys {
+		if err := store.Put(key, []byte(key)); err != nil {
+			t.Fatalf("Error putting data in, %v", err)
+		}
+	}
+	// Reopen so that every page is known to be on disk
+	if err := store.Close(); err != nil {
+		t.Fatalf("Error closing store, %v", err)
+	}
+	store = setUp(t)
+
+	ctx := context.Background()
+	report, err := store.Verify(ctx)
+	if err != nil {
+		t.Fatalf("Error verifying store, %v", err)
+	}
+	if report.Checked != len(keys) {
+		t.Errorf("Expected %d files checked but was %d",
+			len(keys), report.Checked)
+	}
+	if len(report.Problems) != 0 {
+		t.Fatalf("Expected no problem on a healthy store, got %v",
+			report.Problems)
+	}
+
+	m, _ := store.coll.member("artist")
+	daftPunk := m.entries["daft_punk"]
+	justice := m.entries["justice"]
+	air := m.entries["air"]
+
+	collPath := filepath.Join(store.storagePath, "artist")
+	orphanDir := filepath.Join(store.storagePath, "deleted_by_hand")
+	for filename, data := range map[string][]byte{
+		generateFilename(daftPunk):         {0xDE, 0xAD, 0xBE, 0xEF},
+		filepath.Join(collPath, "orphan"):  []byte("not a page"),
+		filepath.Join(orphanDir, "orphan"): []byte("not a page"),
+		generateFilename(air) + tempSuffix: []byte("ignored"),
+	} {
+		os.MkdirAll(filepath.Dir(filename), DIR_PERM)
+		if err := ioutil.WriteFile(filename, data, FILE_PERM); err != nil {
+			t.Fatalf("Couldn't write file <%s> : %v", filename, err)
+		}
+	}
+	airBytes, _ := fromPageToBytes(justice)
+	if err := ioutil.WriteFile(generateFilename(air), airBytes, FILE_PERM); err != nil {
+		t.Fatalf("Couldn't overwrite file, %v", err)
+	}
+	os.Remove(generateFilename(justice))
+
+	report, err = store.Verify(ctx)
+	if err != nil {
+		t.Fatalf("Error verifying store, %v", err)
+	}
+	expected := map[ProblemKind]int{
+		CorruptFile:      1,
+		MissingFile:      1,
+		ValueMismatch:    1,
+		OrphanFile:       1,
+		OrphanCollection: 1,
+	}
+	actual := countProblems(report)
+	for kind, n := range expected {
+		if actual[kind] != n {
+			t.Errorf("Expected %d %v but was %d", n, kind, actual[kind])
+		}
+	}
+
+	report, err = store.Repair(ctx)
+	if err != nil {
+		t.Fatalf("Error repairing store, %v", err)
+	}
+	for _, problem := range report.Problems {
+		if !problem.Repaired {
+			t.Errorf("Problem should have been repaired, %v", problem)
+		}
+	}
+
+	// Closing waits for the janitor to rewrite the pages
+	if err := store.Close(); err != nil {
+		t.Fatalf("Error closing store, %v", err)
+	}
+	store = setUp(t)
+	defer tearDown(store, t)
+
+	report, err = store.Verify(ctx)
+	if err != nil {
+		t.Fatalf("Error verifying store, %v", err)
+	}
+	if len(report.Problems) != 0 {
+		t.Errorf("Expected no problem after repair, got %v", report.Problems)
+	}
+	if _, err := os.Stat(orphanDir); !os.IsNotExist(err) {
+		t.Errorf("Orphan collection should have been removed, %v", err)
+	}
+}
+
+func TestVerifyStopsWhenContextIsDone(t *testing.T) {
+	store := setUp(t)
+	defer tearDown(store, t)
+
+	if err := store.Put("artist/daft_punk", []byte("Alive")); err != nil {
+		t.Fatalf("Error putting data in, %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := store.Verify(ctx); err != context.Canceled {
+		t.Errorf("Expected error %v but was %v", context.Canceled, err)
+	}
+}
+
+func TestVerifySkipsPagesWithoutValue(t *testing.T) {
+	store := setUp(t)
+	defer tearDown(store, t)
+	store.Put("artist/daft_punk", []byte("Discovery"))
+
+	// As a write that didn't go through would leave it
+	stray := store.coll.memberOrNew("artist").pageOrNew("stray")
+
+	report, err := store.Repair(context.Background())
+	if err != nil {
+		t.Fatalf("Error repairing store, %v", err)
+	}
+	if len(report.Problems) != 0 {
+		t.Errorf("Expected no problem, got %v", report.Problems)
+	}
+	if _, err := os.Stat(generateFilename(stray)); !os.IsNotExist(err) {
+		t.Errorf("Page without value was written, %v", err)
+	}
+}
+
+func TestVerifyNestedOrphanCollection(t *testing.T) {
+	store := openNested(t)
+	fillStore(store, nestedData, t)
+	if err := store.Close(); err != nil {
+		t.Fatalf("Error closing store, %v", err)
+	}
+	store = openNested(t)
+	defer func() { tearDown(store, t) }()
+
+	orphanDir := filepath.Join(store.storagePath, "artist", "deleted_by_hand")
+	os.MkdirAll(orphanDir, DIR_PERM)
+	filename := filepath.Join(orphanDir, "orphan")
+	if err := ioutil.WriteFile(filename, []byte("not a page"), FILE_PERM); err != nil {
+		t.Fatalf("Couldn't write file <%s> : %v", filename, err)
+	}
+
+	ctx := context.Background()
+	report, err := store.Verify(ctx)
+	if err != nil {
+		t.Fatalf("Error verifying store, %v", err)
+	}
+	if len(report.Problems) != 1 || report.Problems[0].Kind != OrphanCollection ||
+		report.Problems[0].Filename != orphanDir {
+		t.Fatalf("Expected only %s as orphan collection, got %v",
+			orphanDir, report.Problems)
+	}
+
+	report, err = store.Repair(ctx)
+	if err != nil {
+		t.Fatalf("Error repairing store, %v", err)
+	}
+	if len(report.Problems) != 1 || !report.Problems[0].Repaired {
+		t.Errorf("Orphan collection should have been repaired, %v", report.Problems)
+	}
+	if _, err := os.Stat(orphanDir); !os.IsNotExist(err) {
+		t.Errorf("Orphan collection should have been removed, %v", err)
+	}
+	checkGetAll(store, "artist/daft_punk/", []string{"1997", "2001"}, t)
+}